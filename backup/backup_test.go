@@ -0,0 +1,195 @@
+package backup_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/LINBIT/golinstor/backup"
+	"github.com/LINBIT/golinstor/client"
+)
+
+func ts(t time.Time) *client.TimeStampMs {
+	return &client.TimeStampMs{Time: t}
+}
+
+func ids(nodes []*backup.Node) []string {
+	result := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		result = append(result, n.ID)
+	}
+	return result
+}
+
+type fakeBackups struct {
+	client.BackupProvider
+	deleted []client.BackupDeleteOpts
+}
+
+func (f *fakeBackups) DeleteAll(_ context.Context, _ string, filter client.BackupDeleteOpts) error {
+	f.deleted = append(f.deleted, filter)
+	return nil
+}
+
+func TestBuildGraphs(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	list := &client.BackupList{Linstor: map[string]client.Backup{
+		"full1":   {Id: "full1", OriginRsc: "rsc1", Success: true, Restorable: true, FinishedTimestamp: ts(base)},
+		"inc1":    {Id: "inc1", OriginRsc: "rsc1", Success: true, Restorable: true, BasedOnId: "full1", FinishedTimestamp: ts(base.Add(time.Hour))},
+		"failed":  {Id: "failed", OriginRsc: "rsc1", BasedOnId: "inc1", FinishedTimestamp: ts(base.Add(2 * time.Hour))},
+		"inc3":    {Id: "inc3", OriginRsc: "rsc1", Success: true, Restorable: true, BasedOnId: "failed", FinishedTimestamp: ts(base.Add(3 * time.Hour))},
+		"orphan":  {Id: "orphan", OriginRsc: "rsc1", Success: true, Restorable: true, BasedOnId: "gone", FinishedTimestamp: ts(base.Add(4 * time.Hour))},
+		"loop1":   {Id: "loop1", OriginRsc: "rsc1", Success: true, Restorable: true, BasedOnId: "loop2", FinishedTimestamp: ts(base.Add(5 * time.Hour))},
+		"loop2":   {Id: "loop2", OriginRsc: "rsc1", Success: true, Restorable: true, BasedOnId: "loop1", FinishedTimestamp: ts(base.Add(6 * time.Hour))},
+		"running": {Id: "running", OriginRsc: "rsc1", Shipping: true, BasedOnId: "inc1", StartTimestamp: ts(base.Add(7 * time.Hour))},
+		"other":   {Id: "other", OriginRsc: "rsc2", Success: true, Restorable: true, FinishedTimestamp: ts(base)},
+		// Successful, but the controller reports it cannot be restored.
+		"unrestorable": {Id: "unrestorable", OriginRsc: "rsc1", Success: true, BasedOnId: "inc1", FinishedTimestamp: ts(base.Add(8 * time.Hour))},
+		"inc9":         {Id: "inc9", OriginRsc: "rsc1", Success: true, Restorable: true, BasedOnId: "unrestorable", FinishedTimestamp: ts(base.Add(9 * time.Hour))},
+	}}
+
+	graphs := backup.BuildGraphs(list)
+	require.Len(t, graphs, 2)
+
+	g := graphs["rsc1"]
+	assert.Equal(t, []string{"full1"}, ids(g.Fulls))
+	assert.Equal(t, []string{"failed", "inc3", "unrestorable", "inc9"}, ids(g.Broken))
+	assert.Equal(t, []string{"orphan", "loop1", "loop2"}, ids(g.Orphaned))
+	assert.Equal(t, []string{"full1", "inc1", "failed", "inc3"}, ids(g.Nodes["inc3"].Chain()))
+	assert.True(t, g.Nodes["inc1"].Restorable())
+	assert.False(t, g.Nodes["running"].Restorable())
+	assert.False(t, g.Nodes["running"].Broken)
+	assert.False(t, g.Nodes["unrestorable"].Restorable())
+	assert.False(t, g.Nodes["inc9"].Restorable())
+}
+
+func TestPlan(t *testing.T) {
+	// One full backup per week, daily incremental backups, for 4 weeks starting on a Monday.
+	start := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	list := &client.BackupList{Linstor: map[string]client.Backup{}}
+	var prev string
+	for day := 0; day < 28; day++ {
+		id := start.AddDate(0, 0, day).Format("0102")
+		b := client.Backup{Id: id, OriginRsc: "rsc", Success: true, Restorable: true, FinishedTimestamp: ts(start.AddDate(0, 0, day))}
+		if day%7 != 0 {
+			b.BasedOnId = prev
+		}
+		list.Linstor[id] = b
+		prev = id
+	}
+
+	g := backup.BuildGraphs(list)["rsc"]
+
+	plan := g.Plan(backup.Policy{Daily: 2, Weekly: 2, Location: time.UTC})
+
+	// Daily keeps the last two days, weekly the last day of the previous week, which requires the whole chain
+	// of both weeks.
+	expectedKeep := []string{
+		"0115", "0116", "0117", "0118", "0119", "0120", "0121",
+		"0122", "0123", "0124", "0125", "0126", "0127", "0128",
+	}
+	assert.Equal(t, expectedKeep, ids(plan.Keep))
+	assert.Contains(t, plan.Reasons["0115"], "base of 0121")
+	assert.Len(t, plan.Delete, 14)
+
+	// Incremental backups are deleted before their base.
+	pos := make(map[string]int)
+	for i, n := range plan.Delete {
+		pos[n.ID] = i
+	}
+	for _, n := range plan.Delete {
+		if n.Parent != nil {
+			assert.Less(t, pos[n.ID], pos[n.Parent.ID])
+		}
+	}
+
+	fake := &fakeBackups{}
+	require.NoError(t, plan.Apply(context.Background(), fake, "remote", true))
+	require.Len(t, fake.deleted, 14)
+	for _, d := range fake.deleted {
+		assert.True(t, d.DryRun)
+		assert.False(t, d.Cascading)
+	}
+}
+
+func TestPlanKeepsBrokenUnlessPruned(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	list := &client.BackupList{Linstor: map[string]client.Backup{
+		"full":   {Id: "full", OriginRsc: "rsc", Success: true, Restorable: true, FinishedTimestamp: ts(base)},
+		"failed": {Id: "failed", OriginRsc: "rsc", BasedOnId: "full", FinishedTimestamp: ts(base.Add(time.Hour))},
+		"orphan": {Id: "orphan", OriginRsc: "rsc", Success: true, Restorable: true, BasedOnId: "gone", FinishedTimestamp: ts(base.Add(2 * time.Hour))},
+	}}
+
+	g := backup.BuildGraphs(list)["rsc"]
+
+	plan := g.Plan(backup.Policy{Last: 1})
+	assert.Equal(t, []string{"full", "failed", "orphan"}, ids(plan.Keep))
+	assert.Empty(t, plan.Delete)
+
+	plan = g.Plan(backup.Policy{Last: 1, PruneBroken: true})
+	assert.Equal(t, []string{"full"}, ids(plan.Keep))
+	assert.Equal(t, []string{"failed", "orphan"}, ids(plan.Delete))
+}
+
+type fakeInfo struct {
+	client.BackupProvider
+	info *client.BackupInfo
+}
+
+func (f *fakeInfo) Info(_ context.Context, _ string, _ client.BackupInfoRequest) (*client.BackupInfo, error) {
+	return f.info, nil
+}
+
+type fakePools struct {
+	client.NodeProvider
+	pools []client.StoragePool
+}
+
+func (f *fakePools) GetStoragePools(_ context.Context, _ string, _ ...*client.ListOpts) ([]client.StoragePool, error) {
+	return f.pools, nil
+}
+
+func TestPlanRestore(t *testing.T) {
+	backups := &fakeInfo{info: &client.BackupInfo{
+		Rsc: "rsc",
+		Storpools: []client.BackupInfoStorPool{
+			{Name: "thin", ProviderKind: client.LVM_THIN, Vlms: []client.BackupInfoVolume{{AllocSizeKib: 100, UsableSizeKib: 1000}}},
+			{Name: "zfs", ProviderKind: client.ZFS_THIN, Vlms: []client.BackupInfoVolume{{AllocSizeKib: 500, UsableSizeKib: 500}}},
+		},
+	}}
+
+	nodes := &fakePools{pools: []client.StoragePool{
+		{StoragePoolName: "thick", ProviderKind: client.LVM, FreeCapacity: 10000},
+		{StoragePoolName: "small", ProviderKind: client.LVM_THIN, FreeCapacity: 200},
+		{StoragePoolName: "zpool", ProviderKind: client.ZFS, FreeCapacity: 600},
+		{StoragePoolName: "diskless", ProviderKind: client.DISKLESS},
+	}}
+
+	request := client.BackupRestoreRequest{SrcRscName: "rsc", TargetRscName: "restored", NodeName: "node1"}
+
+	plan, err := backup.PlanRestore(context.Background(), backups, nodes, "remote", request)
+	require.NoError(t, err)
+	assert.True(t, plan.Feasible(), plan.Explain())
+	assert.Equal(t, map[string]string{"thin": "thick", "zfs": "zpool"}, plan.Request.StorPoolMap)
+	assert.Equal(t, int64(1000), plan.Assignments[0].RequiredKib)
+
+	// Explicit mappings are honoured, even if they do not fit.
+	request.StorPoolMap = map[string]string{"zfs": "small"}
+	plan, err = backup.PlanRestore(context.Background(), backups, nodes, "remote", request)
+	require.NoError(t, err)
+	assert.False(t, plan.Feasible())
+	require.Len(t, plan.Problems, 1)
+	assert.Contains(t, plan.Problems[0], "incompatible provider kind")
+
+	// Both thin volumes compete for the same space.
+	nodes.pools = []client.StoragePool{{StoragePoolName: "zpool", ProviderKind: client.ZFS_THIN, FreeCapacity: 550}}
+	backups.info.Storpools[0].ProviderKind = client.ZFS_THIN
+	request.StorPoolMap = nil
+	plan, err = backup.PlanRestore(context.Background(), backups, nodes, "remote", request)
+	require.NoError(t, err)
+	assert.False(t, plan.Feasible())
+	assert.Contains(t, plan.Explain(), "has only 450 KiB of 500 KiB free")
+}
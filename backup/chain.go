@@ -0,0 +1,207 @@
+// Package backup provides helpers to analyze backups stored at a remote.
+//
+// LINSTOR stores backups as full backups and incremental backups based on a previous backup. This package
+// reconstructs these chains from a client.BackupList, finds backups that can no longer be restored and computes
+// retention plans that never break a chain that is still needed.
+package backup
+
+import (
+	"sort"
+	"time"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// Node is a single backup in a chain.
+type Node struct {
+	// ID of the backup, as used by BackupDeleteOpts.ID.
+	ID     string
+	Backup client.Backup
+	// Parent is the backup this incremental backup is based on. It is nil for full backups and for incremental
+	// backups whose base is missing.
+	Parent   *Node
+	Children []*Node
+
+	// Broken is set if this backup or any backup it is based on failed, or is not restorable according to the
+	// controller.
+	Broken bool
+	// Orphaned is set if the base of this backup, or of any backup it is based on, is missing.
+	Orphaned bool
+}
+
+// IsFull returns true if the backup is not based on another backup.
+func (n *Node) IsFull() bool {
+	return n.Backup.BasedOnId == ""
+}
+
+// InProgress returns true if the backup is still being created.
+func (n *Node) InProgress() bool {
+	return n.Backup.Shipping
+}
+
+// Restorable returns true if the complete chain up to this backup can be restored.
+func (n *Node) Restorable() bool {
+	return !n.Broken && !n.Orphaned && !n.InProgress()
+}
+
+// Time returns the time the backup finished, or, if that is not known, the time it started.
+func (n *Node) Time() time.Time {
+	if n.Backup.FinishedTimestamp != nil {
+		return n.Backup.FinishedTimestamp.Time
+	}
+
+	if n.Backup.StartTimestamp != nil {
+		return n.Backup.StartTimestamp.Time
+	}
+
+	return time.Time{}
+}
+
+// Chain returns all backups needed to restore this backup, starting with the full backup.
+func (n *Node) Chain() []*Node {
+	var chain []*Node
+	for cur := n; cur != nil; cur = cur.Parent {
+		chain = append(chain, cur)
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	return chain
+}
+
+// Graph contains all backups of a single resource.
+type Graph struct {
+	Resource string
+	// Nodes contains all backups, indexed by ID.
+	Nodes map[string]*Node
+	// Fulls are the full backups, oldest first.
+	Fulls []*Node
+	// Broken are backups that cannot be restored because they, or a backup they are based on, failed or are not
+	// restorable.
+	Broken []*Node
+	// Orphaned are incremental backups that cannot be restored because a base backup is missing.
+	Orphaned []*Node
+}
+
+// Sorted returns all backups, oldest first.
+func (g *Graph) Sorted() []*Node {
+	nodes := make([]*Node, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n)
+	}
+
+	sortByTime(nodes)
+
+	return nodes
+}
+
+// BuildGraphs reconstructs the backup chains of all resources in the list, indexed by resource name.
+func BuildGraphs(list *client.BackupList) map[string]*Graph {
+	graphs := make(map[string]*Graph)
+	if list == nil {
+		return graphs
+	}
+
+	for key, b := range list.Linstor {
+		id := b.Id
+		if id == "" {
+			id = key
+		}
+
+		g, ok := graphs[b.OriginRsc]
+		if !ok {
+			g = &Graph{Resource: b.OriginRsc, Nodes: make(map[string]*Node)}
+			graphs[b.OriginRsc] = g
+		}
+
+		g.Nodes[id] = &Node{ID: id, Backup: b}
+	}
+
+	for _, g := range graphs {
+		g.link()
+	}
+
+	return graphs
+}
+
+func (g *Graph) link() {
+	for _, n := range g.Nodes {
+		if n.IsFull() {
+			continue
+		}
+
+		parent, ok := g.Nodes[n.Backup.BasedOnId]
+		if !ok || isAncestor(n, parent) {
+			// Missing base, or a cycle, which LINSTOR should never create. Either way, n is orphaned.
+			continue
+		}
+
+		n.Parent = parent
+		parent.Children = append(parent.Children, n)
+	}
+
+	for _, n := range g.Nodes {
+		sortByTime(n.Children)
+	}
+
+	done := make(map[*Node]bool)
+	for _, n := range g.Sorted() {
+		classify(n, done)
+	}
+
+	for _, n := range g.Sorted() {
+		if n.IsFull() {
+			g.Fulls = append(g.Fulls, n)
+		}
+
+		if n.Orphaned {
+			g.Orphaned = append(g.Orphaned, n)
+		} else if n.Broken {
+			g.Broken = append(g.Broken, n)
+		}
+	}
+}
+
+// classify propagates the broken and orphaned state from the base backups.
+func classify(n *Node, done map[*Node]bool) {
+	if done[n] {
+		return
+	}
+
+	n.Broken = (!n.Backup.Success || !n.Backup.Restorable) && !n.InProgress()
+
+	switch {
+	case n.IsFull():
+	case n.Parent == nil:
+		n.Orphaned = true
+	default:
+		classify(n.Parent, done)
+		n.Orphaned = n.Parent.Orphaned
+		n.Broken = n.Broken || n.Parent.Broken
+	}
+
+	done[n] = true
+}
+
+// isAncestor returns true if a is n or any of the backups n is based on.
+func isAncestor(a, n *Node) bool {
+	for cur := n; cur != nil; cur = cur.Parent {
+		if cur == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortByTime(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		ti, tj := nodes[i].Time(), nodes[j].Time()
+		if ti.Equal(tj) {
+			return nodes[i].ID < nodes[j].ID
+		}
+		return ti.Before(tj)
+	})
+}
@@ -0,0 +1,234 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// PoolAssignment maps a storage pool of the backup to a storage pool on the target node.
+type PoolAssignment struct {
+	// Source is the name of the storage pool the backup was created from.
+	Source       string
+	SourceKind   client.ProviderKind
+	Target       string
+	TargetKind   client.ProviderKind
+	RequiredKib  int64
+	AvailableKib int64
+}
+
+// RestorePlan is the result of PlanRestore.
+type RestorePlan struct {
+	// Request is ready to be passed to BackupProvider.Restore, if the plan is feasible.
+	Request     client.BackupRestoreRequest
+	Info        *client.BackupInfo
+	Assignments []PoolAssignment
+	// Problems explains why the restore cannot fit on the target node. Empty if the plan is feasible.
+	Problems []string
+}
+
+// Feasible returns true if all storage pools of the backup could be mapped to the target node.
+func (p *RestorePlan) Feasible() bool {
+	return len(p.Problems) == 0
+}
+
+// Explain returns a human-readable description of the plan.
+func (p *RestorePlan) Explain() string {
+	var sb strings.Builder
+	if p.Feasible() {
+		fmt.Fprintf(&sb, "backup of '%s' can be restored as '%s' on node '%s':\n", p.Request.SrcRscName, p.Request.TargetRscName, p.Request.NodeName)
+	} else {
+		fmt.Fprintf(&sb, "backup of '%s' cannot be restored on node '%s':\n", p.Request.SrcRscName, p.Request.NodeName)
+		for _, problem := range p.Problems {
+			fmt.Fprintf(&sb, "  - %s\n", problem)
+		}
+	}
+
+	for _, a := range p.Assignments {
+		fmt.Fprintf(&sb, "  %s (%s) -> %s (%s): %d KiB required, %d KiB available\n", a.Source, a.SourceKind, a.Target, a.TargetKind, a.RequiredKib, a.AvailableKib)
+	}
+
+	return sb.String()
+}
+
+// PlanRestore computes a storage pool mapping for restoring a backup on request.NodeName.
+//
+// Mappings already present in request.StorPoolMap are kept, but still checked. For all other storage pools of the
+// backup, a storage pool with the same name is preferred, then the compatible pool with the most free space. Free
+// space is shared between all volumes mapped to the same pool.
+//
+// An error is only returned if the information could not be fetched. A restore that does not fit is reported via
+// RestorePlan.Problems.
+func PlanRestore(ctx context.Context, backups client.BackupProvider, nodes client.NodeProvider, remoteName string, request client.BackupRestoreRequest) (*RestorePlan, error) {
+	if request.NodeName == "" {
+		return nil, fmt.Errorf("restore planning requires a target node")
+	}
+
+	info, err := backups.Info(ctx, remoteName, client.BackupInfoRequest{
+		SrcRscName:  request.SrcRscName,
+		SrcSnapName: request.SrcSnapName,
+		LastBackup:  request.LastBackup,
+		StorPoolMap: request.StorPoolMap,
+		NodeName:    request.NodeName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup info: %w", err)
+	}
+
+	pools, err := nodes.GetStoragePools(ctx, request.NodeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage pools of node '%s': %w", request.NodeName, err)
+	}
+
+	free := make(map[string]int64, len(pools))
+	byName := make(map[string]*client.StoragePool, len(pools))
+	for i := range pools {
+		byName[pools[i].StoragePoolName] = &pools[i]
+		free[pools[i].StoragePoolName] = pools[i].FreeCapacity
+	}
+
+	plan := &RestorePlan{Request: request, Info: info}
+	plan.Request.StorPoolMap = make(map[string]string, len(info.Storpools))
+
+	sources := append([]client.BackupInfoStorPool(nil), info.Storpools...)
+	// Place pools with explicit mapping first, so they are not crowded out by automatic choices.
+	sort.SliceStable(sources, func(i, j int) bool {
+		_, iMapped := request.StorPoolMap[sources[i].Name]
+		_, jMapped := request.StorPoolMap[sources[j].Name]
+		if iMapped != jMapped {
+			return iMapped
+		}
+		return sources[i].Name < sources[j].Name
+	})
+
+	for _, src := range sources {
+		candidates := restoreCandidates(src, request.StorPoolMap, pools, free)
+
+		var chosen *client.StoragePool
+		var required int64
+		var reasons []string
+		for _, name := range candidates {
+			target, ok := byName[name]
+			if !ok {
+				reasons = append(reasons, fmt.Sprintf("storage pool '%s' does not exist", name))
+				continue
+			}
+
+			if !restoreCompatible(src.ProviderKind, target.ProviderKind) {
+				reasons = append(reasons, fmt.Sprintf("storage pool '%s' has incompatible provider kind %s", name, target.ProviderKind))
+				continue
+			}
+
+			need := requiredKib(src, target.ProviderKind)
+			if need > free[name] {
+				reasons = append(reasons, fmt.Sprintf("storage pool '%s' has only %d KiB of %d KiB free", name, free[name], need))
+				continue
+			}
+
+			// The controller knows better, for example about space reserved for other volumes.
+			if src.TargetName == name && src.RemainingSpaceKib < 0 {
+				reasons = append(reasons, fmt.Sprintf("storage pool '%s' lacks %d KiB according to the controller", name, -src.RemainingSpaceKib))
+				continue
+			}
+
+			chosen, required = target, need
+			break
+		}
+
+		if chosen == nil {
+			if len(reasons) == 0 {
+				reasons = append(reasons, "no storage pools")
+			}
+			plan.Problems = append(plan.Problems, fmt.Sprintf("storage pool '%s' (%s, %d KiB): %s", src.Name, src.ProviderKind, requiredKib(src, src.ProviderKind), strings.Join(reasons, "; ")))
+			continue
+		}
+
+		plan.Assignments = append(plan.Assignments, PoolAssignment{
+			Source:       src.Name,
+			SourceKind:   src.ProviderKind,
+			Target:       chosen.StoragePoolName,
+			TargetKind:   chosen.ProviderKind,
+			RequiredKib:  required,
+			AvailableKib: free[chosen.StoragePoolName],
+		})
+		free[chosen.StoragePoolName] -= required
+		plan.Request.StorPoolMap[src.Name] = chosen.StoragePoolName
+	}
+
+	return plan, nil
+}
+
+// restoreCandidates returns the target pools to try, in order of preference.
+func restoreCandidates(src client.BackupInfoStorPool, mapping map[string]string, pools []client.StoragePool, free map[string]int64) []string {
+	if target, ok := mapping[src.Name]; ok {
+		return []string{target}
+	}
+
+	var candidates []string
+	for i := range pools {
+		if pools[i].StoragePoolName != src.Name {
+			candidates = append(candidates, pools[i].StoragePoolName)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if free[candidates[i]] != free[candidates[j]] {
+			return free[candidates[i]] > free[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+
+	for i := range pools {
+		if pools[i].StoragePoolName == src.Name {
+			candidates = append([]string{src.Name}, candidates...)
+		}
+	}
+
+	return candidates
+}
+
+// restoreCompatible reports whether a backup taken from a pool of kind src can be restored to a pool of kind dst.
+// LINSTOR restores backups using the tools of the storage provider, so only the same provider family works.
+func restoreCompatible(src, dst client.ProviderKind) bool {
+	family := func(k client.ProviderKind) client.ProviderKind {
+		switch k {
+		case client.LVM_THIN:
+			return client.LVM
+		case client.ZFS_THIN:
+			return client.ZFS
+		case client.FILE_THIN:
+			return client.FILE
+		}
+		return k
+	}
+
+	if dst == client.DISKLESS {
+		return false
+	}
+
+	// Older controllers do not report the provider kind of the backup.
+	if src == "" {
+		return true
+	}
+
+	return family(src) == family(dst)
+}
+
+// requiredKib returns the space needed for all volumes of the pool. Thick pools allocate the full volume.
+func requiredKib(src client.BackupInfoStorPool, target client.ProviderKind) int64 {
+	thin := target == client.LVM_THIN || target == client.ZFS_THIN || target == client.FILE_THIN
+
+	var total int64
+	for _, v := range src.Vlms {
+		size := v.AllocSizeKib
+		if !thin && v.UsableSizeKib > size {
+			size = v.UsableSizeKib
+		}
+		total += size
+	}
+
+	return total
+}
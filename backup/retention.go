@@ -0,0 +1,164 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// Policy is a grandfather-father-son retention policy.
+//
+// For every period, the newest restorable backup is kept, up to the configured number of periods. A backup is kept
+// if any rule selects it, so the rules overlap: with Last: 1 and Daily: 7 at most 7 backups are kept.
+type Policy struct {
+	// Last is the number of most recent restorable backups to keep.
+	Last int
+	// Daily is the number of days to keep the newest backup of.
+	Daily int
+	// Weekly is the number of ISO weeks to keep the newest backup of.
+	Weekly int
+	// Monthly is the number of months to keep the newest backup of.
+	Monthly int
+	// Yearly is the number of years to keep the newest backup of.
+	Yearly int
+	// Location is used to determine period boundaries. Defaults to time.Local.
+	Location *time.Location
+	// PruneBroken also deletes backups that cannot be restored, i.e. broken and orphaned backups.
+	// By default, they are kept for inspection.
+	PruneBroken bool
+}
+
+// Plan is the result of applying a Policy to a Graph.
+type Plan struct {
+	Resource string
+	// Keep are the backups to keep, oldest first.
+	Keep []*Node
+	// Delete are the backups to delete, in the order they should be deleted: incremental backups are always
+	// deleted before the backups they are based on.
+	Delete []*Node
+	// Reasons explains why a backup is kept, indexed by ID.
+	Reasons map[string][]string
+}
+
+// Plan computes which backups to keep according to the policy.
+//
+// All backups needed to restore a kept backup are kept too. Backups that are still in progress are never deleted,
+// neither are the backups they are based on.
+func (g *Graph) Plan(p Policy) *Plan {
+	loc := p.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	plan := &Plan{Resource: g.Resource, Reasons: make(map[string][]string)}
+	keep := func(n *Node, reason string) {
+		plan.Reasons[n.ID] = append(plan.Reasons[n.ID], reason)
+	}
+
+	var restorable []*Node
+	sorted := g.Sorted()
+	for i := len(sorted) - 1; i >= 0; i-- {
+		n := sorted[i]
+		switch {
+		case n.InProgress():
+			keep(n, "in progress")
+		case n.Restorable():
+			restorable = append(restorable, n)
+		case !p.PruneBroken:
+			keep(n, "not restorable")
+		}
+	}
+
+	for i := 0; i < p.Last && i < len(restorable); i++ {
+		keep(restorable[i], "last")
+	}
+
+	rules := []struct {
+		name  string
+		count int
+		key   func(t time.Time) string
+	}{
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", y, w)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+
+	for _, rule := range rules {
+		seen := make(map[string]bool)
+		// restorable is sorted newest first, so the first backup of every period is the one to keep.
+		for _, n := range restorable {
+			if len(seen) >= rule.count {
+				break
+			}
+
+			period := rule.key(n.Time().In(loc))
+			if seen[period] {
+				continue
+			}
+
+			seen[period] = true
+			keep(n, rule.name+" "+period)
+		}
+	}
+
+	// Never delete a base backup that is still needed.
+	for _, n := range sorted {
+		if _, ok := plan.Reasons[n.ID]; !ok {
+			continue
+		}
+
+		for cur := n.Parent; cur != nil; cur = cur.Parent {
+			keep(cur, "base of "+n.ID)
+		}
+	}
+
+	for _, n := range sorted {
+		if _, ok := plan.Reasons[n.ID]; ok {
+			plan.Keep = append(plan.Keep, n)
+		}
+	}
+
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if _, ok := plan.Reasons[sorted[i].ID]; !ok {
+			plan.Delete = append(plan.Delete, sorted[i])
+		}
+	}
+
+	sortDeleteOrder(plan.Delete)
+
+	return plan
+}
+
+// sortDeleteOrder ensures that every backup is deleted before the backup it is based on, by deleting the backups
+// deepest in their chain first. Otherwise, the newest backups are deleted first.
+func sortDeleteOrder(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return len(nodes[i].Chain()) > len(nodes[j].Chain())
+	})
+}
+
+// Apply deletes the backups of the plan from the given remote. With dryRun set, LINSTOR only reports what would be
+// deleted.
+//
+// Backups are deleted one by one, without cascading, so that a kept backup is never removed as a side effect.
+// Apply stops at the first error.
+func (p *Plan) Apply(ctx context.Context, backups client.BackupProvider, remoteName string, dryRun bool) error {
+	for _, n := range p.Delete {
+		err := backups.DeleteAll(ctx, remoteName, client.BackupDeleteOpts{
+			ID:     n.ID,
+			DryRun: dryRun,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete backup '%s' of resource '%s': %w", n.ID, p.Resource, err)
+		}
+	}
+
+	return nil
+}
@@ -12,6 +12,8 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -46,6 +48,7 @@ type cache struct {
 	mu         sync.Mutex
 	lastUpdate time.Time
 	cache      any
+	etag       string
 }
 
 // Invalidate forcefully resets the cache.
@@ -54,6 +57,7 @@ func (c *cache) Invalidate() {
 	c.mu.Lock()
 	c.lastUpdate = time.Time{}
 	c.cache = nil
+	c.etag = ""
 	c.mu.Unlock()
 }
 
@@ -63,18 +67,41 @@ func (c *cache) Invalidate() {
 // If the cache is outdated, it will run the provided function to retrieve a result. A successful response
 // is cached for later use.
 func (c *cache) Get(timeout time.Duration, updateFunc func() (any, error)) (any, error) {
+	return c.update(timeout, func(string) (any, string, error) {
+		result, err := updateFunc()
+		return result, "", err
+	})
+}
+
+// GetConditional works like Get, but calls the update function with a context that makes the request conditional
+// on the cached response. If the controller reports that the response did not change, the cached response is
+// reused.
+func (c *cache) GetConditional(ctx context.Context, timeout time.Duration, updateFunc func(ctx context.Context) (any, error)) (any, error) {
+	return c.update(timeout, func(etag string) (any, string, error) {
+		cond := &client.Conditional{ETag: etag}
+		result, err := updateFunc(client.WithConditional(ctx, cond))
+		return result, cond.ETag, err
+	})
+}
+
+// update runs fn with the ETag of the cached response if the cache is outdated. fn returns the new result and its
+// ETag, or client.ErrNotModified to keep the cached response.
+func (c *cache) update(timeout time.Duration, fn func(etag string) (any, string, error)) (any, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	now := time.Now()
 
 	if timeout != 0 && c.lastUpdate.Add(timeout).Before(now) {
-		result, err := updateFunc()
-		if err != nil {
+		result, etag, err := fn(c.etag)
+		switch {
+		case errors.Is(err, client.ErrNotModified):
+		case err != nil:
 			return nil, err
+		default:
+			c.cache = result
+			c.etag = etag
 		}
-
-		c.cache = result
 		c.lastUpdate = now
 	}
 
@@ -139,6 +166,18 @@ outer:
 	return result
 }
 
+// each calls fn for every item, stopping at the first error.
+// Used to implement the Stream* methods, where the cached response is already in memory.
+func each[T any](items []T, fn func(T) error) error {
+	for i := range items {
+		if err := fn(items[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func hasUnsupportedOpts(opts ...*client.ListOpts) bool {
 	for _, opt := range opts {
 		if len(opt.Snapshots) > 0 || len(opt.Resource) > 0 || opt.Limit != 0 || opt.Offset != 0 {
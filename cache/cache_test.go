@@ -162,3 +162,45 @@ func TestNodeCachePropsFiltering(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []client.Node{Node2}, nodes)
 }
+
+func TestNodeCacheConditional(t *testing.T) {
+	const etag = `"nodes-1"`
+	var full, notModified int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		full++
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(AllNodes)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	assert.NoError(t, err)
+
+	cl, err := client.NewClient(
+		client.HTTPClient(srv.Client()),
+		client.BaseURL(u),
+		cache.WithCaches(&cache.NodeCache{Timeout: 100 * time.Millisecond}),
+	)
+	assert.NoError(t, err)
+
+	nodes, err := cl.Nodes.GetAll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, AllNodes, nodes)
+	assert.Equal(t, 1, full)
+
+	// Cache expired -> conditional request, the stored value is reused.
+	time.Sleep(200 * time.Millisecond)
+	nodes, err = cl.Nodes.GetAll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, AllNodes, nodes)
+	assert.Equal(t, 1, full)
+	assert.Equal(t, 1, notModified)
+}
@@ -32,7 +32,7 @@ type nodeCacheProvider struct {
 var _ client.NodeProvider = &nodeCacheProvider{}
 
 func (n *nodeCacheProvider) GetAll(ctx context.Context, opts ...*client.ListOpts) ([]client.Node, error) {
-	c, err := n.cache.nodeCache.Get(n.cache.Timeout, func() (any, error) {
+	c, err := n.cache.nodeCache.GetConditional(ctx, n.cache.Timeout, func(ctx context.Context) (any, error) {
 		return n.cl.GetAll(ctx, cacheOpt)
 	})
 	if err != nil {
@@ -142,7 +142,7 @@ func (n *nodeCacheProvider) Evacuate(ctx context.Context, nodeName string, evacu
 }
 
 func (n *nodeCacheProvider) GetStoragePoolView(ctx context.Context, opts ...*client.ListOpts) ([]client.StoragePool, error) {
-	result, err := n.cache.storagePoolCache.Get(n.cache.Timeout, func() (any, error) {
+	result, err := n.cache.storagePoolCache.GetConditional(ctx, n.cache.Timeout, func(ctx context.Context) (any, error) {
 		return n.cl.GetStoragePoolView(ctx, cacheOpt)
 	})
 	if err != nil {
@@ -152,6 +152,15 @@ func (n *nodeCacheProvider) GetStoragePoolView(ctx context.Context, opts ...*cli
 	return filterNodeAndPoolOpts(result.([]client.StoragePool), opts...), nil
 }
 
+func (n *nodeCacheProvider) StreamStoragePoolView(ctx context.Context, fn func(client.StoragePool) error, opts ...*client.ListOpts) error {
+	pools, err := n.GetStoragePoolView(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	return each(pools, fn)
+}
+
 func (n *nodeCacheProvider) GetStoragePools(ctx context.Context, nodeName string, opts ...*client.ListOpts) ([]client.StoragePool, error) {
 	allPools, err := n.GetStoragePoolView(ctx, opts...)
 	if err != nil {
@@ -200,7 +209,7 @@ func (n *nodeCacheProvider) DeleteStoragePool(ctx context.Context, nodeName, spN
 }
 
 func (n *nodeCacheProvider) GetPhysicalStorageView(ctx context.Context, opts ...*client.ListOpts) ([]client.PhysicalStorageViewItem, error) {
-	result, err := n.cache.physicalStorageCache.Get(n.cache.Timeout, func() (any, error) {
+	result, err := n.cache.physicalStorageCache.GetConditional(ctx, n.cache.Timeout, func(ctx context.Context) (any, error) {
 		return n.cl.GetPhysicalStorageView(ctx, cacheOpt)
 	})
 	if err != nil {
@@ -44,7 +44,7 @@ var _ client.ResourceProvider = &resourceCacheProvider{}
 var _ client.BackupProvider = backupShim{}
 
 func (r *resourceCacheProvider) GetResourceView(ctx context.Context, opts ...*client.ListOpts) ([]client.ResourceWithVolumes, error) {
-	result, err := r.cache.resourceCache.Get(r.cache.Timeout, func() (any, error) {
+	result, err := r.cache.resourceCache.GetConditional(ctx, r.cache.Timeout, func(ctx context.Context) (any, error) {
 		return r.cl.GetResourceView(ctx, cacheOpt)
 	})
 	if err != nil {
@@ -54,6 +54,15 @@ func (r *resourceCacheProvider) GetResourceView(ctx context.Context, opts ...*cl
 	return filterNodeAndPoolOpts(result.([]client.ResourceWithVolumes), opts...), nil
 }
 
+func (r *resourceCacheProvider) StreamResourceView(ctx context.Context, fn func(client.ResourceWithVolumes) error, opts ...*client.ListOpts) error {
+	ress, err := r.GetResourceView(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	return each(ress, fn)
+}
+
 func (r *resourceCacheProvider) GetAll(ctx context.Context, resName string, opts ...*client.ListOpts) ([]client.Resource, error) {
 	ress, err := r.GetResourceView(ctx, opts...)
 	if err != nil {
@@ -176,7 +185,7 @@ func (r *resourceCacheProvider) MakeAvailable(ctx context.Context, resName, node
 }
 
 func (r *resourceCacheProvider) GetSnapshotView(ctx context.Context, opts ...*client.ListOpts) ([]client.Snapshot, error) {
-	result, err := r.cache.snapshotCache.Get(r.cache.Timeout, func() (any, error) {
+	result, err := r.cache.snapshotCache.GetConditional(ctx, r.cache.Timeout, func(ctx context.Context) (any, error) {
 		return r.cl.GetSnapshotView(ctx, cacheOpt)
 	})
 	if err != nil {
@@ -186,6 +195,15 @@ func (r *resourceCacheProvider) GetSnapshotView(ctx context.Context, opts ...*cl
 	return filterNodeAndPoolOpts(result.([]client.Snapshot), opts...), nil
 }
 
+func (r *resourceCacheProvider) StreamSnapshotView(ctx context.Context, fn func(client.Snapshot) error, opts ...*client.ListOpts) error {
+	snaps, err := r.GetSnapshotView(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	return each(snaps, fn)
+}
+
 func (r *resourceCacheProvider) GetSnapshots(ctx context.Context, resName string, opts ...*client.ListOpts) ([]client.Snapshot, error) {
 	snaps, err := r.GetSnapshotView(ctx, opts...)
 	if err != nil {
@@ -248,6 +266,15 @@ func (r *resourceCacheProvider) RollbackSnapshot(ctx context.Context, resName, s
 	return r.cl.RollbackSnapshot(ctx, resName, snapName)
 }
 
+func (r *resourceCacheProvider) CreateSnapshotShipping(ctx context.Context, resName string, ship client.SnapshotShipping) error {
+	defer r.cache.snapshotCache.Invalidate()
+	return r.cl.CreateSnapshotShipping(ctx, resName, ship)
+}
+
+func (r *resourceCacheProvider) GetSnapshotShippings(ctx context.Context, opts ...*client.ListOpts) ([]client.SnapshotShippingStatus, error) {
+	return r.cl.GetSnapshotShippings(ctx, opts...)
+}
+
 func (r *resourceCacheProvider) GetConnections(ctx context.Context, resName, nodeAName, nodeBName string, opts ...*client.ListOpts) ([]client.ResourceConnection, error) {
 	return r.cl.GetConnections(ctx, resName, nodeAName, nodeBName, opts...)
 }
@@ -47,9 +47,13 @@ type Client struct {
 	httpClient    *http.Client
 	basicAuth     *BasicAuthCfg
 	bearerToken   string
+	credentials   CredentialProvider
+	coalescer     *coalescer
 	userAgent     string
 	controllersMu sync.Mutex
 	controllers   []*url.URL
+	proxy         *url.URL
+	unixSockets   sync.Map // placeholder host name -> socket path
 	lim           *rate.Limiter
 	log           interface{} // must be either Logger or LeveledLogger
 
@@ -63,6 +67,7 @@ type Client struct {
 	Events                 EventProvider
 	Remote                 RemoteProvider
 	Backup                 BackupProvider
+	Schedules              ScheduleProvider
 	KeyValueStore          KeyValueStoreProvider
 	Connections            ConnectionProvider
 }
@@ -216,6 +221,16 @@ func BearerTokenFromFile(path ...string) Option {
 	}
 }
 
+// WithCredentialProvider configures authentication via the given CredentialProvider. The provider is asked for
+// credentials on every request, and asked to refresh them if the controller rejects a request as unauthorized.
+// If set, this will override any authentication configured via BasicAuth or BearerToken.
+func WithCredentialProvider(provider CredentialProvider) Option {
+	return func(c *Client) error {
+		c.credentials = provider
+		return nil
+	}
+}
+
 // UserAgent sets the User-Agent header for every request to the given string.
 func UserAgent(ua string) Option {
 	return func(c *Client) error {
@@ -350,6 +365,14 @@ func parseBaseURL(urlString string) (*url.URL, error) {
 		return nil, err
 	}
 
+	if isUnix(u) {
+		// unix:///path/to/socket
+		if u.Host != "" || u.Path == "" {
+			return nil, fmt.Errorf("invalid unix socket URL '%s', expected unix:///path/to/socket", urlString)
+		}
+		return u, nil
+	}
+
 	if u.Path != "" && u.Host == "" {
 		u.Host = u.Path
 		u.Path = ""
@@ -393,6 +416,9 @@ func parseURLs(urls []string) ([]*url.URL, error) {
 // the client at runtime:
 //
 // - LS_CONTROLLERS: a comma-separated list of LINSTOR controllers to connect to.
+// Controllers listening on a unix domain socket can be specified as "unix:///path/to/socket".
+//
+// - LS_PROXY: the URL of an HTTP or SOCKS5 proxy used to reach the LINSTOR controllers. Ignored if empty.
 //
 // - LS_USERNAME, LS_PASSWORD: can be used to authenticate against the LINSTOR
 // controller using HTTP basic authentication.
@@ -435,6 +461,7 @@ func NewClient(options ...Option) (*Client, error) {
 	c.Events = &EventService{client: c}
 	c.Remote = &RemoteService{client: c}
 	c.Backup = &BackupService{client: c}
+	c.Schedules = &ScheduleService{client: c}
 	c.KeyValueStore = &KeyValueStoreService{client: c}
 	c.Connections = &ConnectionService{client: c}
 
@@ -447,6 +474,17 @@ func NewClient(options ...Option) (*Client, error) {
 		c.bearerToken = token
 	}
 
+	if proxyStr := os.Getenv(ProxyEnv); proxyStr != "" {
+		proxy, err := url.Parse(proxyStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+		}
+
+		if err := Proxy(proxy)(c); err != nil {
+			return nil, err
+		}
+	}
+
 	for _, opt := range options {
 		if err := opt(c); err != nil {
 			return nil, err
@@ -467,6 +505,10 @@ func NewClient(options ...Option) (*Client, error) {
 		}
 	}
 
+	if err := c.configureTransport(); err != nil {
+		return nil, fmt.Errorf("failed to configure transport: %w", err)
+	}
+
 	return c, nil
 }
 
@@ -504,7 +546,7 @@ func (c *Client) newRequest(method, path string, body interface{}) (*http.Reques
 		return nil, err
 	}
 
-	u := c.BaseURL().ResolveReference(rel)
+	u := c.requestBase(c.BaseURL()).ResolveReference(rel)
 
 	var buf io.ReadWriter
 	if body != nil {
@@ -572,9 +614,9 @@ func (c *Client) findRespondingController() error {
 		i := i
 		go func() {
 			defer wg.Done()
-			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.controllers[i].Host)
+			conn, err := c.dialController(ctx, c.controllers[i])
 			if err != nil {
-				errs[i] = fmt.Errorf("failed to dial '%s': %w", c.controllers[i].Host, err)
+				errs[i] = fmt.Errorf("failed to dial '%s': %w", controllerAddr(c.controllers[i]), err)
 				return
 			}
 			_ = conn.Close()
@@ -627,20 +669,29 @@ func (c *Client) retry(origErr error, req *http.Request) (*http.Response, error)
 		return nil, origErr
 	}
 
-	req.URL.Host = c.BaseURL().Host
-	req.URL.Scheme = c.BaseURL().Scheme
+	base := c.requestBase(c.BaseURL())
+	req.URL.Host = base.Host
+	req.URL.Scheme = base.Scheme
 	return c.httpClient.Do(req)
 }
 
-// do sends a prepared http.Request and returns the http.Response. If an HTTP error occurs, the parsed error is
-// returned. Otherwise, the response is returned as-is. The caller is responsible for closing the response body in
-// the non-error case.
-func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
-	if err := c.lim.Wait(ctx); err != nil {
-		return nil, err
+// applyCredentials sets the authentication headers from the configured CredentialProvider, if any.
+func (c *Client) applyCredentials(ctx context.Context, req *http.Request) error {
+	if c.credentials == nil {
+		return nil
 	}
-	req = req.WithContext(ctx)
 
+	creds, err := c.credentials.Credentials(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get credentials: %w", err)
+	}
+
+	creds.apply(req)
+	return nil
+}
+
+// send sends the request, retrying on a different controller in case of connectivity issues.
+func (c *Client) send(ctx context.Context, req *http.Request) (*http.Response, error) {
 	c.logCurlify(req)
 
 	resp, err := c.httpClient.Do(req)
@@ -652,12 +703,75 @@ func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, err
 		}
 
 		// if this was a connectivity issue, attempt a retry
-		resp, err = c.retry(err, req)
+		return c.retry(err, req)
+	}
+
+	return resp, nil
+}
+
+// retryUnauthorized asks the CredentialProvider for new credentials and sends the request again.
+// If the credentials can not be refreshed, the original response is returned.
+func (c *Client) retryUnauthorized(ctx context.Context, req *http.Request, resp *http.Response) (*http.Response, error) {
+	if req.Body != nil && req.GetBody == nil {
+		// Body already consumed, and we have no way of getting it back.
+		return resp, nil
+	}
+
+	if err := c.credentials.Refresh(ctx); err != nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	if err := c.applyCredentials(ctx, retry); err != nil {
+		return resp, nil
+	}
+
+	// We are not going to use the original response anymore.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	return c.send(ctx, retry)
+}
+
+// do sends a prepared http.Request and returns the http.Response. If an HTTP error occurs, the parsed error is
+// returned. Otherwise, the response is returned as-is. The caller is responsible for closing the response body in
+// the non-error case.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if err := c.lim.Wait(ctx); err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if err := c.applyCredentials(ctx, req); err != nil {
+		return nil, err
+	}
+
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && c.credentials != nil {
+		resp, err = c.retryUnauthorized(ctx, req, resp)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	decompress(resp)
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
 		// If we get an error, we handle the body ourselves, so we also have to close it.
 		defer resp.Body.Close()
@@ -699,7 +813,7 @@ func (c *Client) doJSON(ctx context.Context, req *http.Request, out any) (*http.
 
 // Higer Leve Abstractions
 
-func (c *Client) doGET(ctx context.Context, url string, ret interface{}, opts ...*ListOpts) (*http.Response, error) {
+func (c *Client) newGETRequest(url string, opts ...*ListOpts) (*http.Request, error) {
 	opt, err := Optional(opts...)
 	if err != nil {
 		return nil, err
@@ -710,11 +824,56 @@ func (c *Client) doGET(ctx context.Context, url string, ret interface{}, opts ..
 		return nil, err
 	}
 
-	req, err := c.newRequest("GET", u, nil)
+	return c.newRequest("GET", u, nil)
+}
+
+func (c *Client) doGET(ctx context.Context, url string, ret interface{}, opts ...*ListOpts) (*http.Response, error) {
+	req, err := c.newGETRequest(url, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return c.doJSON(ctx, req, ret)
+
+	prepareConditional(ctx, req)
+
+	if c.coalescer == nil || ret == nil {
+		req.Header.Set("Accept", "application/json")
+		resp, err := c.do(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if finishConditional(ctx, resp) {
+			return resp, ErrNotModified
+		}
+
+		if ret == nil {
+			return resp, nil
+		}
+
+		return resp, json.NewDecoder(resp.Body).Decode(ret)
+	}
+
+	resp, body, err := c.coalescer.do(ctx, coalesceKey(req), func(ctx context.Context) (*http.Response, []byte, error) {
+		req.Header.Set("Accept", "application/json")
+		resp, err := c.do(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		return resp, body, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if finishConditional(ctx, resp) {
+		return resp, ErrNotModified
+	}
+
+	return resp, json.Unmarshal(body, ret)
 }
 
 func (c *Client) doEvent(ctx context.Context, url, lastEventId string) (*eventsource.Stream, error) {
@@ -725,6 +884,10 @@ func (c *Client) doEvent(ctx context.Context, url, lastEventId string) (*eventso
 	req.Header.Set("Accept", "text/event-stream")
 	req = req.WithContext(ctx)
 
+	if err := c.applyCredentials(ctx, req); err != nil {
+		return nil, err
+	}
+
 	stream, err := eventsource.SubscribeWith(lastEventId, c.httpClient, req)
 	if err != nil {
 		return nil, err
@@ -1,16 +1,26 @@
 package client
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/devicelayerkind"
 )
 
 const TestCaCert = `-----BEGIN CERTIFICATE-----
@@ -83,6 +93,31 @@ func TestNewClient_ViaEnv(t *testing.T) {
 			env:         map[string]string{"LS_CONTROLLERS": "just.domain:4000", "LS_ROOT_CA": TestCaCert},
 			expectedUrl: "https://just.domain:4000",
 		},
+		{
+			name:        "unix-socket",
+			env:         map[string]string{"LS_CONTROLLERS": "unix:///run/linstor.sock"},
+			expectedUrl: "unix:///run/linstor.sock",
+		},
+		{
+			name:     "unix-socket-with-host",
+			env:      map[string]string{"LS_CONTROLLERS": "unix://run/linstor.sock"},
+			hasError: true,
+		},
+		{
+			name:        "proxy",
+			env:         map[string]string{"LS_PROXY": "socks5://proxy:1080"},
+			expectedUrl: "http://localhost:3370",
+		},
+		{
+			name:        "empty-proxy",
+			env:         map[string]string{"LS_PROXY": ""},
+			expectedUrl: "http://localhost:3370",
+		},
+		{
+			name:     "unsupported-proxy",
+			env:      map[string]string{"LS_PROXY": "ftp://proxy"},
+			hasError: true,
+		},
 		{
 			name:     "parse-error-inconsistent-env",
 			env:      map[string]string{"LS_CONTROLLERS": "https://just.domain:4000", "LS_USER_CERTIFICATE": "stuff"},
@@ -232,3 +267,470 @@ func TestOptionalArgumentsValidation(t *testing.T) {
 	err = cl.Resources.Delete(t.Context(), "foo", "bar", &ResourceDeleteOpts{KeepTiebreaker: true}, &ResourceDeleteOpts{KeepTiebreaker: false})
 	assert.EqualError(t, err, "expected exactly zero or one arguments *client.ResourceDeleteOpts, got 2")
 }
+
+type rotatingCredentials struct {
+	tokens    []string
+	refreshes int
+}
+
+func (r *rotatingCredentials) Credentials(_ context.Context) (*Credentials, error) {
+	return &Credentials{BearerToken: r.tokens[r.refreshes]}, nil
+}
+
+func (r *rotatingCredentials) Refresh(_ context.Context) error {
+	if r.refreshes+1 >= len(r.tokens) {
+		return ErrCredentialsNotRefreshable
+	}
+	r.refreshes++
+	return nil
+}
+
+func TestCredentialProviderRefresh(t *testing.T) {
+	const Token = "fresh-token"
+
+	fakeHttpServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer "+Token {
+			writer.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprint(writer, `[{"ret_code":-1,"message":"unauthorized"}]`)
+		} else {
+			writer.WriteHeader(http.StatusOK)
+			_, _ = fmt.Fprint(writer, `{"version":"#v1"}`)
+		}
+	}))
+	defer fakeHttpServer.Close()
+
+	u, err := url.Parse(fakeHttpServer.URL)
+	require.NoError(t, err)
+
+	provider := &rotatingCredentials{tokens: []string{"stale-token", Token}}
+	cl, err := NewClient(WithCredentialProvider(provider), HTTPClient(fakeHttpServer.Client()), BaseURL(u))
+	require.NoError(t, err)
+
+	version, err := cl.Controller.GetVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "#v1", version.Version)
+	assert.Equal(t, 1, provider.refreshes)
+
+	// Refresh is not possible anymore, so the 401 is passed on to the caller.
+	provider.tokens = []string{"stale-token"}
+	provider.refreshes = 0
+	_, err = cl.Controller.GetVersion(context.Background())
+	assert.Error(t, err)
+}
+
+func TestExecCredentialProvider(t *testing.T) {
+	provider := &ExecCredentialProvider{
+		Command: "/bin/sh",
+		Args:    []string{"-c", `echo "{\"status\":{\"token\":\"$TOKEN\",\"expirationTimestamp\":\"2099-01-01T00:00:00Z\"}}"`},
+		Env:     []string{"TOKEN=from-exec"},
+	}
+
+	creds, err := provider.Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "from-exec", creds.BearerToken)
+	assert.Equal(t, 2099, creds.Expiry.Year())
+
+	provider.Args = []string{"-c", "echo not-json"}
+	creds, err = provider.Credentials(context.Background())
+	require.NoError(t, err, "cached credentials should be returned")
+	assert.Equal(t, "from-exec", creds.BearerToken)
+
+	require.NoError(t, provider.Refresh(context.Background()))
+	_, err = provider.Credentials(context.Background())
+	assert.Error(t, err)
+}
+
+func TestProxyNil(t *testing.T) {
+	_, err := NewClient(Proxy(nil))
+	assert.EqualError(t, err, "proxy URL is required")
+}
+
+func TestUnixSocketController(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "linstor.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(fakeVersionHandler("unix"))
+	srv.Listener = listener
+	srv.Start()
+	defer srv.Close()
+
+	down := url.URL{Scheme: "http", Host: "127.0.0.1:1"}
+
+	cl, err := NewClient(Controllers([]string{down.String(), "unix://" + socket}))
+	require.NoError(t, err)
+
+	// The first controller is not reachable, so this also checks that the fail-over can dial the socket.
+	version, err := cl.Controller.GetVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "unix", version.Version)
+	assert.Equal(t, "unix://"+socket, cl.BaseURL().String())
+}
+
+func TestCoalesceRequests(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, `{"version":"shared"}`)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	cl, err := NewClient(BaseURL(u), HTTPClient(srv.Client()), CoalesceRequests())
+	require.NoError(t, err)
+
+	// One caller gives up early, this must not affect the others.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancelledErr := make(chan error)
+	go func() {
+		_, err := cl.Controller.GetVersion(cancelled)
+		cancelledErr <- err
+	}()
+
+	const callers = 10
+	var wg sync.WaitGroup
+	versions := make([]ControllerVersion, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			versions[i], errs[i] = cl.Controller.GetVersion(context.Background())
+		}()
+	}
+
+	waiters := func() int {
+		cl.coalescer.mu.Lock()
+		defer cl.coalescer.mu.Unlock()
+		for _, call := range cl.coalescer.calls {
+			return call.waiters
+		}
+		return 0
+	}
+	require.Eventually(t, func() bool { return waiters() == callers+1 }, time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-cancelledErr, context.Canceled)
+
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, "shared", versions[i].Version)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	// Requests that are not concurrent are sent again.
+	_, err = cl.Controller.GetVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestCoalescerCopiesHeader(t *testing.T) {
+	g := &coalescer{calls: make(map[string]*coalescedCall)}
+	release := make(chan struct{})
+	fn := func(context.Context) (*http.Response, []byte, error) {
+		<-release
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Etag": {"abc"}}}, nil, nil
+	}
+
+	results := make(chan *http.Response, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, _, err := g.do(context.Background(), "key", fn)
+			assert.NoError(t, err)
+			results <- resp
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["key"] != nil && g.calls["key"].waiters == 2
+	}, time.Second, time.Millisecond)
+	close(release)
+
+	first, second := <-results, <-results
+	first.Header.Set("Etag", "changed")
+	assert.Equal(t, "abc", second.Header.Get("Etag"))
+}
+
+func TestStreamResourceView(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Query().Get("nodes") {
+		case "broken":
+			_, _ = fmt.Fprint(w, `[{"name":"rsc1"},{"name":17}]`)
+		case "none":
+			_, _ = fmt.Fprint(w, `null`)
+		default:
+			_, _ = fmt.Fprint(w, `[{"name":"rsc1","node_name":"node1"},{"name":"rsc2","node_name":"node1"}]`)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	cl, err := NewClient(BaseURL(u), HTTPClient(srv.Client()))
+	require.NoError(t, err)
+
+	var names []string
+	err = cl.Resources.StreamResourceView(context.Background(), func(r ResourceWithVolumes) error {
+		names = append(names, r.Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rsc1", "rsc2"}, names)
+
+	stop := errors.New("stop")
+	err = cl.Resources.StreamResourceView(context.Background(), func(r ResourceWithVolumes) error {
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+
+	err = cl.Resources.StreamResourceView(context.Background(), func(r ResourceWithVolumes) error {
+		return nil
+	}, &ListOpts{Node: []string{"broken"}})
+	var decodeErr *StreamDecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, 1, decodeErr.Index)
+
+	err = cl.Resources.StreamResourceView(context.Background(), func(r ResourceWithVolumes) error {
+		t.Fatal("unexpected element")
+		return nil
+	}, &ListOpts{Node: []string{"none"}})
+	assert.NoError(t, err)
+}
+
+func TestGzipResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusOK)
+		zw := gzip.NewWriter(w)
+		_, _ = fmt.Fprint(zw, `{"version":"compressed"}`)
+		_ = zw.Close()
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	// Compression must work even if the transport does not handle it.
+	cl, err := NewClient(BaseURL(u), HTTPClient(&http.Client{Transport: &http.Transport{DisableCompression: true}}))
+	require.NoError(t, err)
+
+	version, err := cl.Controller.GetVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "compressed", version.Version)
+}
+
+func TestConditionalRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, `{"version":"v1"}`)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	cl, err := NewClient(BaseURL(u), HTTPClient(srv.Client()))
+	require.NoError(t, err)
+
+	cond := &Conditional{}
+	version, err := cl.Controller.GetVersion(WithConditional(context.Background(), cond))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", version.Version)
+	assert.Equal(t, `"v1"`, cond.ETag)
+
+	_, err = cl.Controller.GetVersion(WithConditional(context.Background(), cond))
+	assert.ErrorIs(t, err, ErrNotModified)
+	assert.True(t, cond.NotModified)
+}
+
+func TestLayerStackValidatedBeforeRequest(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprint(w, `[]`)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	cl, err := NewClient(BaseURL(u), HTTPClient(srv.Client()))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	var lsErr *LayerStackError
+
+	err = cl.ResourceDefinitions.Create(ctx, ResourceDefinitionCreate{ResourceDefinition: ResourceDefinition{
+		Name:      "rsc1",
+		LayerData: []ResourceDefinitionLayer{{Type: devicelayerkind.Storage}, {Type: devicelayerkind.Drbd}},
+	}})
+	assert.ErrorAs(t, err, &lsErr)
+
+	err = cl.Resources.Autoplace(ctx, "rsc1", AutoPlaceRequest{LayerList: []devicelayerkind.DeviceLayerKind{devicelayerkind.Drbd}})
+	assert.ErrorAs(t, err, &lsErr)
+
+	err = cl.ResourceGroups.Spawn(ctx, "rg1", ResourceGroupSpawn{SelectFilter: AutoSelectFilter{LayerStack: []string{"storage", "luks"}}})
+	assert.ErrorAs(t, err, &lsErr)
+
+	assert.Empty(t, requests)
+
+	// Valid stacks are sent, layer names in select filters are not case-sensitive.
+	err = cl.ResourceGroups.Spawn(ctx, "rg1", ResourceGroupSpawn{SelectFilter: AutoSelectFilter{LayerStack: []string{"drbd", "STORAGE"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /v1/resource-groups/rg1/spawn"}, requests)
+}
+
+func TestScheduleCreateAndEnable(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, `[]`)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	cl, err := NewClient(BaseURL(u), HTTPClient(srv.Client()))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	keep := int32(3)
+
+	err = cl.Schedules.Create(ctx, Schedule{ScheduleName: "nightly", FullCron: "0 3 * * *", MaxRetries: &keep})
+	assert.Error(t, err)
+	err = cl.Schedules.Create(ctx, Schedule{ScheduleName: "nightly", FullCron: "0 3 * * *", OnFailure: ScheduleOnFailureRetry, MaxRetries: &keep})
+	assert.NoError(t, err)
+
+	err = cl.Schedules.Enable(ctx, "s3", "nightly", ScheduleBackupEnable{RscName: "rsc1", GrpName: "grp1"})
+	assert.Error(t, err)
+	err = cl.Schedules.Enable(ctx, "s3", "nightly", ScheduleBackupEnable{GrpName: "grp1"})
+	assert.NoError(t, err)
+
+	err = cl.Schedules.DeleteConfig(ctx, "s3", "nightly", ScheduleBackupDeleteOpts{GrpName: "grp1"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"POST /v1/schedules",
+		"PUT /v1/remotes/s3/backups/schedule/nightly/enable",
+		"DELETE /v1/remotes/s3/backups/schedule/nightly/delete?rsc_grp_name=grp1",
+	}, requests)
+}
+
+func TestS3RemoteValidation(t *testing.T) {
+	valid := S3Remote{
+		RemoteName: "s3",
+		Endpoint:   "s3.us-west-2.amazonaws.com",
+		Bucket:     "my-bucket",
+		Region:     "us-west-2",
+		AccessKey:  "access",
+		SecretKey:  "secret",
+	}
+
+	cases := []struct {
+		name    string
+		modify  func(r *S3Remote)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(r *S3Remote) {}},
+		{name: "valid-url-endpoint", modify: func(r *S3Remote) { r.Endpoint = "http://minio.local:9000/" }},
+		{name: "missing-region", modify: func(r *S3Remote) { r.Region = "" }, wantErr: true},
+		{name: "endpoint-with-path", modify: func(r *S3Remote) { r.Endpoint = "https://s3.example.com/bucket" }, wantErr: true},
+		{name: "endpoint-bad-scheme", modify: func(r *S3Remote) { r.Endpoint = "ftp://s3.example.com" }, wantErr: true},
+		{name: "bucket-uppercase", modify: func(r *S3Remote) { r.Bucket = "MyBucket" }, wantErr: true},
+		{name: "bucket-ip", modify: func(r *S3Remote) { r.Bucket = "192.168.1.1" }, wantErr: true},
+		{name: "bucket-dots-virtual-host", modify: func(r *S3Remote) { r.Bucket = "my.bucket" }, wantErr: true},
+		{name: "bucket-dots-path-style", modify: func(r *S3Remote) { r.Bucket = "my.bucket"; r.UsePathStyle = true }},
+		{name: "region-invalid", modify: func(r *S3Remote) { r.Region = "US West" }, wantErr: true},
+	}
+
+	for _, tcase := range cases {
+		t.Run(tcase.name, func(t *testing.T) {
+			r := valid
+			tcase.modify(&r)
+			err := r.validate(true)
+			if tcase.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Modifications only validate the fields that are set.
+	assert.NoError(t, (&S3Remote{SecretKey: "new"}).validate(false))
+	assert.Error(t, (&S3Remote{Bucket: "-bucket"}).validate(false))
+}
+
+func TestRemoteCheck(t *testing.T) {
+	retCode := func(mask uint64) int64 { return int64(mask) }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/remotes/good/backups":
+			w.WriteHeader(http.StatusOK)
+			_, _ = fmt.Fprint(w, `{}`)
+		case "/v1/remotes/badcreds/backups":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(w, `[{"ret_code":%d,"message":"Failed to list backups"}]`, retCode(linstor.FailAccDeniedRemote))
+		case "/v1/remotes/nobucket/backups":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(w, `[{"ret_code":%d,"message":"Failed to list backups","cause":"NoSuchBucket"}]`, retCode(linstor.FailInvldBackupConfig))
+		case "/v1/remotes/broken/backups":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(w, `[{"ret_code":%d,"message":"InvalidAccessKeyId"}]`, retCode(linstor.MaskError))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	cl, err := NewClient(BaseURL(u), HTTPClient(srv.Client()))
+	require.NoError(t, err)
+
+	assert.NoError(t, cl.Remote.Check(context.Background(), "good"))
+
+	for remote, reason := range map[string]RemoteCheckReason{
+		"badcreds": RemoteCheckCredentials,
+		"nobucket": RemoteCheckBucket,
+		"broken":   RemoteCheckUnknown,
+		"missing":  RemoteCheckNotFound,
+	} {
+		err := cl.Remote.Check(context.Background(), remote)
+		var checkErr *RemoteCheckError
+		require.ErrorAs(t, err, &checkErr, remote)
+		assert.Equal(t, reason, checkErr.Reason, remote)
+	}
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// CoalesceRequests is a client's option to share in-flight GET requests between callers.
+//
+// If enabled, identical GET requests (same URL, same query parameters and same credentials) that are issued while
+// another such request is still waiting for a response will not be sent to the controller again. Instead, all
+// callers receive the response of the request already in flight.
+//
+// Every caller can still cancel its own wait using its context. The shared request is only cancelled once all
+// callers waiting for it have given up.
+//
+// This is independent of any time-based caching, a response is only shared while the request is in flight.
+func CoalesceRequests() Option {
+	return func(c *Client) error {
+		c.coalescer = &coalescer{calls: make(map[string]*coalescedCall)}
+		return nil
+	}
+}
+
+// coalescer implements request deduplication, similar to golang.org/x/sync/singleflight, but with per-caller
+// context cancellation.
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done    chan struct{}
+	waiters int
+	cancel  context.CancelFunc
+
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+// do runs fn once for all concurrent callers using the same key.
+//
+// The function is called with a context that is detached from the callers' contexts: it keeps the values of the
+// first caller's context, but is only cancelled once no caller is waiting for the result anymore.
+func (g *coalescer) do(ctx context.Context, key string, fn func(ctx context.Context) (*http.Response, []byte, error)) (*http.Response, []byte, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		sharedCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+
+		go func() {
+			resp, body, err := fn(sharedCtx)
+			cancel()
+
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.resp, call.body, call.err = resp, body, err
+			g.mu.Unlock()
+
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, nil, call.err
+		}
+
+		// Every caller gets its own copy of the response. The body was already consumed.
+		resp := *call.resp
+		resp.Header = call.resp.Header.Clone()
+		resp.Body = http.NoBody
+		return &resp, call.body, nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			// Ensure new callers do not join a call that is about to be cancelled.
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+
+		return nil, nil, ctx.Err()
+	}
+}
+
+// coalesceKey identifies identical requests. Conditional requests are only shared with requests using the same
+// validator, otherwise a caller could receive a "304 Not Modified" it did not ask for.
+func coalesceKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String() + "\x00" + req.Header.Get("Authorization") + "\x00" + req.Header.Get("If-None-Match")
+}
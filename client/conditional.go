@@ -0,0 +1,111 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Conditional holds the state of a conditional GET request.
+//
+// Attach it to the context of a Get* call using WithConditional. If ETag is set, it is sent to the controller as
+// "If-None-Match" header. If the controller reports that the response did not change, NotModified is set and the
+// Get* call returns ErrNotModified, without a result. Otherwise, ETag is updated to the value returned by the
+// controller, if any.
+type Conditional struct {
+	// ETag of the last response known to the caller.
+	ETag string
+	// NotModified is set if the controller reported that the response did not change since ETag.
+	NotModified bool
+}
+
+// ErrNotModified is returned by conditional GET requests if the response did not change since Conditional.ETag.
+const ErrNotModified = clientError("304 Not Modified")
+
+type conditionalKey struct{}
+
+// WithConditional returns a context that makes GET requests conditional on the given state.
+//
+// Controllers that do not support conditional requests will always send a full response, so callers must be
+// prepared to handle both cases.
+func WithConditional(ctx context.Context, cond *Conditional) context.Context {
+	return context.WithValue(ctx, conditionalKey{}, cond)
+}
+
+func conditionalFrom(ctx context.Context) *Conditional {
+	cond, _ := ctx.Value(conditionalKey{}).(*Conditional)
+	return cond
+}
+
+// prepareConditional sets the "If-None-Match" header, if requested by the context.
+func prepareConditional(ctx context.Context, req *http.Request) {
+	cond := conditionalFrom(ctx)
+	if cond == nil {
+		return
+	}
+
+	cond.NotModified = false
+	if cond.ETag != "" {
+		req.Header.Set("If-None-Match", cond.ETag)
+	}
+}
+
+// finishConditional updates the conditional state of the context based on the response.
+// It returns true if the response did not change, in which case there is no body to decode.
+func finishConditional(ctx context.Context, resp *http.Response) bool {
+	if resp.StatusCode == http.StatusNotModified {
+		if cond := conditionalFrom(ctx); cond != nil {
+			cond.NotModified = true
+		}
+		return true
+	}
+
+	if cond := conditionalFrom(ctx); cond != nil {
+		cond.ETag = resp.Header.Get("ETag")
+	}
+
+	return false
+}
+
+// decompress transparently decodes gzip compressed response bodies.
+//
+// We request compression ourselves instead of relying on http.Transport, so that it also works with custom
+// transports, for example ones that disable compression.
+func decompress(resp *http.Response) {
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	resp.Body = &gzipBody{body: resp.Body}
+}
+
+// gzipBody lazily creates the gzip reader: empty bodies, such as in "304 Not Modified" responses, would otherwise
+// fail to decode.
+type gzipBody struct {
+	body io.ReadCloser
+
+	once sync.Once
+	zr   *gzip.Reader
+	err  error
+}
+
+func (g *gzipBody) Read(p []byte) (int, error) {
+	g.once.Do(func() {
+		g.zr, g.err = gzip.NewReader(g.body)
+	})
+	if g.err != nil {
+		return 0, g.err
+	}
+
+	return g.zr.Read(p)
+}
+
+func (g *gzipBody) Close() error {
+	return g.body.Close()
+}
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials are the authentication details attached to a single request.
+//
+// If BearerToken is set, it takes precedence over BasicAuth.
+type Credentials struct {
+	BasicAuth   *BasicAuthCfg
+	BearerToken string
+	// Expiry is the point in time after which the credentials should no longer be used. A zero value means
+	// the credentials do not expire.
+	Expiry time.Time
+}
+
+// expired reports whether the credentials are (about to be) expired at the given time.
+func (c *Credentials) expired(now time.Time) bool {
+	// Treat credentials that expire in the next few seconds as expired, so that we do not send a request with a
+	// token that becomes invalid while the request is in flight.
+	return !c.Expiry.IsZero() && now.Add(credentialExpirySkew).After(c.Expiry)
+}
+
+const credentialExpirySkew = 10 * time.Second
+
+// apply sets the authentication headers on the given request.
+func (c *Credentials) apply(req *http.Request) {
+	req.Header.Del("Authorization")
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	} else if c.BasicAuth != nil && c.BasicAuth.Username != "" {
+		req.SetBasicAuth(c.BasicAuth.Username, c.BasicAuth.Password)
+	}
+}
+
+// ErrCredentialsNotRefreshable is returned by CredentialProvider.Refresh if the provider has no way of fetching
+// new credentials.
+var ErrCredentialsNotRefreshable = errors.New("credentials can not be refreshed")
+
+// CredentialProvider supplies the credentials used to authenticate against the LINSTOR controller.
+//
+// Credentials is called before every request. If the controller rejects a request with "401 Unauthorized",
+// Refresh is called and, if it returns without error, the request is retried once with the new credentials.
+// Implementations must be safe for concurrent use.
+type CredentialProvider interface {
+	// Credentials returns the credentials to use for the next request.
+	Credentials(ctx context.Context) (*Credentials, error)
+	// Refresh discards any cached credentials, so that the next call to Credentials returns fresh ones.
+	Refresh(ctx context.Context) error
+}
+
+// StaticCredentialProvider always returns the same credentials.
+type StaticCredentialProvider struct {
+	Static Credentials
+}
+
+var _ CredentialProvider = &StaticCredentialProvider{}
+
+func (s *StaticCredentialProvider) Credentials(_ context.Context) (*Credentials, error) {
+	return &s.Static, nil
+}
+
+func (s *StaticCredentialProvider) Refresh(_ context.Context) error {
+	return ErrCredentialsNotRefreshable
+}
+
+// EnvCredentialProvider reads credentials from environment variables on every request.
+//
+// Empty variable names default to LS_USERNAME, LS_PASSWORD and LS_BEARER_TOKEN_FILE respectively.
+type EnvCredentialProvider struct {
+	UsernameVar        string
+	PasswordVar        string
+	BearerTokenFileVar string
+}
+
+var _ CredentialProvider = &EnvCredentialProvider{}
+
+func (e *EnvCredentialProvider) Credentials(_ context.Context) (*Credentials, error) {
+	creds := &Credentials{
+		BasicAuth: &BasicAuthCfg{
+			Username: os.Getenv(orDefault(e.UsernameVar, UsernameEnv)),
+			Password: os.Getenv(orDefault(e.PasswordVar, PasswordEnv)),
+		},
+	}
+
+	if path, ok := os.LookupEnv(orDefault(e.BearerTokenFileVar, BearerTokenFileEnv)); ok {
+		token, err := tokenFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token from file: %w", err)
+		}
+
+		creds.BearerToken = token
+	}
+
+	return creds, nil
+}
+
+func (e *EnvCredentialProvider) Refresh(_ context.Context) error {
+	// Nothing is cached, the next call to Credentials will read the environment again.
+	return nil
+}
+
+// FileCredentialProvider reads a bearer token from a file, using the same format as BearerTokenFromFile.
+//
+// The token is cached and only read again after Refresh is called, i.e. after the controller rejected the current
+// token.
+type FileCredentialProvider struct {
+	Path string
+
+	mu     sync.Mutex
+	cached *Credentials
+}
+
+var _ CredentialProvider = &FileCredentialProvider{}
+
+func (f *FileCredentialProvider) Credentials(_ context.Context) (*Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.cached == nil {
+		token, err := tokenFromFile(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token from file: %w", err)
+		}
+
+		f.cached = &Credentials{BearerToken: token}
+	}
+
+	return f.cached, nil
+}
+
+func (f *FileCredentialProvider) Refresh(_ context.Context) error {
+	f.mu.Lock()
+	f.cached = nil
+	f.mu.Unlock()
+	return nil
+}
+
+// ExecCredentialProvider runs an external command to fetch credentials, similar to the exec credential plugins
+// known from kubectl.
+//
+// The command is expected to print a JSON document to stdout:
+//
+//	{
+//	  "status": {
+//	    "token": "...",
+//	    "expirationTimestamp": "2006-01-02T15:04:05Z"
+//	  }
+//	}
+//
+// Instead of "token", "username" and "password" may be used for basic authentication. The result is cached until
+// it expires or Refresh is called. Documents in the kubectl "ExecCredential" format are accepted as-is.
+type ExecCredentialProvider struct {
+	// Command is the executable to run.
+	Command string
+	// Args are passed to the command.
+	Args []string
+	// Env are additional "KEY=value" pairs added to the environment of the command.
+	Env []string
+
+	mu     sync.Mutex
+	cached *Credentials
+}
+
+var _ CredentialProvider = &ExecCredentialProvider{}
+
+type execCredential struct {
+	Status *execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	Token               string     `json:"token,omitempty"`
+	Username            string     `json:"username,omitempty"`
+	Password            string     `json:"password,omitempty"`
+	ExpirationTimestamp *time.Time `json:"expirationTimestamp,omitempty"`
+}
+
+func (e *ExecCredentialProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.cached != nil && !e.cached.expired(time.Now()) {
+		return e.cached, nil
+	}
+
+	creds, err := e.run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e.cached = creds
+	return creds, nil
+}
+
+func (e *ExecCredentialProvider) Refresh(_ context.Context) error {
+	e.mu.Lock()
+	e.cached = nil
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *ExecCredentialProvider) run(ctx context.Context) (*Credentials, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Env = append(os.Environ(), e.Env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential command '%s' failed: %w: %s", e.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out execCredential
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("failed to parse output of credential command '%s': %w", e.Command, err)
+	}
+
+	if out.Status == nil {
+		return nil, fmt.Errorf("output of credential command '%s' is missing 'status'", e.Command)
+	}
+
+	creds := &Credentials{BearerToken: out.Status.Token}
+	if out.Status.Username != "" {
+		creds.BasicAuth = &BasicAuthCfg{Username: out.Status.Username, Password: out.Status.Password}
+	}
+
+	if creds.BearerToken == "" && creds.BasicAuth == nil {
+		return nil, fmt.Errorf("credential command '%s' returned neither token nor username", e.Command)
+	}
+
+	if out.Status.ExpirationTimestamp != nil {
+		creds.Expiry = *out.Status.ExpirationTimestamp
+	}
+
+	return creds, nil
+}
+
+func orDefault(s, dflt string) string {
+	if s == "" {
+		return dflt
+	}
+	return s
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/LINBIT/golinstor/devicelayerkind"
+)
+
+// DrbdVolume returns the data of a DRBD volume layer.
+func (v *VolumeLayer) DrbdVolume() (*DrbdVolume, bool) {
+	d, ok := v.Data.(*DrbdVolume)
+	return d, ok && d != nil
+}
+
+// LuksVolume returns the data of a LUKS volume layer.
+func (v *VolumeLayer) LuksVolume() (*LuksVolume, bool) {
+	d, ok := v.Data.(*LuksVolume)
+	return d, ok && d != nil
+}
+
+// StorageVolume returns the data of a STORAGE volume layer.
+func (v *VolumeLayer) StorageVolume() (*StorageVolume, bool) {
+	d, ok := v.Data.(*StorageVolume)
+	return d, ok && d != nil
+}
+
+// NvmeVolume returns the data of an NVME volume layer.
+func (v *VolumeLayer) NvmeVolume() (*NvmeVolume, bool) {
+	d, ok := v.Data.(*NvmeVolume)
+	return d, ok && d != nil
+}
+
+// WritecacheVolume returns the data of a WRITECACHE volume layer.
+func (v *VolumeLayer) WritecacheVolume() (*WritecacheVolume, bool) {
+	d, ok := v.Data.(*WritecacheVolume)
+	return d, ok && d != nil
+}
+
+// CacheVolume returns the data of a CACHE volume layer.
+func (v *VolumeLayer) CacheVolume() (*CacheVolume, bool) {
+	d, ok := v.Data.(*CacheVolume)
+	return d, ok && d != nil
+}
+
+// BCacheVolume returns the data of a BCACHE volume layer.
+func (v *VolumeLayer) BCacheVolume() (*BCacheVolume, bool) {
+	d, ok := v.Data.(*BCacheVolume)
+	return d, ok && d != nil
+}
+
+// DevicePath returns the block device provided by this layer, or "" if it is not known.
+func (v *VolumeLayer) DevicePath() string {
+	switch d := v.Data.(type) {
+	case *DrbdVolume:
+		return d.DevicePath
+	case *LuksVolume:
+		return d.DevicePath
+	case *StorageVolume:
+		return d.DevicePath
+	case *NvmeVolume:
+		return d.DevicePath
+	case *WritecacheVolume:
+		return d.DevicePath
+	case *CacheVolume:
+		return d.DevicePath
+	case *BCacheVolume:
+		return d.DevicePath
+	}
+
+	return ""
+}
+
+// DiskState returns the state of this layer, or "" if it is not known.
+func (v *VolumeLayer) DiskState() string {
+	switch d := v.Data.(type) {
+	case *DrbdVolume:
+		return d.DiskState
+	case *LuksVolume:
+		return d.DiskState
+	case *StorageVolume:
+		return d.DiskState
+	case *NvmeVolume:
+		return d.DiskState
+	case *WritecacheVolume:
+		return d.DiskState
+	case *CacheVolume:
+		return d.DiskState
+	case *BCacheVolume:
+		return d.DiskState
+	}
+
+	return ""
+}
+
+// LayerStack returns the layers of the volume, from top to bottom, as reported in LayerDataList.
+func (v *Volume) LayerStack() []devicelayerkind.DeviceLayerKind {
+	stack := make([]devicelayerkind.DeviceLayerKind, 0, len(v.LayerDataList))
+	for _, l := range v.LayerDataList {
+		stack = append(stack, l.Type)
+	}
+
+	return stack
+}
+
+// BackingDevice returns the device of the bottom layer of the volume, usually the storage layer.
+func (v *Volume) BackingDevice() string {
+	if len(v.LayerDataList) == 0 {
+		return ""
+	}
+
+	return v.LayerDataList[len(v.LayerDataList)-1].DevicePath()
+}
+
+// SkipChildren can be returned by a WalkFunc to skip the children of the current layer.
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is called by ResourceLayer.Walk for every layer. Depth is 0 for the layer Walk was called on.
+type WalkFunc func(layer *ResourceLayer, depth int) error
+
+// Walk calls fn for the layer and all of its descendants, depth first, parents before children. If fn returns
+// SkipChildren, the children of that layer are skipped. Any other error stops the walk and is returned.
+func (l *ResourceLayer) Walk(fn WalkFunc) error {
+	err := l.walk(fn, 0)
+	if errors.Is(err, SkipChildren) {
+		return nil
+	}
+
+	return err
+}
+
+func (l *ResourceLayer) walk(fn WalkFunc, depth int) error {
+	if l == nil {
+		return nil
+	}
+
+	if err := fn(l, depth); err != nil {
+		return err
+	}
+
+	for i := range l.Children {
+		err := l.Children[i].walk(fn, depth+1)
+		if errors.Is(err, SkipChildren) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find returns the first layer of the given kind, searching depth first, or nil if there is none.
+func (l *ResourceLayer) Find(kind devicelayerkind.DeviceLayerKind) *ResourceLayer {
+	var found *ResourceLayer
+	_ = l.Walk(func(layer *ResourceLayer, _ int) error {
+		if layer.Type == kind {
+			found = layer
+			return errStopWalk
+		}
+		return nil
+	})
+
+	return found
+}
+
+var errStopWalk = errors.New("stop walk")
+
+// dataChild returns the child that holds the data of the layer, ignoring children for metadata or caches, which
+// have a resource name suffix such as ".meta" or ".dcache".
+func (l *ResourceLayer) dataChild() *ResourceLayer {
+	for i := range l.Children {
+		if l.Children[i].ResourceNameSuffix == l.ResourceNameSuffix {
+			return &l.Children[i]
+		}
+	}
+
+	if len(l.Children) > 0 {
+		return &l.Children[0]
+	}
+
+	return nil
+}
+
+// LayerStack returns the layers on the data path of the resource, from top to bottom. Layers for external metadata
+// or caches are not included.
+func (l *ResourceLayer) LayerStack() []devicelayerkind.DeviceLayerKind {
+	var stack []devicelayerkind.DeviceLayerKind
+	for cur := l; cur != nil; cur = cur.dataChild() {
+		stack = append(stack, cur.Type)
+	}
+
+	return stack
+}
+
+// Volume returns the data of volume volNr in this layer. The returned VolumeLayer points into the ResourceLayer,
+// so its typed accessors can be used.
+func (l *ResourceLayer) Volume(volNr int32) (VolumeLayer, bool) {
+	if l == nil {
+		return VolumeLayer{}, false
+	}
+
+	v := VolumeLayer{Type: l.Type}
+	switch {
+	case l.Drbd != nil:
+		for i := range l.Drbd.DrbdVolumes {
+			if l.Drbd.DrbdVolumes[i].DrbdVolumeDefinition.VolumeNumber == volNr {
+				v.Data = &l.Drbd.DrbdVolumes[i]
+			}
+		}
+	case l.Luks != nil:
+		for i := range l.Luks.StorageVolumes {
+			if l.Luks.StorageVolumes[i].VolumeNumber == volNr {
+				v.Data = &l.Luks.StorageVolumes[i]
+			}
+		}
+	case l.Storage != nil:
+		for i := range l.Storage.StorageVolumes {
+			if l.Storage.StorageVolumes[i].VolumeNumber == volNr {
+				v.Data = &l.Storage.StorageVolumes[i]
+			}
+		}
+	case l.Nvme != nil:
+		for i := range l.Nvme.NvmeVolumes {
+			if l.Nvme.NvmeVolumes[i].VolumeNumber == volNr {
+				v.Data = &l.Nvme.NvmeVolumes[i]
+			}
+		}
+	case l.Writecache != nil:
+		for i := range l.Writecache.WritecacheVolumes {
+			if l.Writecache.WritecacheVolumes[i].VolumeNumber == volNr {
+				v.Data = &l.Writecache.WritecacheVolumes[i]
+			}
+		}
+	case l.Cache != nil:
+		for i := range l.Cache.CacheVolumes {
+			if l.Cache.CacheVolumes[i].VolumeNumber == volNr {
+				v.Data = &l.Cache.CacheVolumes[i]
+			}
+		}
+	case l.BCache != nil:
+		for i := range l.BCache.BCacheVolumes {
+			if l.BCache.BCacheVolumes[i].VolumeNumber == volNr {
+				v.Data = &l.BCache.BCacheVolumes[i]
+			}
+		}
+	}
+
+	return v, v.Data != nil
+}
+
+// TopDevicePath returns the device path of volume volNr at the top of the stack, i.e. the device used by
+// applications, such as /dev/drbd1000. It returns "" if the volume does not exist.
+func (l *ResourceLayer) TopDevicePath(volNr int32) string {
+	v, ok := l.Volume(volNr)
+	if !ok {
+		return ""
+	}
+
+	return v.DevicePath()
+}
+
+// BackingDevice returns the device path of volume volNr at the bottom of the data path, usually the logical
+// volume or zvol of the storage layer. It returns "" if the volume does not exist.
+func (l *ResourceLayer) BackingDevice(volNr int32) string {
+	if l == nil {
+		return ""
+	}
+
+	bottom := l
+	for child := l.dataChild(); child != nil; child = child.dataChild() {
+		bottom = child
+	}
+
+	v, ok := bottom.Volume(volNr)
+	if !ok {
+		return ""
+	}
+
+	return v.DevicePath()
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/devicelayerkind"
+)
+
+// LayerStackError describes why a layer stack is invalid, in general or on a specific node.
+type LayerStackError struct {
+	Stack []devicelayerkind.DeviceLayerKind
+	// Node is set if the error is specific to a node.
+	Node   string
+	Reason string
+}
+
+func (e *LayerStackError) Error() string {
+	stack := make([]string, len(e.Stack))
+	for i, l := range e.Stack {
+		stack[i] = string(l)
+	}
+
+	if e.Node != "" {
+		return fmt.Sprintf("layer stack [%s] on node '%s': %s", strings.Join(stack, ","), e.Node, e.Reason)
+	}
+
+	return fmt.Sprintf("layer stack [%s]: %s", strings.Join(stack, ","), e.Reason)
+}
+
+func isCacheLayer(kind devicelayerkind.DeviceLayerKind) bool {
+	switch kind {
+	case devicelayerkind.Writecache, devicelayerkind.Cache, devicelayerkind.Bcache:
+		return true
+	}
+
+	return false
+}
+
+// ValidateLayerStack checks that the layers of a stack, as used in ResourceCreate.LayerList,
+// AutoPlaceRequest.LayerList and ResourceDefinitionModify.LayerStack, are in a legal order:
+//   - every layer is used at most once,
+//   - STORAGE is the bottom layer,
+//   - DRBD is the top layer,
+//   - NVME is the top layer or directly below DRBD,
+//   - at most one of WRITECACHE, CACHE and BCACHE is used.
+//
+// An empty stack is valid, the controller then uses its default.
+//
+// ResourceDefinitions.Create, Resources.Autoplace and ResourceGroups.Spawn validate their layer stacks before
+// sending the request.
+func ValidateLayerStack(stack []devicelayerkind.DeviceLayerKind) error {
+	if len(stack) == 0 {
+		return nil
+	}
+
+	fail := func(format string, args ...interface{}) error {
+		return &LayerStackError{Stack: stack, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	seen := make(map[devicelayerkind.DeviceLayerKind]bool, len(stack))
+	cache := devicelayerkind.DeviceLayerKind("")
+	for i, kind := range stack {
+		switch kind {
+		case devicelayerkind.Drbd, devicelayerkind.Luks, devicelayerkind.Storage, devicelayerkind.Nvme,
+			devicelayerkind.Writecache, devicelayerkind.Cache, devicelayerkind.Bcache:
+		default:
+			return fail("unknown layer '%s'", kind)
+		}
+
+		if seen[kind] {
+			return fail("layer %s is used more than once", kind)
+		}
+		seen[kind] = true
+
+		switch {
+		case kind == devicelayerkind.Storage && i != len(stack)-1:
+			return fail("%s must be the bottom layer", kind)
+		case kind == devicelayerkind.Drbd && i != 0:
+			return fail("%s must be the top layer", kind)
+		case kind == devicelayerkind.Nvme && i != 0 && stack[i-1] != devicelayerkind.Drbd:
+			return fail("%s must be the top layer or directly below %s", kind, devicelayerkind.Drbd)
+		case isCacheLayer(kind):
+			if cache != "" {
+				return fail("%s and %s cannot be combined", cache, kind)
+			}
+			cache = kind
+		}
+	}
+
+	if stack[len(stack)-1] != devicelayerkind.Storage {
+		return fail("the bottom layer must be %s", devicelayerkind.Storage)
+	}
+
+	return nil
+}
+
+// validateFilterLayerStack validates AutoSelectFilter.LayerStack, where the layers are given as strings.
+func validateFilterLayerStack(filter AutoSelectFilter) error {
+	stack := make([]devicelayerkind.DeviceLayerKind, len(filter.LayerStack))
+	for i, l := range filter.LayerStack {
+		stack[i] = devicelayerkind.DeviceLayerKind(strings.ToUpper(l))
+	}
+
+	return ValidateLayerStack(stack)
+}
+
+// ValidateLayerStackOnNode checks that the node supports all layers of the stack and the given storage providers,
+// using Node.ResourceLayers, Node.StorageProviders and the reasons in Node.UnsupportedLayers and
+// Node.UnsupportedProviders. Nodes that do not report their capabilities are only checked against the unsupported
+// lists. All problems are returned, joined into one error.
+func ValidateLayerStackOnNode(stack []devicelayerkind.DeviceLayerKind, node Node, providers ...ProviderKind) error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, &LayerStackError{Stack: stack, Node: node.Name, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	for _, kind := range stack {
+		if reasons, ok := node.UnsupportedLayers[kind]; ok {
+			fail("layer %s is not supported%s", kind, formatReasons(reasons))
+			continue
+		}
+
+		if len(node.ResourceLayers) > 0 && !slices.Contains(node.ResourceLayers, kind) {
+			fail("layer %s is not supported", kind)
+		}
+	}
+
+	for _, provider := range providers {
+		if provider == "" {
+			continue
+		}
+
+		if reasons, ok := node.UnsupportedProviders[provider]; ok {
+			fail("storage provider %s is not supported%s", provider, formatReasons(reasons))
+			continue
+		}
+
+		if len(node.StorageProviders) > 0 && !slices.Contains(node.StorageProviders, provider) {
+			fail("storage provider %s is not supported", provider)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateNvmeResources checks the NVMe target and initiator rules for the resources of one resource definition,
+// including those that already exist. If NVME is the top layer of the stack, there must be exactly one target, a
+// resource without the NVME_INITIATOR flag, and at most one initiator. Stacks without NVME at the top are not
+// checked.
+func ValidateNvmeResources(stack []devicelayerkind.DeviceLayerKind, resources ...Resource) error {
+	if len(stack) == 0 || stack[0] != devicelayerkind.Nvme {
+		return nil
+	}
+
+	var targets, initiators []string
+	for _, rsc := range resources {
+		if slices.Contains(rsc.Flags, linstor.FlagNvmeInitiator) {
+			initiators = append(initiators, rsc.NodeName)
+		} else {
+			targets = append(targets, rsc.NodeName)
+		}
+	}
+
+	switch {
+	case len(targets) > 1:
+		return &LayerStackError{Stack: stack, Reason: fmt.Sprintf("only one NVMe target is allowed, got %s", strings.Join(targets, ", "))}
+	case len(initiators) > 1:
+		return &LayerStackError{Stack: stack, Reason: fmt.Sprintf("only one NVMe initiator is allowed, got %s", strings.Join(initiators, ", "))}
+	case len(initiators) > 0 && len(targets) == 0:
+		return &LayerStackError{Stack: stack, Reason: "NVMe initiator requires a target"}
+	}
+
+	return nil
+}
+
+func formatReasons(reasons []string) string {
+	if len(reasons) == 0 {
+		return ""
+	}
+
+	return ": " + strings.Join(reasons, "; ")
+}
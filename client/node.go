@@ -150,6 +150,9 @@ type NodeProvider interface {
 	DeleteNetinterface(ctx context.Context, nodeName, nifName string) error
 	// GetStoragePoolView gets information about all storage pools in the cluster.
 	GetStoragePoolView(ctx context.Context, opts ...*ListOpts) ([]StoragePool, error)
+	// StreamStoragePoolView works like GetStoragePoolView, but decodes the response one storage pool at a time,
+	// passing each to fn. If fn returns an error, the iteration stops and the error is returned.
+	StreamStoragePoolView(ctx context.Context, fn func(StoragePool) error, opts ...*ListOpts) error
 	// GetStoragePools gets information about all storage pools on a given node.
 	GetStoragePools(ctx context.Context, nodeName string, opts ...*ListOpts) ([]StoragePool, error)
 	// GetStoragePool gets information about a specific storage pool on a given node.
@@ -282,6 +285,12 @@ func (n *NodeService) GetStoragePoolView(ctx context.Context, opts ...*ListOpts)
 	return sps, err
 }
 
+// StreamStoragePoolView works like GetStoragePoolView, but decodes the response one storage pool at a time, passing
+// each to fn. If fn returns an error, the iteration stops and the error is returned.
+func (n *NodeService) StreamStoragePoolView(ctx context.Context, fn func(StoragePool) error, opts ...*ListOpts) error {
+	return doGETStream(ctx, n.client, "/v1/view/storage-pools", fn, opts...)
+}
+
 // GetStoragePools gets information about all storage pools on a given node.
 func (n *NodeService) GetStoragePools(ctx context.Context, nodeName string, opts ...*ListOpts) ([]StoragePool, error) {
 	var sps []StoragePool
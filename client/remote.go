@@ -2,9 +2,16 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
 
 	"github.com/google/go-querystring/query"
+
+	linstor "github.com/LINBIT/golinstor"
 )
 
 type LinstorRemote struct {
@@ -62,6 +69,9 @@ type RemoteProvider interface {
 	ModifyS3(ctx context.Context, remoteName string, modify S3Remote) error
 	// ModifyEbs modifies the given EBS remote.
 	ModifyEbs(ctx context.Context, remoteName string, modify EbsRemote) error
+	// Check verifies that the controller can access the given S3 remote by listing the backups stored there.
+	// Problems are reported as *RemoteCheckError.
+	Check(ctx context.Context, remoteName string) error
 }
 
 var _ RemoteProvider = &RemoteService{}
@@ -100,6 +110,10 @@ func (r *RemoteService) CreateLinstor(ctx context.Context, create LinstorRemote)
 }
 
 func (r *RemoteService) CreateS3(ctx context.Context, create S3Remote) error {
+	if err := create.validate(true); err != nil {
+		return err
+	}
+
 	_, err := r.client.doPOST(ctx, "/v1/remotes/s3", create)
 	return err
 }
@@ -127,6 +141,10 @@ func (r *RemoteService) ModifyLinstor(ctx context.Context, remoteName string, mo
 }
 
 func (r *RemoteService) ModifyS3(ctx context.Context, remoteName string, modify S3Remote) error {
+	if err := modify.validate(false); err != nil {
+		return err
+	}
+
 	_, err := r.client.doPUT(ctx, "/v1/remotes/s3/"+remoteName, modify)
 	return err
 }
@@ -135,3 +153,133 @@ func (r *RemoteService) ModifyEbs(ctx context.Context, remoteName string, modify
 	_, err := r.client.doPUT(ctx, "/v1/remotes/ebs/"+remoteName, modify)
 	return err
 }
+
+func (r *RemoteService) Check(ctx context.Context, remoteName string) error {
+	var list BackupList
+	_, err := r.client.doGET(ctx, "/v1/remotes/"+remoteName+"/backups", &list)
+	if err != nil {
+		return &RemoteCheckError{RemoteName: remoteName, Reason: remoteCheckReason(err), Err: err}
+	}
+
+	return nil
+}
+
+// RemoteCheckReason classifies why a remote check failed.
+type RemoteCheckReason string
+
+const (
+	// RemoteCheckNotFound means the remote is not registered in LINSTOR.
+	RemoteCheckNotFound RemoteCheckReason = "NotFound"
+	// RemoteCheckCredentials means the remote denied access, for example because of a wrong access or secret key.
+	RemoteCheckCredentials RemoteCheckReason = "Credentials"
+	// RemoteCheckBucket means the controller considers the remote's configuration invalid, for example because the
+	// bucket does not exist.
+	RemoteCheckBucket RemoteCheckReason = "Bucket"
+	// RemoteCheckUnknown is used for all other errors, for example if the endpoint is not reachable.
+	RemoteCheckUnknown RemoteCheckReason = "Unknown"
+)
+
+// RemoteCheckError is returned by RemoteProvider.Check.
+type RemoteCheckError struct {
+	RemoteName string
+	Reason     RemoteCheckReason
+	Err        error
+}
+
+func (e *RemoteCheckError) Error() string {
+	return fmt.Sprintf("remote '%s' check failed (%s): %v", e.RemoteName, e.Reason, e.Err)
+}
+
+func (e *RemoteCheckError) Unwrap() error {
+	return e.Err
+}
+
+func remoteCheckReason(err error) RemoteCheckReason {
+	switch {
+	case errors.Is(err, NotFoundError) || IsApiCallError(err, linstor.FailNotFoundRemote):
+		return RemoteCheckNotFound
+	case IsApiCallError(err, linstor.FailAccDeniedRemote):
+		return RemoteCheckCredentials
+	case IsApiCallError(err, linstor.FailInvldBackupConfig):
+		return RemoteCheckBucket
+	}
+
+	return RemoteCheckUnknown
+}
+
+// s3BucketName matches valid S3 bucket names, see
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
+var (
+	s3BucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	s3Region     = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// validate checks the S3 remote for obvious errors. If create is false, only the fields that are set are checked.
+func (s *S3Remote) validate(create bool) error {
+	if create {
+		required := []struct{ name, val string }{
+			{"remote name", s.RemoteName},
+			{"endpoint", s.Endpoint},
+			{"bucket", s.Bucket},
+			{"region", s.Region},
+			{"access key", s.AccessKey},
+			{"secret key", s.SecretKey},
+		}
+		for _, r := range required {
+			if r.val == "" {
+				return fmt.Errorf("s3 remote: %s is required", r.name)
+			}
+		}
+	}
+
+	if s.Endpoint != "" {
+		if err := validateS3Endpoint(s.Endpoint); err != nil {
+			return err
+		}
+	}
+
+	if s.Bucket != "" {
+		if !s3BucketName.MatchString(s.Bucket) || strings.Contains(s.Bucket, "..") || net.ParseIP(s.Bucket) != nil {
+			return fmt.Errorf("s3 remote: invalid bucket name '%s'", s.Bucket)
+		}
+
+		// Virtual-hosted style puts the bucket in the host name, which does not match the endpoint's TLS
+		// certificate if the bucket contains dots.
+		if create && strings.Contains(s.Bucket, ".") && !s.UsePathStyle {
+			return fmt.Errorf("s3 remote: bucket name '%s' contains dots, which requires path style access", s.Bucket)
+		}
+	}
+
+	if s.Region != "" && !s3Region.MatchString(s.Region) {
+		return fmt.Errorf("s3 remote: invalid region '%s'", s.Region)
+	}
+
+	return nil
+}
+
+// validateS3Endpoint accepts host names with optional port, with or without http(s) scheme.
+func validateS3Endpoint(endpoint string) error {
+	raw := endpoint
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("s3 remote: invalid endpoint '%s': %w", endpoint, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("s3 remote: invalid endpoint '%s': unsupported scheme '%s'", endpoint, u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("s3 remote: invalid endpoint '%s': missing host", endpoint)
+	}
+
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.User != nil {
+		return fmt.Errorf("s3 remote: invalid endpoint '%s': must not contain path, query or user info", endpoint)
+	}
+
+	return nil
+}
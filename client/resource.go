@@ -432,7 +432,8 @@ type ResourceProvider interface {
 	Diskful(ctx context.Context, resName, nodeName, storagePoolName string, props *ToggleDiskDiskfulProps) error
 	// Migrate mirgates a resource from one node to another node
 	Migrate(ctx context.Context, resName, fromNodeName, toNodeName, storagePoolName string) error
-	// Autoplace places a resource on your nodes autmatically
+	// Autoplace places a resource on your nodes autmatically. The layer stacks of the request are checked with
+	// ValidateLayerStack first.
 	Autoplace(ctx context.Context, resName string, apr AutoPlaceRequest) error
 	// GetConnections lists all resource connections if no node-names are given- if two node-names are given it shows the connection between them
 	GetConnections(ctx context.Context, resName, nodeAName, nodeBName string, opts ...*ListOpts) ([]ResourceConnection, error)
@@ -442,6 +443,12 @@ type ResourceProvider interface {
 	GetSnapshots(ctx context.Context, resName string, opts ...*ListOpts) ([]Snapshot, error)
 	// GetSnapshotView gets information about all snapshots
 	GetSnapshotView(ctx context.Context, opts ...*ListOpts) ([]Snapshot, error)
+	// StreamResourceView works like GetResourceView, but decodes the response one resource at a time, passing each
+	// to fn. If fn returns an error, the iteration stops and the error is returned.
+	StreamResourceView(ctx context.Context, fn func(ResourceWithVolumes) error, opts ...*ListOpts) error
+	// StreamSnapshotView works like GetSnapshotView, but decodes the response one snapshot at a time, passing each
+	// to fn. If fn returns an error, the iteration stops and the error is returned.
+	StreamSnapshotView(ctx context.Context, fn func(Snapshot) error, opts ...*ListOpts) error
 	// GetSnapshot returns information about a specific Snapshot by its name
 	GetSnapshot(ctx context.Context, resName, snapName string, opts ...*ListOpts) (Snapshot, error)
 	// CreateSnapshot creates a snapshot of a resource
@@ -456,6 +463,11 @@ type ResourceProvider interface {
 	RestoreVolumeDefinitionSnapshot(ctx context.Context, origResName, snapName string, snapRestoreConf SnapshotRestore) error
 	// RollbackSnapshot rolls back a snapshot from a specific resource
 	RollbackSnapshot(ctx context.Context, resName, snapName string) error
+	// CreateSnapshotShipping creates a new snapshot of the resource and ships it from one node to another.
+	CreateSnapshotShipping(ctx context.Context, resName string, ship SnapshotShipping) error
+	// GetSnapshotShippings lists the status of snapshot shippings. Filters can be set via ListOpts, including the
+	// shipping status.
+	GetSnapshotShippings(ctx context.Context, opts ...*ListOpts) ([]SnapshotShippingStatus, error)
 	// ModifyDRBDProxy is used to modify drbd-proxy properties
 	ModifyDRBDProxy(ctx context.Context, resName string, props DrbdProxyModify) error
 	// EnableDRBDProxy is used to enable drbd-proxy with the rest-api call from the function enableDisableDRBDProxy
@@ -548,6 +560,21 @@ func (v *VolumeLayer) UnmarshalJSON(b []byte) error {
 		}
 		v.Data = dst
 	case devicelayerkind.Cache:
+		dst := new(CacheVolume)
+		if vIn.Data != nil {
+			if err := json.Unmarshal(vIn.Data, &dst); err != nil {
+				return err
+			}
+		}
+		v.Data = dst
+	case devicelayerkind.Bcache:
+		dst := new(BCacheVolume)
+		if vIn.Data != nil {
+			if err := json.Unmarshal(vIn.Data, &dst); err != nil {
+				return err
+			}
+		}
+		v.Data = dst
 	default:
 		return fmt.Errorf("'%+v' is not a valid type to Unmarshal", v.Type)
 	}
@@ -573,6 +600,8 @@ func (d *WritecacheVolume) isOneOfDrbdVolumeLuksVolumeStorageVolumeNvmeVolumeWri
 }
 func (d *CacheVolume) isOneOfDrbdVolumeLuksVolumeStorageVolumeNvmeVolumeWritecacheVolumeCacheVolumeBCacheVolume() {
 }
+func (d *BCacheVolume) isOneOfDrbdVolumeLuksVolumeStorageVolumeNvmeVolumeWritecacheVolumeCacheVolumeBCacheVolume() {
+}
 
 // GetResourceView returns all resources in the cluster. Filters can be set via ListOpts.
 func (n *ResourceService) GetResourceView(ctx context.Context, opts ...*ListOpts) ([]ResourceWithVolumes, error) {
@@ -581,6 +610,12 @@ func (n *ResourceService) GetResourceView(ctx context.Context, opts ...*ListOpts
 	return reses, err
 }
 
+// StreamResourceView works like GetResourceView, but decodes the response one resource at a time, passing each to fn.
+// If fn returns an error, the iteration stops and the error is returned.
+func (n *ResourceService) StreamResourceView(ctx context.Context, fn func(ResourceWithVolumes) error, opts ...*ListOpts) error {
+	return doGETStream(ctx, n.client, "/v1/view/resources", fn, opts...)
+}
+
 // GetAll returns all resources for a resource-definition
 func (n *ResourceService) GetAll(ctx context.Context, resName string, opts ...*ListOpts) ([]Resource, error) {
 	var reses []Resource
@@ -689,6 +724,14 @@ func (n *ResourceService) Migrate(ctx context.Context, resName, fromNodeName, to
 
 // Autoplace places a resource on your nodes autmatically
 func (n *ResourceService) Autoplace(ctx context.Context, resName string, apr AutoPlaceRequest) error {
+	if err := ValidateLayerStack(apr.LayerList); err != nil {
+		return err
+	}
+
+	if err := validateFilterLayerStack(apr.SelectFilter); err != nil {
+		return err
+	}
+
 	_, err := n.client.doPOST(ctx, "/v1/resource-definitions/"+resName+"/autoplace", apr)
 	return err
 }
@@ -728,6 +771,12 @@ func (r *ResourceService) GetSnapshotView(ctx context.Context, opts ...*ListOpts
 	return snaps, err
 }
 
+// StreamSnapshotView works like GetSnapshotView, but decodes the response one snapshot at a time, passing each to fn.
+// If fn returns an error, the iteration stops and the error is returned.
+func (r *ResourceService) StreamSnapshotView(ctx context.Context, fn func(Snapshot) error, opts ...*ListOpts) error {
+	return doGETStream(ctx, r.client, "/v1/view/snapshots", fn, opts...)
+}
+
 // GetSnapshot returns information about a specific Snapshot by its name
 func (n *ResourceService) GetSnapshot(ctx context.Context, resName, snapName string, opts ...*ListOpts) (Snapshot, error) {
 	var snap Snapshot
@@ -784,6 +833,20 @@ func (n *ResourceService) RollbackSnapshot(ctx context.Context, resName, snapNam
 	return err
 }
 
+// CreateSnapshotShipping creates a new snapshot of the resource and ships it from one node to another.
+func (n *ResourceService) CreateSnapshotShipping(ctx context.Context, resName string, ship SnapshotShipping) error {
+	_, err := n.client.doPOST(ctx, "/v1/resource-definitions/"+resName+"/snapshot-shipping", ship)
+	return err
+}
+
+// GetSnapshotShippings lists the status of snapshot shippings. Filters can be set via ListOpts, including the
+// shipping status.
+func (n *ResourceService) GetSnapshotShippings(ctx context.Context, opts ...*ListOpts) ([]SnapshotShippingStatus, error) {
+	var shippings []SnapshotShippingStatus
+	_, err := n.client.doGET(ctx, "/v1/view/snapshot-shippings", &shippings, opts...)
+	return shippings, err
+}
+
 // ModifyDRBDProxy is used to modify drbd-proxy properties
 func (n *ResourceService) ModifyDRBDProxy(ctx context.Context, resName string, props DrbdProxyModify) error {
 	_, err := n.client.doPUT(ctx, "/v1/resource-definitions/"+resName+"/drbd-proxy", props)
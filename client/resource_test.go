@@ -1,7 +1,10 @@
 package client_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -11,6 +14,7 @@ import (
 
 	"github.com/LINBIT/golinstor/client"
 	"github.com/LINBIT/golinstor/devicelayerkind"
+	"github.com/LINBIT/golinstor/snapshotshipstatus"
 )
 
 func TestParse(t *testing.T) {
@@ -257,3 +261,535 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+type fakeShippingProvider struct {
+	client.ResourceProvider
+	polls     int
+	responses [][]client.SnapshotShippingStatus
+}
+
+func (f *fakeShippingProvider) GetSnapshotShippings(_ context.Context, _ ...*client.ListOpts) ([]client.SnapshotShippingStatus, error) {
+	resp := f.responses[min(f.polls, len(f.responses)-1)]
+	f.polls++
+	return resp, nil
+}
+
+func TestWaitForSnapshotShipping(t *testing.T) {
+	shipping := func(status snapshotshipstatus.SnapshotShipStatus) client.SnapshotShippingStatus {
+		return client.SnapshotShippingStatus{
+			Snapshot:     client.Snapshot{Name: "snap1", ResourceName: "rsc1"},
+			FromNodeName: "node1",
+			ToNodeName:   "node2",
+			Status:       status,
+		}
+	}
+
+	provider := &fakeShippingProvider{responses: [][]client.SnapshotShippingStatus{
+		{shipping(snapshotshipstatus.Running)},
+		{shipping(snapshotshipstatus.Running)},
+		{shipping(snapshotshipstatus.Complete)},
+	}}
+	err := client.WaitForSnapshotShipping(context.Background(), provider, "rsc1", "snap1", time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, provider.polls)
+
+	provider = &fakeShippingProvider{responses: [][]client.SnapshotShippingStatus{
+		{shipping(snapshotshipstatus.Running)},
+		{},
+	}}
+	err = client.WaitForSnapshotShipping(context.Background(), provider, "rsc1", "", time.Millisecond)
+	assert.Error(t, err)
+
+	provider = &fakeShippingProvider{responses: [][]client.SnapshotShippingStatus{{}}}
+	err = client.WaitForSnapshotShipping(context.Background(), provider, "rsc1", "", time.Millisecond)
+	assert.Equal(t, client.NotFoundError, err)
+
+	// A zero interval uses the default.
+	provider = &fakeShippingProvider{responses: [][]client.SnapshotShippingStatus{{shipping(snapshotshipstatus.Complete)}}}
+	err = client.WaitForSnapshotShipping(context.Background(), provider, "rsc1", "", 0)
+	assert.NoError(t, err)
+}
+
+type fakeGroupProvider struct {
+	snapshots   map[string]client.Snapshot
+	rds         map[string]client.ResourceDefinition
+	failCreate  string
+	failRestore string
+	created     []string
+	deleted     []string
+	viewOpts    []*client.ListOpts
+}
+
+type fakeGroupResources struct {
+	client.ResourceProvider
+	f *fakeGroupProvider
+}
+
+func (r fakeGroupResources) CreateSnapshots(_ context.Context, snapshots ...client.Snapshot) error {
+	for _, snap := range snapshots {
+		if snap.ResourceName == r.f.failCreate {
+			return errors.New("snapshot failed")
+		}
+		r.f.snapshots[snap.ResourceName+"/"+snap.Name] = snap
+	}
+	return nil
+}
+
+func (r fakeGroupResources) GetSnapshot(_ context.Context, resName, snapName string, _ ...*client.ListOpts) (client.Snapshot, error) {
+	snap, ok := r.f.snapshots[resName+"/"+snapName]
+	if !ok {
+		return snap, client.NotFoundError
+	}
+	return snap, nil
+}
+
+func (r fakeGroupResources) DeleteSnapshot(_ context.Context, resName, snapName string, _ ...string) error {
+	delete(r.f.snapshots, resName+"/"+snapName)
+	return nil
+}
+
+func (r fakeGroupResources) StreamSnapshotView(_ context.Context, fn func(client.Snapshot) error, opts ...*client.ListOpts) error {
+	r.f.viewOpts = opts
+	for _, snap := range r.f.snapshots {
+		if err := fn(snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r fakeGroupResources) RestoreVolumeDefinitionSnapshot(_ context.Context, _, _ string, _ client.SnapshotRestore) error {
+	return nil
+}
+
+func (r fakeGroupResources) RestoreSnapshot(_ context.Context, origResName, _ string, _ client.SnapshotRestore) error {
+	if origResName == r.f.failRestore {
+		return errors.New("restore failed")
+	}
+	return nil
+}
+
+type fakeGroupDefinitions struct {
+	client.ResourceDefinitionProvider
+	f *fakeGroupProvider
+}
+
+func (d fakeGroupDefinitions) Get(_ context.Context, resDefName string, _ ...*client.ListOpts) (client.ResourceDefinition, error) {
+	rd, ok := d.f.rds[resDefName]
+	if !ok {
+		return rd, client.NotFoundError
+	}
+	return rd, nil
+}
+
+func (d fakeGroupDefinitions) Create(_ context.Context, resDef client.ResourceDefinitionCreate) error {
+	d.f.created = append(d.f.created, resDef.ResourceDefinition.Name+"@"+resDef.ResourceDefinition.ResourceGroupName)
+	return nil
+}
+
+func (d fakeGroupDefinitions) Delete(_ context.Context, resDefName string) error {
+	d.f.deleted = append(d.f.deleted, resDefName)
+	return nil
+}
+
+func TestSnapshotGroups(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeGroupProvider{
+		snapshots: map[string]client.Snapshot{"db/existing": {Name: "existing", ResourceName: "db"}},
+		// The original of "log" was deleted.
+		rds: map[string]client.ResourceDefinition{"db": {Name: "db", ResourceGroupName: "rg-db"}},
+	}
+	groups := &client.SnapshotGroups{Resources: fakeGroupResources{f: f}, ResourceDefinitions: fakeGroupDefinitions{f: f}}
+
+	// Creation is all or nothing.
+	f.failCreate = "log"
+	_, err := groups.Create(ctx, "g1", "snap", "db", "log")
+	assert.Error(t, err)
+	assert.Len(t, f.snapshots, 1)
+
+	f.failCreate = ""
+	_, err = groups.Create(ctx, "g1", "snap", "db", "log")
+	assert.NoError(t, err)
+
+	// Group ids are unique.
+	_, err = groups.Create(ctx, "g1", "snap2", "db", "log")
+	assert.EqualError(t, err, "snapshot group 'g1' already exists")
+	assert.Len(t, f.snapshots, 3)
+	assert.Equal(t, []*client.ListOpts{{Prop: []string{client.KeySnapshotGroup + "=g1"}}}, f.viewOpts)
+
+	list, err := groups.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, "g1", list[0].ID)
+	assert.Equal(t, "db", list[0].Snapshots[0].ResourceName)
+	assert.Equal(t, "log", list[0].Snapshots[1].ResourceName)
+
+	rename := func(name string) string { return "restored-" + name }
+
+	// A failed restore removes all resource definitions created for the group. Restored resource definitions keep
+	// the resource group of the original.
+	f.failRestore = "log"
+	err = groups.Restore(ctx, "g1", rename)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"restored-db@rg-db", "restored-log@"}, f.created)
+	assert.Equal(t, []string{"restored-db", "restored-log"}, f.deleted)
+
+	f.failRestore = ""
+	err = groups.Restore(ctx, "g1", func(string) string { return "same" })
+	assert.Error(t, err)
+	err = groups.Restore(ctx, "g1", rename)
+	assert.NoError(t, err)
+
+	assert.NoError(t, groups.Delete(ctx, "g1"))
+	assert.Len(t, f.snapshots, 1)
+	_, err = groups.Get(ctx, "g1")
+	assert.ErrorIs(t, err, client.NotFoundError)
+}
+
+type fakeGroups struct {
+	client.ResourceGroupProvider
+
+	groups   map[string]client.ResourceGroup
+	vgs      map[string][]client.VolumeGroup
+	sizeInfo client.QuerySizeInfoResponse
+	calls    []string
+}
+
+func (f *fakeGroups) Get(_ context.Context, name string, _ ...*client.ListOpts) (client.ResourceGroup, error) {
+	rg, ok := f.groups[name]
+	if !ok {
+		return rg, client.NotFoundError
+	}
+	return rg, nil
+}
+
+func (f *fakeGroups) GetVolumeGroups(_ context.Context, name string, _ ...*client.ListOpts) ([]client.VolumeGroup, error) {
+	return f.vgs[name], nil
+}
+
+func (f *fakeGroups) QuerySizeInfo(_ context.Context, _ string, _ client.QuerySizeInfoRequest) (client.QuerySizeInfoResponse, error) {
+	return f.sizeInfo, nil
+}
+
+// Create and Modify store the group the way the controller returns it, with the storage pool list filled in.
+func (f *fakeGroups) Create(_ context.Context, rg client.ResourceGroup) error {
+	f.calls = append(f.calls, "create "+rg.Name)
+	if rg.SelectFilter.StoragePool != "" {
+		rg.SelectFilter.StoragePoolList = []string{rg.SelectFilter.StoragePool}
+	}
+	f.groups[rg.Name] = rg
+	return nil
+}
+
+func (f *fakeGroups) Modify(_ context.Context, name string, modify client.ResourceGroupModify) error {
+	f.calls = append(f.calls, fmt.Sprintf("modify %s %v %v", name, modify.OverrideProps, modify.DeleteProps))
+
+	rg := f.groups[name]
+	props := make(map[string]string)
+	for k, v := range rg.Props {
+		props[k] = v
+	}
+	for k, v := range modify.OverrideProps {
+		props[k] = v
+	}
+	for _, k := range modify.DeleteProps {
+		delete(props, k)
+	}
+	rg.Props = props
+
+	if modify.Description != "" {
+		rg.Description = modify.Description
+	}
+	if modify.SelectFilter.PlaceCount != 0 {
+		rg.SelectFilter.PlaceCount = modify.SelectFilter.PlaceCount
+	}
+	if modify.SelectFilter.StoragePool != "" {
+		rg.SelectFilter.StoragePool = modify.SelectFilter.StoragePool
+		rg.SelectFilter.StoragePoolList = []string{modify.SelectFilter.StoragePool}
+	}
+	f.groups[name] = rg
+
+	return nil
+}
+
+func (f *fakeGroups) CreateVolumeGroup(_ context.Context, name string, vg client.VolumeGroup) error {
+	f.calls = append(f.calls, fmt.Sprintf("create %s/%d", name, vg.VolumeNumber))
+	return nil
+}
+
+func (f *fakeGroups) ModifyVolumeGroup(_ context.Context, name string, volNr int, modify client.VolumeGroupModify) error {
+	f.calls = append(f.calls, fmt.Sprintf("modify %s/%d %v", name, volNr, modify.Flags))
+	return nil
+}
+
+func (f *fakeGroups) DeleteVolumeGroup(_ context.Context, name string, volNr int) error {
+	f.calls = append(f.calls, fmt.Sprintf("delete %s/%d", name, volNr))
+	return nil
+}
+
+func TestPreviewSpawn(t *testing.T) {
+	groups := &fakeGroups{
+		groups: map[string]client.ResourceGroup{"rg": {Name: "rg"}},
+		vgs:    map[string][]client.VolumeGroup{"rg": {{VolumeNumber: 0}, {VolumeNumber: 1}}},
+		sizeInfo: client.QuerySizeInfoResponse{SpaceInfo: &client.QuerySizeInfoResponseSpaceInfo{
+			MaxVlmSizeInKib: 1000,
+			NextSpawnResult: []client.QuerySizeInfoSpawnResult{{NodeName: "n1", StorPoolName: "pool"}},
+		}},
+	}
+
+	preview, err := client.PreviewSpawn(context.Background(), groups, "rg", client.ResourceGroupSpawn{
+		ResourceDefinitionName: "rsc",
+		VolumeSizes:            []int64{100, 200},
+	})
+	assert.NoError(t, err)
+	assert.True(t, preview.Valid())
+	assert.Equal(t, []client.QuerySizeInfoSpawnResult{{NodeName: "n1", StorPoolName: "pool"}}, preview.Placement)
+
+	preview, err = client.PreviewSpawn(context.Background(), groups, "rg", client.ResourceGroupSpawn{
+		ResourceDefinitionName: "rsc",
+		VolumeSizes:            []int64{2000},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, preview.Problems, 2)
+	assert.Error(t, preview.Error())
+
+	preview, err = client.PreviewSpawn(context.Background(), groups, "rg", client.ResourceGroupSpawn{
+		ResourceDefinitionName: "rsc",
+		VolumeSizes:            []int64{100},
+		Partial:                true,
+	})
+	assert.NoError(t, err)
+	assert.True(t, preview.Valid())
+	assert.Equal(t, []string{"1 volume groups will not be used"}, preview.Warnings)
+
+	_, err = client.PreviewSpawn(context.Background(), groups, "missing", client.ResourceGroupSpawn{})
+	assert.ErrorIs(t, err, client.NotFoundError)
+}
+
+func TestSyncResourceGroupTemplates(t *testing.T) {
+	groups := &fakeGroups{
+		groups: map[string]client.ResourceGroup{
+			"existing": {Name: "existing", Props: map[string]string{"a": "1", "extra": "x"}, SelectFilter: client.AutoSelectFilter{PlaceCount: 2}},
+		},
+		vgs: map[string][]client.VolumeGroup{
+			"existing": {{VolumeNumber: 0}, {VolumeNumber: 1, Flags: []string{"GROSS_SIZE"}}},
+		},
+	}
+
+	templates := []client.ResourceGroupTemplate{
+		{Name: "new", VolumeGroups: []client.VolumeGroupTemplate{{VolumeNumber: 0}}},
+		{
+			Name:         "existing",
+			Props:        map[string]string{"a": "1"},
+			SelectFilter: client.AutoSelectFilter{PlaceCount: 2},
+			VolumeGroups: []client.VolumeGroupTemplate{{VolumeNumber: 0, Flags: []string{"GROSS_SIZE"}}},
+		},
+	}
+
+	// Without pruning, only additions are made to the existing group.
+	changes, err := client.SyncResourceGroupTemplates(context.Background(), groups, client.SyncOpts{DryRun: true}, templates...)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"create resource group 'new'",
+		"create volume group 0 of 'new'",
+		"modify volume group 0 of 'existing'",
+	}, changes)
+	assert.Empty(t, groups.calls)
+
+	changes, err = client.SyncResourceGroupTemplates(context.Background(), groups, client.SyncOpts{Prune: true}, templates...)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 5)
+	assert.Equal(t, []string{
+		"create new",
+		"create new/0",
+		"modify existing map[] [extra]",
+		"modify existing/0 [GROSS_SIZE]",
+		"delete existing/1",
+	}, groups.calls)
+
+	_, err = client.SyncResourceGroupTemplates(context.Background(), groups, client.SyncOpts{}, client.ResourceGroupTemplate{
+		Name:         "dup",
+		VolumeGroups: []client.VolumeGroupTemplate{{VolumeNumber: 0}, {VolumeNumber: 0}},
+	})
+	assert.Error(t, err)
+}
+
+func TestSyncResourceGroupTemplatesConverges(t *testing.T) {
+	groups := &fakeGroups{
+		groups: map[string]client.ResourceGroup{
+			"existing": {Name: "existing", Description: "old", SelectFilter: client.AutoSelectFilter{PlaceCount: 2, StoragePool: "a", StoragePoolList: []string{"a"}}},
+		},
+	}
+
+	templates := []client.ResourceGroupTemplate{
+		{Name: "new", Description: "new group", SelectFilter: client.AutoSelectFilter{PlaceCount: 2, StoragePool: "a"}},
+		{Name: "existing", SelectFilter: client.AutoSelectFilter{PlaceCount: 3, StoragePool: "b"}},
+	}
+
+	changes, err := client.SyncResourceGroupTemplates(context.Background(), groups, client.SyncOpts{Prune: true}, templates...)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"create resource group 'new'", "modify resource group 'existing'"}, changes)
+	assert.Equal(t, client.AutoSelectFilter{PlaceCount: 3, StoragePool: "b", StoragePoolList: []string{"b"}}, groups.groups["existing"].SelectFilter)
+	assert.Equal(t, "old", groups.groups["existing"].Description)
+
+	changes, err = client.SyncResourceGroupTemplates(context.Background(), groups, client.SyncOpts{Prune: true}, templates...)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestResourceLayers(t *testing.T) {
+	const data = `{
+  "name": "rsc",
+  "node_name": "n1",
+  "layer_object": {
+    "type": "DRBD",
+    "drbd": {"drbd_volumes": [
+      {"drbd_volume_definition": {"volume_number": 0}, "device_path": "/dev/drbd1000", "backing_device": "/dev/mapper/luks0"},
+      {"drbd_volume_definition": {"volume_number": 1}, "device_path": "/dev/drbd1001", "backing_device": "/dev/mapper/luks1"}
+    ]},
+    "children": [
+      {
+        "type": "LUKS",
+        "luks": {"storage_volumes": [{"volume_number": 0, "device_path": "/dev/mapper/luks0"}]},
+        "children": [
+          {"type": "STORAGE", "storage": {"storage_volumes": [{"volume_number": 0, "device_path": "/dev/vg/rsc_00000"}]}}
+        ]
+      },
+      {
+        "type": "STORAGE",
+        "resource_name_suffix": ".meta",
+        "storage": {"storage_volumes": [{"volume_number": 0, "device_path": "/dev/vg/rsc.meta_00000"}]}
+      }
+    ]
+  },
+  "volumes": [{
+    "volume_number": 0,
+    "layer_data_list": [
+      {"type": "CACHE", "data": {"volume_number": 0, "device_path": "/dev/mapper/cache0", "disk_state": "UpToDate"}},
+      {"type": "BCACHE", "data": {"volume_number": 0, "device_path": "/dev/bcache0"}},
+      {"type": "STORAGE", "data": {"volume_number": 0, "device_path": "/dev/vg/rsc_00000"}}
+    ]
+  }]
+}`
+
+	var rsc client.ResourceWithVolumes
+	if !assert.NoError(t, json.Unmarshal([]byte(data), &rsc)) {
+		return
+	}
+
+	layer := rsc.LayerObject
+	assert.Equal(t, []devicelayerkind.DeviceLayerKind{devicelayerkind.Drbd, devicelayerkind.Luks, devicelayerkind.Storage}, layer.LayerStack())
+	assert.Equal(t, "/dev/drbd1000", layer.TopDevicePath(0))
+	assert.Equal(t, "/dev/drbd1001", layer.TopDevicePath(1))
+	assert.Equal(t, "", layer.TopDevicePath(2))
+	assert.Equal(t, "/dev/vg/rsc_00000", layer.BackingDevice(0))
+	assert.Equal(t, "", layer.BackingDevice(1))
+
+	v, ok := layer.Volume(1)
+	assert.True(t, ok)
+	drbd, ok := v.DrbdVolume()
+	assert.True(t, ok)
+	assert.Equal(t, "/dev/mapper/luks1", drbd.BackingDevice)
+	_, ok = v.StorageVolume()
+	assert.False(t, ok)
+
+	var visited []string
+	err := layer.Walk(func(l *client.ResourceLayer, depth int) error {
+		visited = append(visited, fmt.Sprintf("%d:%s%s", depth, l.Type, l.ResourceNameSuffix))
+		if l.Type == devicelayerkind.Luks {
+			return client.SkipChildren
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0:DRBD", "1:LUKS", "1:STORAGE.meta"}, visited)
+	assert.Equal(t, "/dev/vg/rsc_00000", layer.Find(devicelayerkind.Storage).TopDevicePath(0))
+	assert.Nil(t, layer.Find(devicelayerkind.Nvme))
+
+	vol := rsc.Volumes[0]
+	assert.Equal(t, []devicelayerkind.DeviceLayerKind{devicelayerkind.Cache, devicelayerkind.Bcache, devicelayerkind.Storage}, vol.LayerStack())
+	assert.Equal(t, "/dev/vg/rsc_00000", vol.BackingDevice())
+	cache, ok := vol.LayerDataList[0].CacheVolume()
+	assert.True(t, ok)
+	assert.Equal(t, "/dev/mapper/cache0", cache.DevicePath)
+	assert.Equal(t, "UpToDate", vol.LayerDataList[0].DiskState())
+	assert.Equal(t, "/dev/bcache0", vol.LayerDataList[1].DevicePath())
+}
+
+func TestValidateLayerStack(t *testing.T) {
+	const (
+		drbd    = devicelayerkind.Drbd
+		luks    = devicelayerkind.Luks
+		storage = devicelayerkind.Storage
+		nvme    = devicelayerkind.Nvme
+		wc      = devicelayerkind.Writecache
+		cache   = devicelayerkind.Cache
+	)
+
+	valid := [][]devicelayerkind.DeviceLayerKind{
+		nil,
+		{storage},
+		{drbd, storage},
+		{drbd, luks, storage},
+		{drbd, nvme, storage},
+		{nvme, luks, wc, storage},
+		{drbd, cache, luks, storage},
+	}
+	for _, stack := range valid {
+		assert.NoError(t, client.ValidateLayerStack(stack), stack)
+	}
+
+	invalid := map[string][]devicelayerkind.DeviceLayerKind{
+		"layer stack [DRBD]: the bottom layer must be STORAGE":                                    {drbd},
+		"layer stack [STORAGE,DRBD]: STORAGE must be the bottom layer":                            {storage, drbd},
+		"layer stack [LUKS,DRBD,STORAGE]: DRBD must be the top layer":                             {luks, drbd, storage},
+		"layer stack [DRBD,LUKS,NVME,STORAGE]: NVME must be the top layer or directly below DRBD": {drbd, luks, nvme, storage},
+		"layer stack [DRBD,WRITECACHE,CACHE,STORAGE]: WRITECACHE and CACHE cannot be combined":    {drbd, wc, cache, storage},
+		"layer stack [LUKS,LUKS,STORAGE]: layer LUKS is used more than once":                      {luks, luks, storage},
+		"layer stack [drbd,STORAGE]: unknown layer 'drbd'":                                        {"drbd", storage},
+	}
+	for msg, stack := range invalid {
+		err := client.ValidateLayerStack(stack)
+		var lsErr *client.LayerStackError
+		assert.ErrorAs(t, err, &lsErr)
+		assert.EqualError(t, err, msg)
+	}
+}
+
+func TestValidateLayerStackOnNode(t *testing.T) {
+	node := client.Node{
+		Name:           "n1",
+		ResourceLayers: []devicelayerkind.DeviceLayerKind{devicelayerkind.Drbd, devicelayerkind.Storage},
+		UnsupportedLayers: map[devicelayerkind.DeviceLayerKind][]string{
+			devicelayerkind.Luks: {"cryptsetup not found"},
+		},
+		StorageProviders: []client.ProviderKind{client.LVM, client.DISKLESS},
+		UnsupportedProviders: map[client.ProviderKind][]string{
+			client.ZFS: {"zfs kernel module not loaded", "zfs utils not installed"},
+		},
+	}
+
+	assert.NoError(t, client.ValidateLayerStackOnNode([]devicelayerkind.DeviceLayerKind{devicelayerkind.Drbd, devicelayerkind.Storage}, node, client.LVM))
+
+	err := client.ValidateLayerStackOnNode([]devicelayerkind.DeviceLayerKind{devicelayerkind.Luks, devicelayerkind.Nvme, devicelayerkind.Storage}, node, client.ZFS, client.LVM_THIN)
+	assert.EqualError(t, err, strings.Join([]string{
+		"layer stack [LUKS,NVME,STORAGE] on node 'n1': layer LUKS is not supported: cryptsetup not found",
+		"layer stack [LUKS,NVME,STORAGE] on node 'n1': layer NVME is not supported",
+		"layer stack [LUKS,NVME,STORAGE] on node 'n1': storage provider ZFS is not supported: zfs kernel module not loaded; zfs utils not installed",
+		"layer stack [LUKS,NVME,STORAGE] on node 'n1': storage provider LVM_THIN is not supported",
+	}, "\n"))
+}
+
+func TestValidateNvmeResources(t *testing.T) {
+	stack := []devicelayerkind.DeviceLayerKind{devicelayerkind.Nvme, devicelayerkind.Storage}
+	target := client.Resource{NodeName: "n1"}
+	initiator := func(node string) client.Resource {
+		return client.Resource{NodeName: node, Flags: []string{"NVME_INITIATOR"}}
+	}
+
+	assert.NoError(t, client.ValidateNvmeResources(stack, target, initiator("n2")))
+	assert.EqualError(t, client.ValidateNvmeResources(stack, target, client.Resource{NodeName: "n2"}), "layer stack [NVME,STORAGE]: only one NVMe target is allowed, got n1, n2")
+	assert.EqualError(t, client.ValidateNvmeResources(stack, target, initiator("n2"), initiator("n3")), "layer stack [NVME,STORAGE]: only one NVMe initiator is allowed, got n2, n3")
+	assert.EqualError(t, client.ValidateNvmeResources(stack, initiator("n2")), "layer stack [NVME,STORAGE]: NVMe initiator requires a target")
+	assert.NoError(t, client.ValidateNvmeResources([]devicelayerkind.DeviceLayerKind{devicelayerkind.Drbd, devicelayerkind.Nvme, devicelayerkind.Storage}, target, client.Resource{NodeName: "n2"}))
+}
@@ -172,7 +172,7 @@ type ResourceDefinitionProvider interface {
 	GetAll(ctx context.Context, request RDGetAllRequest) ([]ResourceDefinitionWithVolumeDefinition, error)
 	// Get return information about a resource-defintion
 	Get(ctx context.Context, resDefName string, opts ...*ListOpts) (ResourceDefinition, error)
-	// Create adds a new resource-definition
+	// Create adds a new resource-definition. The layer stack is checked with ValidateLayerStack first.
 	Create(ctx context.Context, resDef ResourceDefinitionCreate) error
 	// Modify allows to modify a resource-definition
 	Modify(ctx context.Context, resDefName string, props GenericPropsModify) error
@@ -302,6 +302,15 @@ func (n *ResourceDefinitionService) Get(ctx context.Context, resDefName string,
 
 // Create adds a new resource-definition
 func (n *ResourceDefinitionService) Create(ctx context.Context, resDef ResourceDefinitionCreate) error {
+	stack := make([]devicelayerkind.DeviceLayerKind, len(resDef.ResourceDefinition.LayerData))
+	for i, layer := range resDef.ResourceDefinition.LayerData {
+		stack[i] = layer.Type
+	}
+
+	if err := ValidateLayerStack(stack); err != nil {
+		return err
+	}
+
 	_, err := n.client.doPOST(ctx, "/v1/resource-definitions", resDef)
 	return err
 }
@@ -119,7 +119,8 @@ type ResourceGroupProvider interface {
 	Modify(ctx context.Context, resGrpName string, props ResourceGroupModify) error
 	// Delete deletes a resource-group
 	Delete(ctx context.Context, resGrpName string) error
-	// Spawn creates a new resource-definition and auto-deploys if configured to do so
+	// Spawn creates a new resource-definition and auto-deploys if configured to do so. The layer stack of the select
+	// filter is checked with ValidateLayerStack first.
 	Spawn(ctx context.Context, resGrpName string, resGrpSpwn ResourceGroupSpawn) error
 	// GetVolumeGroups lists all volume-groups for a resource-group
 	GetVolumeGroups(ctx context.Context, resGrpName string, opts ...*ListOpts) ([]VolumeGroup, error)
@@ -185,6 +186,10 @@ func (n *ResourceGroupService) Delete(ctx context.Context, resGrpName string) er
 
 // Spawn creates a new resource-definition and auto-deploys if configured to do so
 func (n *ResourceGroupService) Spawn(ctx context.Context, resGrpName string, resGrpSpwn ResourceGroupSpawn) error {
+	if err := validateFilterLayerStack(resGrpSpwn.SelectFilter); err != nil {
+		return err
+	}
+
 	_, err := n.client.doPOST(ctx, "/v1/resource-groups/"+resGrpName+"/spawn", resGrpSpwn)
 	return err
 }
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+// ResourceGroupTemplate is the desired state of a resource group, see SyncResourceGroupTemplates.
+type ResourceGroupTemplate struct {
+	Name         string            `json:"name"`
+	Description  string            `json:"description,omitempty"`
+	Props        map[string]string `json:"props,omitempty"`
+	SelectFilter AutoSelectFilter  `json:"select_filter,omitempty"`
+	// VolumeGroups of the resource group. Volume numbers must be unique.
+	VolumeGroups []VolumeGroupTemplate `json:"volume_groups,omitempty"`
+}
+
+// VolumeGroupTemplate is the desired state of a volume group.
+type VolumeGroupTemplate struct {
+	VolumeNumber int32             `json:"volume_number"`
+	Props        map[string]string `json:"props,omitempty"`
+	Flags        []string          `json:"flags,omitempty"`
+}
+
+// SyncOpts control SyncResourceGroupTemplates.
+type SyncOpts struct {
+	// DryRun only reports the changes, without applying them.
+	DryRun bool
+	// Prune deletes properties and volume groups that are not part of the template. Without it, they are kept,
+	// so that settings made by other tools are not lost.
+	Prune bool
+}
+
+// SyncResourceGroupTemplates creates or updates resource groups so that they match the templates. It returns a
+// description of every change made, or, with DryRun, every change that would be made.
+//
+// Only the description and select filter fields set in a template are compared and applied. Unset fields keep
+// the value of the existing resource group, as the controller cannot clear them.
+//
+// Resource groups not mentioned in the templates are never touched.
+func SyncResourceGroupTemplates(ctx context.Context, groups ResourceGroupProvider, opts SyncOpts, templates ...ResourceGroupTemplate) ([]string, error) {
+	var changes []string
+	for _, tmpl := range templates {
+		if err := tmpl.validate(); err != nil {
+			return nil, err
+		}
+
+		c, err := syncResourceGroup(ctx, groups, opts, tmpl)
+		changes = append(changes, c...)
+		if err != nil {
+			return changes, fmt.Errorf("failed to sync resource group '%s': %w", tmpl.Name, err)
+		}
+	}
+
+	return changes, nil
+}
+
+func (t *ResourceGroupTemplate) validate() error {
+	if t.Name == "" {
+		return errors.New("resource group template name is required")
+	}
+
+	seen := make(map[int32]bool, len(t.VolumeGroups))
+	for _, vg := range t.VolumeGroups {
+		if seen[vg.VolumeNumber] {
+			return fmt.Errorf("resource group template '%s': volume number %d is used more than once", t.Name, vg.VolumeNumber)
+		}
+		seen[vg.VolumeNumber] = true
+	}
+
+	return nil
+}
+
+func syncResourceGroup(ctx context.Context, groups ResourceGroupProvider, opts SyncOpts, tmpl ResourceGroupTemplate) ([]string, error) {
+	var changes []string
+
+	existing, err := groups.Get(ctx, tmpl.Name)
+	switch {
+	case errors.Is(err, NotFoundError):
+		changes = append(changes, fmt.Sprintf("create resource group '%s'", tmpl.Name))
+		if !opts.DryRun {
+			err := groups.Create(ctx, ResourceGroup{
+				Name:         tmpl.Name,
+				Description:  tmpl.Description,
+				Props:        tmpl.Props,
+				SelectFilter: tmpl.SelectFilter,
+			})
+			if err != nil {
+				return changes, err
+			}
+		}
+
+		for _, vg := range tmpl.VolumeGroups {
+			changes = append(changes, fmt.Sprintf("create volume group %d of '%s'", vg.VolumeNumber, tmpl.Name))
+			if opts.DryRun {
+				continue
+			}
+
+			err := groups.CreateVolumeGroup(ctx, tmpl.Name, VolumeGroup{VolumeNumber: vg.VolumeNumber, Props: vg.Props, Flags: vg.Flags})
+			if err != nil {
+				return changes, err
+			}
+		}
+
+		return changes, nil
+	case err != nil:
+		return nil, err
+	}
+
+	filter, filterChanged := changedSelectFilter(existing.SelectFilter, tmpl.SelectFilter)
+	modify := ResourceGroupModify{
+		SelectFilter:  filter,
+		OverrideProps: changedProps(existing.Props, tmpl.Props),
+	}
+	if tmpl.Description != "" && tmpl.Description != existing.Description {
+		modify.Description = tmpl.Description
+	}
+	if opts.Prune {
+		modify.DeleteProps = extraProps(existing.Props, tmpl.Props)
+	}
+
+	if len(modify.OverrideProps) > 0 || len(modify.DeleteProps) > 0 || modify.Description != "" || filterChanged {
+		changes = append(changes, fmt.Sprintf("modify resource group '%s'", tmpl.Name))
+		if !opts.DryRun {
+			if err := groups.Modify(ctx, tmpl.Name, modify); err != nil {
+				return changes, err
+			}
+		}
+	}
+
+	vgChanges, err := syncVolumeGroups(ctx, groups, opts, tmpl)
+	return append(changes, vgChanges...), err
+}
+
+func syncVolumeGroups(ctx context.Context, groups ResourceGroupProvider, opts SyncOpts, tmpl ResourceGroupTemplate) ([]string, error) {
+	current, err := groups.GetVolumeGroups(ctx, tmpl.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	byNr := make(map[int32]VolumeGroup, len(current))
+	for _, vg := range current {
+		byNr[vg.VolumeNumber] = vg
+	}
+
+	var changes []string
+	wanted := make(map[int32]bool, len(tmpl.VolumeGroups))
+	for _, vg := range tmpl.VolumeGroups {
+		wanted[vg.VolumeNumber] = true
+
+		existing, ok := byNr[vg.VolumeNumber]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("create volume group %d of '%s'", vg.VolumeNumber, tmpl.Name))
+			if !opts.DryRun {
+				err := groups.CreateVolumeGroup(ctx, tmpl.Name, VolumeGroup{VolumeNumber: vg.VolumeNumber, Props: vg.Props, Flags: vg.Flags})
+				if err != nil {
+					return changes, err
+				}
+			}
+			continue
+		}
+
+		modify := VolumeGroupModify{OverrideProps: changedProps(existing.Props, vg.Props)}
+		if opts.Prune {
+			modify.DeleteProps = extraProps(existing.Props, vg.Props)
+		}
+
+		for _, flag := range vg.Flags {
+			if !slices.Contains(existing.Flags, flag) {
+				modify.Flags = append(modify.Flags, flag)
+			}
+		}
+		if opts.Prune {
+			for _, flag := range existing.Flags {
+				if !slices.Contains(vg.Flags, flag) {
+					modify.Flags = append(modify.Flags, "-"+flag)
+				}
+			}
+		}
+
+		if len(modify.OverrideProps) == 0 && len(modify.DeleteProps) == 0 && len(modify.Flags) == 0 {
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("modify volume group %d of '%s'", vg.VolumeNumber, tmpl.Name))
+		if !opts.DryRun {
+			if err := groups.ModifyVolumeGroup(ctx, tmpl.Name, int(vg.VolumeNumber), modify); err != nil {
+				return changes, err
+			}
+		}
+	}
+
+	if !opts.Prune {
+		return changes, nil
+	}
+
+	for _, vg := range current {
+		if wanted[vg.VolumeNumber] {
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("delete volume group %d of '%s'", vg.VolumeNumber, tmpl.Name))
+		if !opts.DryRun {
+			if err := groups.DeleteVolumeGroup(ctx, tmpl.Name, int(vg.VolumeNumber)); err != nil {
+				return changes, err
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// changedSelectFilter returns the fields set in wanted that are different in current, and whether there are any.
+// Fields not set in wanted are ignored: the controller fills in some of them, such as the storage pool list for a
+// single storage pool, and the modify API cannot clear them.
+func changedSelectFilter(current, wanted AutoSelectFilter) (AutoSelectFilter, bool) {
+	var changed AutoSelectFilter
+	ok := false
+
+	cur, want, result := reflect.ValueOf(current), reflect.ValueOf(wanted), reflect.ValueOf(&changed).Elem()
+	for i := 0; i < want.NumField(); i++ {
+		if want.Field(i).IsZero() || reflect.DeepEqual(cur.Field(i).Interface(), want.Field(i).Interface()) {
+			continue
+		}
+
+		result.Field(i).Set(want.Field(i))
+		ok = true
+	}
+
+	return changed, ok
+}
+
+// changedProps returns the properties of wanted that are missing or different in current.
+func changedProps(current, wanted map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for k, v := range wanted {
+		if cur, ok := current[k]; !ok || cur != v {
+			changed[k] = v
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return changed
+}
+
+// extraProps returns the keys of current that are not in wanted, sorted.
+func extraProps(current, wanted map[string]string) []string {
+	var extra []string
+	for k := range current {
+		if _, ok := wanted[k]; !ok {
+			extra = append(extra, k)
+		}
+	}
+
+	sort.Strings(extra)
+
+	return extra
+}
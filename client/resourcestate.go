@@ -0,0 +1,27 @@
+package client
+
+import (
+	"slices"
+
+	linstor "github.com/LINBIT/golinstor"
+)
+
+// Diskful returns true if the resource stores data, i.e. it is neither diskless nor a tie breaker.
+func (r *Resource) Diskful() bool {
+	return !slices.Contains(r.Flags, linstor.FlagDiskless) && !slices.Contains(r.Flags, linstor.FlagDrbdDiskless) && !slices.Contains(r.Flags, linstor.FlagTieBreaker)
+}
+
+// VolumesUpToDate returns true if there is at least one volume and all volumes are UpToDate.
+func VolumesUpToDate(vols []Volume) bool {
+	if len(vols) == 0 {
+		return false
+	}
+
+	for _, vol := range vols {
+		if vol.State.DiskState != "UpToDate" {
+			return false
+		}
+	}
+
+	return true
+}
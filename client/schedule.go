@@ -0,0 +1,249 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-querystring/query"
+)
+
+// ScheduleOnFailure determines what happens if a scheduled backup fails.
+type ScheduleOnFailure string
+
+const (
+	// ScheduleOnFailureSkip skips the failed backup and waits for the next scheduled one.
+	ScheduleOnFailureSkip ScheduleOnFailure = "SKIP"
+	// ScheduleOnFailureRetry retries the failed backup up to MaxRetries times.
+	ScheduleOnFailureRetry ScheduleOnFailure = "RETRY"
+)
+
+// Schedule describes when LINSTOR creates and ships backups, and how many of them are kept.
+type Schedule struct {
+	ScheduleName string `json:"schedule_name"`
+	// FullCron is the cron expression for full backups.
+	FullCron string `json:"full_cron"`
+	// IncCron is the cron expression for incremental backups. Optional.
+	IncCron string `json:"inc_cron,omitempty"`
+	// KeepLocal is the number of local backup snapshots to keep. Optional, keeps all if not set.
+	KeepLocal *int32 `json:"keep_local,omitempty"`
+	// KeepRemote is the number of full backups to keep at the remote. Optional, keeps all if not set.
+	KeepRemote *int32            `json:"keep_remote,omitempty"`
+	OnFailure  ScheduleOnFailure `json:"on_failure,omitempty"`
+	// MaxRetries is the number of retries if OnFailure is ScheduleOnFailureRetry.
+	MaxRetries *int32 `json:"max_retries,omitempty"`
+}
+
+type ScheduleModify struct {
+	FullCron   string            `json:"full_cron,omitempty"`
+	IncCron    string            `json:"inc_cron,omitempty"`
+	KeepLocal  *int32            `json:"keep_local,omitempty"`
+	KeepRemote *int32            `json:"keep_remote,omitempty"`
+	OnFailure  ScheduleOnFailure `json:"on_failure,omitempty"`
+	MaxRetries *int32            `json:"max_retries,omitempty"`
+}
+
+type ScheduleList struct {
+	Data []Schedule `json:"data"`
+}
+
+// ScheduleBackupEnable enables a schedule for a remote. If neither RscName nor GrpName are set, the schedule is
+// enabled on the controller level, i.e. for all resources.
+type ScheduleBackupEnable struct {
+	RscName string `json:"rsc_name,omitempty"`
+	GrpName string `json:"grp_name,omitempty"`
+	// NodeName is the preferred node to create the backup on.
+	NodeName       string            `json:"node_name,omitempty"`
+	DstStorPool    string            `json:"dst_stor_pool,omitempty"`
+	StorPoolRename map[string]string `json:"stor_pool_rename,omitempty"`
+}
+
+// ScheduleBackupDisable disables a schedule for a remote. If neither RscName nor GrpName are set, the schedule is
+// disabled on the controller level.
+type ScheduleBackupDisable struct {
+	RscName string `json:"rsc_name,omitempty"`
+	GrpName string `json:"grp_name,omitempty"`
+}
+
+// ScheduleBackupDeleteOpts removes the schedule configuration of a remote, so that the setting of the next higher
+// level applies again. If neither RscName nor GrpName are set, the controller level configuration is removed.
+type ScheduleBackupDeleteOpts struct {
+	RscName string `url:"rsc_dfn_name,omitempty"`
+	GrpName string `url:"rsc_grp_name,omitempty"`
+}
+
+// ScheduledRscs is the state of a schedule for a single resource.
+type ScheduledRscs struct {
+	RscName      string `json:"rsc_name,omitempty"`
+	RemoteName   string `json:"remote_name,omitempty"`
+	ScheduleName string `json:"schedule_name,omitempty"`
+	// Reason explains why the schedule is (not) active for this resource.
+	Reason       string       `json:"reason,omitempty"`
+	LastSnapTime *TimeStampMs `json:"last_snap_time,omitempty"`
+	LastSnapInc  bool         `json:"last_snap_inc,omitempty"`
+	NextExecTime *TimeStampMs `json:"next_exec_time,omitempty"`
+	NextExecInc  bool         `json:"next_exec_inc,omitempty"`
+	// NextPlannedFull is the time of the next planned full backup.
+	NextPlannedFull *TimeStampMs `json:"next_planned_full,omitempty"`
+	// NextPlannedInc is the time of the next planned incremental backup.
+	NextPlannedInc *TimeStampMs `json:"next_planned_inc,omitempty"`
+}
+
+type ScheduledRscsList struct {
+	Data []ScheduledRscs `json:"data"`
+}
+
+// ScheduleDetails shows on which level a schedule is enabled for a resource.
+type ScheduleDetails struct {
+	RemoteName   string `json:"remote_name,omitempty"`
+	ScheduleName string `json:"schedule_name,omitempty"`
+	Ctrl         *bool  `json:"ctrl,omitempty"`
+	RscGrp       *bool  `json:"rsc_grp,omitempty"`
+	RscDfn       *bool  `json:"rsc_dfn,omitempty"`
+}
+
+type ScheduleDetailsList struct {
+	Data []ScheduleDetails `json:"data"`
+}
+
+type ScheduledRscsOpts struct {
+	RscName      string `url:"rsc,omitempty"`
+	RemoteName   string `url:"remote,omitempty"`
+	ScheduleName string `url:"schedule,omitempty"`
+	ActiveOnly   bool   `url:"active-only,omitempty"`
+}
+
+// ScheduleProvider acts as an abstraction for a ScheduleService. It can be swapped out for another ScheduleService
+// implementation, for example for testing.
+type ScheduleProvider interface {
+	// GetAll lists all schedules.
+	GetAll(ctx context.Context) ([]Schedule, error)
+	// Get returns a specific schedule.
+	Get(ctx context.Context, scheduleName string) (Schedule, error)
+	// Create a new schedule.
+	Create(ctx context.Context, schedule Schedule) error
+	// Modify an existing schedule.
+	Modify(ctx context.Context, scheduleName string, modify ScheduleModify) error
+	// Delete a schedule.
+	Delete(ctx context.Context, scheduleName string) error
+	// Enable a schedule for the given remote, on controller, resource group or resource definition level.
+	Enable(ctx context.Context, remoteName, scheduleName string, enable ScheduleBackupEnable) error
+	// Disable a schedule for the given remote, on controller, resource group or resource definition level.
+	Disable(ctx context.Context, remoteName, scheduleName string, disable ScheduleBackupDisable) error
+	// DeleteConfig removes the enable/disable setting of a schedule for the given remote on one level.
+	DeleteConfig(ctx context.Context, remoteName, scheduleName string, opts ScheduleBackupDeleteOpts) error
+	// GetScheduledResources returns the state of schedules per resource.
+	GetScheduledResources(ctx context.Context, opts ScheduledRscsOpts) ([]ScheduledRscs, error)
+	// GetScheduleDetails returns on which levels schedules are enabled for the given resource.
+	GetScheduleDetails(ctx context.Context, rscName string) ([]ScheduleDetails, error)
+}
+
+var _ ScheduleProvider = &ScheduleService{}
+
+type ScheduleService struct {
+	client *Client
+}
+
+func (s *ScheduleService) GetAll(ctx context.Context) ([]Schedule, error) {
+	var list ScheduleList
+	_, err := s.client.doGET(ctx, "/v1/schedules", &list)
+	return list.Data, err
+}
+
+func (s *ScheduleService) Get(ctx context.Context, scheduleName string) (Schedule, error) {
+	var schedule Schedule
+	_, err := s.client.doGET(ctx, "/v1/schedules/"+scheduleName, &schedule)
+	return schedule, err
+}
+
+func (s *ScheduleService) Create(ctx context.Context, schedule Schedule) error {
+	if err := schedule.validate(); err != nil {
+		return err
+	}
+
+	_, err := s.client.doPOST(ctx, "/v1/schedules", schedule)
+	return err
+}
+
+func (s *ScheduleService) Modify(ctx context.Context, scheduleName string, modify ScheduleModify) error {
+	_, err := s.client.doPUT(ctx, "/v1/schedules/"+scheduleName, modify)
+	return err
+}
+
+func (s *ScheduleService) Delete(ctx context.Context, scheduleName string) error {
+	_, err := s.client.doDELETE(ctx, "/v1/schedules/"+scheduleName, nil)
+	return err
+}
+
+func (s *ScheduleService) Enable(ctx context.Context, remoteName, scheduleName string, enable ScheduleBackupEnable) error {
+	if enable.RscName != "" && enable.GrpName != "" {
+		return fmt.Errorf("only one of resource definition and resource group may be set")
+	}
+
+	_, err := s.client.doPUT(ctx, "/v1/remotes/"+remoteName+"/backups/schedule/"+scheduleName+"/enable", enable)
+	return err
+}
+
+func (s *ScheduleService) Disable(ctx context.Context, remoteName, scheduleName string, disable ScheduleBackupDisable) error {
+	if disable.RscName != "" && disable.GrpName != "" {
+		return fmt.Errorf("only one of resource definition and resource group may be set")
+	}
+
+	_, err := s.client.doPUT(ctx, "/v1/remotes/"+remoteName+"/backups/schedule/"+scheduleName+"/disable", disable)
+	return err
+}
+
+func (s *ScheduleService) DeleteConfig(ctx context.Context, remoteName, scheduleName string, opts ScheduleBackupDeleteOpts) error {
+	vals, err := query.Values(opts)
+	if err != nil {
+		return fmt.Errorf("failed to encode delete options: %w", err)
+	}
+
+	_, err = s.client.doDELETE(ctx, "/v1/remotes/"+remoteName+"/backups/schedule/"+scheduleName+"/delete?"+vals.Encode(), nil)
+	return err
+}
+
+func (s *ScheduleService) GetScheduledResources(ctx context.Context, opts ScheduledRscsOpts) ([]ScheduledRscs, error) {
+	vals, err := query.Values(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode filter options: %w", err)
+	}
+
+	var list ScheduledRscsList
+	_, err = s.client.doGET(ctx, "/v1/view/schedules-by-resource?"+vals.Encode(), &list)
+	return list.Data, err
+}
+
+func (s *ScheduleService) GetScheduleDetails(ctx context.Context, rscName string) ([]ScheduleDetails, error) {
+	var list ScheduleDetailsList
+	_, err := s.client.doGET(ctx, "/v1/view/schedules-by-resource/"+rscName, &list)
+	return list.Data, err
+}
+
+// validate checks the schedule for obvious errors before sending it to LINSTOR.
+func (s *Schedule) validate() error {
+	if s.ScheduleName == "" {
+		return fmt.Errorf("schedule name is required")
+	}
+
+	if s.FullCron == "" {
+		return fmt.Errorf("schedule '%s': full cron expression is required", s.ScheduleName)
+	}
+
+	switch s.OnFailure {
+	case "", ScheduleOnFailureSkip:
+		if s.MaxRetries != nil {
+			return fmt.Errorf("schedule '%s': max retries requires on failure mode '%s'", s.ScheduleName, ScheduleOnFailureRetry)
+		}
+	case ScheduleOnFailureRetry:
+	default:
+		return fmt.Errorf("schedule '%s': unknown on failure mode '%s'", s.ScheduleName, s.OnFailure)
+	}
+
+	for name, keep := range map[string]*int32{"keep local": s.KeepLocal, "keep remote": s.KeepRemote, "max retries": s.MaxRetries} {
+		if keep != nil && *keep < 0 {
+			return fmt.Errorf("schedule '%s': %s must not be negative", s.ScheduleName, name)
+		}
+	}
+
+	return nil
+}
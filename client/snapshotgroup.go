@@ -0,0 +1,273 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	linstor "github.com/LINBIT/golinstor"
+)
+
+// KeySnapshotGroup is the snapshot definition property that identifies the members of a SnapshotGroup.
+const KeySnapshotGroup = linstor.NamespcAuxiliary + "/SnapshotGroup"
+
+// SnapshotGroup is a set of snapshots of several resource definitions, taken together so that they are consistent
+// with each other, for example the data and log volumes of a database.
+type SnapshotGroup struct {
+	ID        string
+	Snapshots []Snapshot
+}
+
+// SnapshotGroups creates, restores and deletes snapshot groups.
+//
+// Members of a group are tagged with the group id in their SnapshotDefinitionProps, using KeySnapshotGroup.
+type SnapshotGroups struct {
+	Resources           ResourceProvider
+	ResourceDefinitions ResourceDefinitionProvider
+}
+
+// NewSnapshotGroups returns SnapshotGroups using the services of the given client.
+func NewSnapshotGroups(c *Client) *SnapshotGroups {
+	return &SnapshotGroups{Resources: c.Resources, ResourceDefinitions: c.ResourceDefinitions}
+}
+
+// Create takes a snapshot named snapName of all given resource definitions at once.
+//
+// If the snapshots could not be created on all resource definitions, the snapshots that were created are deleted
+// again, so that either the complete group or nothing exists. Creating a group with an id that is already in use
+// fails.
+func (s *SnapshotGroups) Create(ctx context.Context, groupID, snapName string, resNames ...string) (*SnapshotGroup, error) {
+	if groupID == "" {
+		return nil, errors.New("snapshot group id is required")
+	}
+
+	if len(resNames) == 0 {
+		return nil, errors.New("snapshot group requires at least one resource definition")
+	}
+
+	// Another caller could still create a group with the same id between this check and the snapshots below. This
+	// race is accepted: ids are meant to be chosen by one caller, the check only catches accidental reuse.
+	_, err := s.Get(ctx, groupID)
+	if err == nil {
+		return nil, fmt.Errorf("snapshot group '%s' already exists", groupID)
+	}
+	if !errors.Is(err, NotFoundError) {
+		return nil, fmt.Errorf("failed to check snapshot group '%s': %w", groupID, err)
+	}
+
+	group := &SnapshotGroup{ID: groupID}
+	for _, resName := range resNames {
+		group.Snapshots = append(group.Snapshots, Snapshot{
+			Name:                    snapName,
+			ResourceName:            resName,
+			SnapshotDefinitionProps: map[string]string{KeySnapshotGroup: groupID},
+		})
+	}
+
+	err = s.Resources.CreateSnapshots(ctx, group.Snapshots...)
+	if err != nil {
+		if rollbackErr := s.rollbackCreate(ctx, groupID, snapName, resNames); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to create snapshot group '%s': %w (rollback failed: %v)", groupID, err, rollbackErr)
+		}
+
+		return nil, fmt.Errorf("failed to create snapshot group '%s': %w", groupID, err)
+	}
+
+	return group, nil
+}
+
+func (s *SnapshotGroups) rollbackCreate(ctx context.Context, groupID, snapName string, resNames []string) error {
+	var errs []error
+	for _, resName := range resNames {
+		snap, err := s.Resources.GetSnapshot(ctx, resName, snapName)
+		if errors.Is(err, NotFoundError) {
+			continue
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		// Do not touch snapshots that existed before, with the same name.
+		if snap.SnapshotDefinitionProps[KeySnapshotGroup] != groupID {
+			continue
+		}
+
+		if err := s.Resources.DeleteSnapshot(ctx, resName, snapName); err != nil && !errors.Is(err, NotFoundError) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// List returns all snapshot groups, sorted by id.
+func (s *SnapshotGroups) List(ctx context.Context) ([]SnapshotGroup, error) {
+	byID := make(map[string]*SnapshotGroup)
+	err := s.Resources.StreamSnapshotView(ctx, func(snap Snapshot) error {
+		id, ok := snap.SnapshotDefinitionProps[KeySnapshotGroup]
+		if !ok {
+			return nil
+		}
+
+		group, ok := byID[id]
+		if !ok {
+			group = &SnapshotGroup{ID: id}
+			byID[id] = group
+		}
+
+		group.Snapshots = append(group.Snapshots, snap)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]SnapshotGroup, 0, len(byID))
+	for _, group := range byID {
+		sort.Slice(group.Snapshots, func(i, j int) bool {
+			return group.Snapshots[i].ResourceName < group.Snapshots[j].ResourceName
+		})
+		groups = append(groups, *group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
+
+	return groups, nil
+}
+
+// Get returns the snapshot group with the given id, or NotFoundError.
+func (s *SnapshotGroups) Get(ctx context.Context, groupID string) (SnapshotGroup, error) {
+	group := SnapshotGroup{ID: groupID}
+	err := s.Resources.StreamSnapshotView(ctx, func(snap Snapshot) error {
+		// Checked again, in case the controller does not apply the property filter.
+		if snap.SnapshotDefinitionProps[KeySnapshotGroup] == groupID {
+			group.Snapshots = append(group.Snapshots, snap)
+		}
+		return nil
+	}, &ListOpts{Prop: []string{KeySnapshotGroup + "=" + groupID}})
+	if err != nil {
+		return SnapshotGroup{}, err
+	}
+
+	if len(group.Snapshots) == 0 {
+		return SnapshotGroup{}, NotFoundError
+	}
+
+	sort.Slice(group.Snapshots, func(i, j int) bool {
+		return group.Snapshots[i].ResourceName < group.Snapshots[j].ResourceName
+	})
+
+	return group, nil
+}
+
+// Delete deletes all snapshots of the group.
+func (s *SnapshotGroups) Delete(ctx context.Context, groupID string) error {
+	group, err := s.Get(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, snap := range group.Snapshots {
+		if err := s.Resources.DeleteSnapshot(ctx, snap.ResourceName, snap.Name); err != nil && !errors.Is(err, NotFoundError) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Restore restores all snapshots of the group to new resource definitions. The name of the new resource
+// definition is computed by rename from the original name. Restored resources are placed on the given nodes, or
+// on the nodes of the snapshot if none are given.
+//
+// If any member could not be restored, the resource definitions created so far are deleted again.
+func (s *SnapshotGroups) Restore(ctx context.Context, groupID string, rename func(resName string) string, nodes ...string) error {
+	group, err := s.Get(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	targets := make(map[string]string, len(group.Snapshots))
+	seen := make(map[string]bool, len(group.Snapshots))
+	for _, snap := range group.Snapshots {
+		target := rename(snap.ResourceName)
+		if target == "" || target == snap.ResourceName {
+			return fmt.Errorf("snapshot group '%s': invalid restore target '%s' for resource '%s'", groupID, target, snap.ResourceName)
+		}
+
+		if seen[target] {
+			return fmt.Errorf("snapshot group '%s': restore target '%s' is not unique", groupID, target)
+		}
+
+		seen[target] = true
+		targets[snap.ResourceName] = target
+	}
+
+	var created []string
+	for _, snap := range group.Snapshots {
+		target := targets[snap.ResourceName]
+		rdCreated, err := s.restoreOne(ctx, snap, target, nodes)
+		if rdCreated {
+			created = append(created, target)
+		}
+
+		if err != nil {
+			if rollbackErr := s.rollbackRestore(ctx, created); rollbackErr != nil {
+				return fmt.Errorf("failed to restore snapshot group '%s': %w (rollback failed: %v)", groupID, err, rollbackErr)
+			}
+
+			return fmt.Errorf("failed to restore snapshot group '%s': %w", groupID, err)
+		}
+	}
+
+	return nil
+}
+
+// restoreOne restores a single snapshot into a resource definition in the same resource group as the original, if
+// that still exists. It reports whether the target resource definition was created, even if the restore failed
+// afterwards.
+func (s *SnapshotGroups) restoreOne(ctx context.Context, snap Snapshot, target string, nodes []string) (bool, error) {
+	orig, err := s.ResourceDefinitions.Get(ctx, snap.ResourceName)
+	if err != nil && !errors.Is(err, NotFoundError) {
+		return false, fmt.Errorf("failed to get resource definition '%s': %w", snap.ResourceName, err)
+	}
+
+	err = s.ResourceDefinitions.Create(ctx, ResourceDefinitionCreate{ResourceDefinition: ResourceDefinition{
+		Name:              target,
+		ResourceGroupName: orig.ResourceGroupName,
+	}})
+	if err != nil {
+		return false, fmt.Errorf("failed to create resource definition '%s': %w", target, err)
+	}
+
+	restore := SnapshotRestore{ToResource: target, Nodes: nodes}
+
+	err = s.Resources.RestoreVolumeDefinitionSnapshot(ctx, snap.ResourceName, snap.Name, restore)
+	if err != nil {
+		return true, fmt.Errorf("failed to restore volume definitions of '%s' to '%s': %w", snap.ResourceName, target, err)
+	}
+
+	err = s.Resources.RestoreSnapshot(ctx, snap.ResourceName, snap.Name, restore)
+	if err != nil {
+		return true, fmt.Errorf("failed to restore resources of '%s' to '%s': %w", snap.ResourceName, target, err)
+	}
+
+	return true, nil
+}
+
+func (s *SnapshotGroups) rollbackRestore(ctx context.Context, created []string) error {
+	var errs []error
+	for _, resName := range created {
+		if err := s.ResourceDefinitions.Delete(ctx, resName); err != nil && !errors.Is(err, NotFoundError) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/LINBIT/golinstor/snapshotshipstatus"
+)
+
+// WaitForSnapshotShipping polls the snapshot shipping view until all shippings of the given resource are
+// complete. If snapName is not empty, only shippings of that snapshot are considered.
+//
+// It returns NotFoundError if there is no matching shipping. If a shipping disappears before it completed, which
+// happens when LINSTOR aborts it, an error is returned as well. Use the context to limit the time spent waiting.
+// The poll interval defaults to 5 seconds.
+func WaitForSnapshotShipping(ctx context.Context, resources ResourceProvider, resName, snapName string, interval time.Duration) error {
+	opts := &ListOpts{Resource: []string{resName}}
+	if snapName != "" {
+		opts.Snapshots = []string{snapName}
+	}
+
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	seen := make(map[string]struct{})
+
+	for {
+		shippings, err := resources.GetSnapshotShippings(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		running := make(map[string]struct{})
+		for i := range shippings {
+			s := &shippings[i]
+			if s.Snapshot.ResourceName != resName || (snapName != "" && s.Snapshot.Name != snapName) {
+				continue
+			}
+
+			key := s.Snapshot.Name + "/" + s.FromNodeName + "/" + s.ToNodeName
+			seen[key] = struct{}{}
+
+			if s.Status != snapshotshipstatus.Complete {
+				running[key] = struct{}{}
+			}
+		}
+
+		if len(seen) == 0 {
+			return NotFoundError
+		}
+
+		for key := range seen {
+			if _, ok := running[key]; ok {
+				continue
+			}
+
+			if !containsShipping(shippings, key) {
+				return fmt.Errorf("snapshot shipping '%s' disappeared before completion", key)
+			}
+		}
+
+		if len(running) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func containsShipping(shippings []SnapshotShippingStatus, key string) bool {
+	for i := range shippings {
+		s := &shippings[i]
+		if s.Snapshot.Name+"/"+s.FromNodeName+"/"+s.ToNodeName == key {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	linstor "github.com/LINBIT/golinstor"
+)
+
+// SpawnPreview is the result of PreviewSpawn.
+type SpawnPreview struct {
+	// MaxVolumeSizeKib is the largest volume that can currently be spawned from the resource group.
+	MaxVolumeSizeKib int64
+	// Placement lists where the resource would be placed. It is empty if the spawn only creates definitions.
+	Placement []QuerySizeInfoSpawnResult
+	// Problems are reasons why the spawn would fail.
+	Problems []string
+	// Warnings are surprising, but valid, effects of the spawn, such as volume groups left unused.
+	Warnings []string
+}
+
+// Valid returns true if the spawn is expected to succeed.
+func (p *SpawnPreview) Valid() bool {
+	return len(p.Problems) == 0
+}
+
+// Error returns the problems as an error, or nil if the spawn is valid.
+func (p *SpawnPreview) Error() error {
+	if p.Valid() {
+		return nil
+	}
+
+	return fmt.Errorf("invalid spawn: %s", strings.Join(p.Problems, "; "))
+}
+
+// PreviewSpawn checks a spawn request against the resource group before sending it.
+//
+// It compares the volume sizes with the volume groups, taking the Partial flag into account, and checks the sizes
+// against the space reported by QuerySizeInfo. An error is only returned if the information could not be fetched.
+func PreviewSpawn(ctx context.Context, groups ResourceGroupProvider, resGrpName string, spawn ResourceGroupSpawn) (*SpawnPreview, error) {
+	if _, err := groups.Get(ctx, resGrpName); err != nil {
+		return nil, fmt.Errorf("failed to get resource group '%s': %w", resGrpName, err)
+	}
+
+	volumeGroups, err := groups.GetVolumeGroups(ctx, resGrpName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume groups of '%s': %w", resGrpName, err)
+	}
+
+	preview := &SpawnPreview{}
+
+	if spawn.ResourceDefinitionName == "" && spawn.ResourceDefinitionExternalName == "" {
+		preview.Problems = append(preview.Problems, "resource definition name is required")
+	}
+
+	sizes, vgs := len(spawn.VolumeSizes), len(volumeGroups)
+	switch {
+	case sizes == vgs:
+	case !spawn.Partial:
+		preview.Problems = append(preview.Problems, fmt.Sprintf("%d volume sizes given, but resource group has %d volume groups; set Partial to allow a mismatch", sizes, vgs))
+	case sizes < vgs:
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("%d volume groups will not be used", vgs-sizes))
+	default:
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("%d volumes will not use any volume group settings", sizes-vgs))
+	}
+
+	var filter *AutoSelectFilter
+	if !isEmptySelectFilter(spawn.SelectFilter) {
+		filter = &spawn.SelectFilter
+	}
+
+	info, err := groups.QuerySizeInfo(ctx, resGrpName, QuerySizeInfoRequest{SelectFilter: filter})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query size info of '%s': %w", resGrpName, err)
+	}
+
+	for _, rc := range info.Reports {
+		if uint64(rc.RetCode)&linstor.MaskError == linstor.MaskError {
+			preview.Problems = append(preview.Problems, strings.TrimSpace(rc.Message))
+		}
+	}
+
+	placing := !spawn.DefinitionsOnly
+	if info.SpaceInfo != nil {
+		preview.MaxVolumeSizeKib = info.SpaceInfo.MaxVlmSizeInKib
+		if placing {
+			preview.Placement = info.SpaceInfo.NextSpawnResult
+		}
+	} else if placing {
+		preview.Problems = append(preview.Problems, "no space information available, the resource cannot be placed")
+	}
+
+	for i, size := range spawn.VolumeSizes {
+		if size <= 0 {
+			preview.Problems = append(preview.Problems, fmt.Sprintf("volume %d: size must be positive", i))
+		} else if placing && info.SpaceInfo != nil && size > preview.MaxVolumeSizeKib {
+			preview.Problems = append(preview.Problems, fmt.Sprintf("volume %d: %d KiB requested, but at most %d KiB available", i, size, preview.MaxVolumeSizeKib))
+		}
+	}
+
+	return preview, nil
+}
+
+func isEmptySelectFilter(f AutoSelectFilter) bool {
+	return reflect.DeepEqual(f, AutoSelectFilter{})
+}
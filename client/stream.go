@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// StreamDecodeError is returned by the Stream* methods if an element of the response could not be decoded.
+type StreamDecodeError struct {
+	// Index of the element in the response array that failed to decode.
+	Index int
+	Err   error
+}
+
+func (e *StreamDecodeError) Error() string {
+	return fmt.Sprintf("failed to decode element %d: %v", e.Index, e.Err)
+}
+
+func (e *StreamDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// doGETStream sends a GET request and decodes the response, which is expected to be a JSON array, one element at a
+// time. Every element is passed to fn. If fn returns an error, decoding stops and the error is returned as-is.
+//
+// Responses are never shared between callers, even if CoalesceRequests is enabled: that would require keeping the
+// whole response in memory.
+func doGETStream[T any](ctx context.Context, c *Client, url string, fn func(T) error, opts ...*ListOpts) error {
+	req, err := c.newGETRequest(url, opts...)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeStream(resp.Body, fn)
+}
+
+// decodeStream decodes a JSON array from r, passing every element to fn.
+func decodeStream[T any](r io.Reader, fn func(T) error) error {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read start of array: %w", err)
+	}
+
+	switch tok {
+	case json.Delim('['):
+	case nil:
+		// LINSTOR may return "null" instead of an empty list.
+		return nil
+	default:
+		return fmt.Errorf("expected start of array, got '%v'", tok)
+	}
+
+	for i := 0; dec.More(); i++ {
+		// Always decode into a new value: decoding into an existing value would merge maps and slices.
+		var elem T
+		if err := dec.Decode(&elem); err != nil {
+			return &StreamDecodeError{Index: i, Err: err}
+		}
+
+		if err := fn(elem); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("failed to read end of array: %w", err)
+	}
+
+	return nil
+}
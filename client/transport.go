@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// Name of the environment variable that holds the URL of a proxy used to reach the LINSTOR controllers.
+	// Supported schemes are "http", "https", "socks5" and "socks5h". An empty value means no proxy.
+	ProxyEnv = "LS_PROXY"
+
+	unixScheme     = "unix"
+	unixHostPrefix = "unix-"
+)
+
+// Proxy configures the client to connect to the LINSTOR controllers via the given proxy.
+// Supported schemes are "http", "https", "socks5" and "socks5h". Controllers reached via unix sockets
+// never use the proxy.
+func Proxy(proxy *url.URL) Option {
+	return func(c *Client) error {
+		if proxy == nil {
+			return errors.New("proxy URL is required")
+		}
+
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("unsupported proxy scheme '%s'", proxy.Scheme)
+		}
+
+		c.proxy = proxy
+		return nil
+	}
+}
+
+// isUnix returns true if the controller is reached via a unix domain socket.
+func isUnix(u *url.URL) bool {
+	return u.Scheme == unixScheme
+}
+
+// unixHost returns the placeholder host name used in HTTP requests to a controller reached via a unix socket.
+//
+// The socket path can not be part of the request URL, so we derive a stable host name from it and resolve it back to
+// the path when dialing.
+func unixHost(path string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path))
+	return fmt.Sprintf("%s%08x", unixHostPrefix, h.Sum32())
+}
+
+// requestBase returns the URL that requests to the given controller are resolved against.
+func (c *Client) requestBase(u *url.URL) *url.URL {
+	if !isUnix(u) {
+		return u
+	}
+
+	host := unixHost(u.Path)
+	c.unixSockets.Store(host, u.Path)
+	return &url.URL{Scheme: "http", Host: host}
+}
+
+// unixSocket returns the socket path for a placeholder host as generated by requestBase.
+func (c *Client) unixSocket(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	if !strings.HasPrefix(host, unixHostPrefix) {
+		return "", false
+	}
+
+	path, ok := c.unixSockets.Load(host)
+	if !ok {
+		return "", false
+	}
+
+	return path.(string), true
+}
+
+// controllerAddr returns a human-readable address of the controller, used in error messages.
+func controllerAddr(u *url.URL) string {
+	if isUnix(u) {
+		return u.Path
+	}
+	return u.Host
+}
+
+func (c *Client) needsCustomTransport() bool {
+	if c.proxy != nil {
+		return true
+	}
+
+	for _, u := range c.controllers {
+		if isUnix(u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configureTransport adapts the HTTP client, so that it can reach controllers via unix sockets and the configured
+// proxy. The HTTP client passed by the user is not modified, a copy is used instead.
+func (c *Client) configureTransport() error {
+	if !c.needsCustomTransport() {
+		return nil
+	}
+
+	var transport *http.Transport
+	switch t := c.httpClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return fmt.Errorf("unix sockets and proxies require a *http.Transport, got %T", t)
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := c.unixSocket(addr); ok {
+			return dial(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+
+	if c.proxy != nil {
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if _, ok := c.unixSocket(req.URL.Host); ok {
+				return nil, nil
+			}
+			return c.proxy, nil
+		}
+	}
+
+	httpClient := *c.httpClient
+	httpClient.Transport = transport
+	c.httpClient = &httpClient
+	return nil
+}
+
+// dialController opens a connection to the given controller, taking the same route as regular requests would.
+//
+// For controllers reached via unix socket, the socket is dialed. If a SOCKS proxy is configured, the connection to
+// the controller is established through the proxy. For HTTP proxies, only the connection to the proxy itself is
+// checked: many HTTP proxies do not allow CONNECT to arbitrary ports, so we can not check the full route.
+func (c *Client) dialController(ctx context.Context, u *url.URL) (net.Conn, error) {
+	d := &net.Dialer{}
+
+	if isUnix(u) {
+		return d.DialContext(ctx, "unix", u.Path)
+	}
+
+	if c.proxy == nil {
+		return d.DialContext(ctx, "tcp", u.Host)
+	}
+
+	switch c.proxy.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(c.proxy, d)
+		if err != nil {
+			return nil, err
+		}
+
+		return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", u.Host)
+	}
+
+	proxyAddr := c.proxy.Host
+	if c.proxy.Port() == "" {
+		port := "80"
+		if c.proxy.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(c.proxy.Hostname(), port)
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial proxy: %w", err)
+	}
+
+	return conn, nil
+}
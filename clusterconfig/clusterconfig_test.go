@@ -0,0 +1,423 @@
+package clusterconfig_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/clusterconfig"
+)
+
+type state struct {
+	controller map[string]string
+	nodes      []client.Node
+	pools      []client.StoragePool
+	spds       []client.StoragePoolDefinition
+	rgs        []client.ResourceGroup
+	vgs        map[string][]client.VolumeGroup
+	rds        []client.ResourceDefinitionWithVolumeDefinition
+	nodeConns  []client.Connection
+	rscConns   map[string][]client.Connection
+	kvs        []client.KV
+	files      map[string][]byte
+	remotes    client.RemoteList
+	calls      []string
+}
+
+func (s *state) call(format string, args ...interface{}) {
+	s.calls = append(s.calls, fmt.Sprintf(format, args...))
+}
+
+type fakeController struct {
+	client.ControllerProvider
+	s *state
+}
+
+func (f *fakeController) GetProps(context.Context, ...*client.ListOpts) (client.ControllerProps, error) {
+	return f.s.controller, nil
+}
+
+func (f *fakeController) Modify(_ context.Context, props client.GenericPropsModify) error {
+	f.s.call("modify controller %v %v", map[string]string(props.OverrideProps), []string(props.DeleteProps))
+	return nil
+}
+
+func (f *fakeController) GetExternalFiles(context.Context, ...*client.ListOpts) ([]client.ExternalFile, error) {
+	var files []client.ExternalFile
+	for path := range f.s.files {
+		files = append(files, client.ExternalFile{Path: path})
+	}
+	return files, nil
+}
+
+func (f *fakeController) GetExternalFile(_ context.Context, name string) (client.ExternalFile, error) {
+	return client.ExternalFile{Path: name, Content: f.s.files[name]}, nil
+}
+
+func (f *fakeController) ModifyExternalFile(_ context.Context, name string, file client.ExternalFile) error {
+	f.s.call("modify file %s %q", name, file.Content)
+	return nil
+}
+
+type fakeNodes struct {
+	client.NodeProvider
+	s *state
+}
+
+func (f *fakeNodes) GetAll(context.Context, ...*client.ListOpts) ([]client.Node, error) {
+	return f.s.nodes, nil
+}
+
+func (f *fakeNodes) GetStoragePoolView(context.Context, ...*client.ListOpts) ([]client.StoragePool, error) {
+	return f.s.pools, nil
+}
+
+func (f *fakeNodes) Modify(_ context.Context, name string, modify client.NodeModify) error {
+	f.s.call("modify node %s %v", name, map[string]string(modify.OverrideProps))
+	return nil
+}
+
+func (f *fakeNodes) ModifyNetInterface(_ context.Context, node, name string, nif client.NetInterface) error {
+	f.s.call("modify nif %s/%s %s", node, name, nif.Address)
+	return nil
+}
+
+func (f *fakeNodes) CreateStoragePool(_ context.Context, node string, sp client.StoragePool) error {
+	f.s.call("create pool %s/%s %s %v", node, sp.StoragePoolName, sp.ProviderKind, sp.Props)
+	return nil
+}
+
+type fakeSPDs struct {
+	client.StoragePoolDefinitionProvider
+	s *state
+}
+
+func (f *fakeSPDs) GetAll(context.Context, ...*client.ListOpts) ([]client.StoragePoolDefinition, error) {
+	return f.s.spds, nil
+}
+
+func (f *fakeSPDs) Create(_ context.Context, spd client.StoragePoolDefinition) error {
+	f.s.call("create spd %s", spd.StoragePoolName)
+	return nil
+}
+
+type fakeRGs struct {
+	client.ResourceGroupProvider
+	s *state
+}
+
+func (f *fakeRGs) GetAll(context.Context, ...*client.ListOpts) ([]client.ResourceGroup, error) {
+	return f.s.rgs, nil
+}
+
+func (f *fakeRGs) Get(_ context.Context, name string, _ ...*client.ListOpts) (client.ResourceGroup, error) {
+	for _, rg := range f.s.rgs {
+		if rg.Name == name {
+			return rg, nil
+		}
+	}
+	return client.ResourceGroup{}, client.NotFoundError
+}
+
+func (f *fakeRGs) GetVolumeGroups(_ context.Context, name string, _ ...*client.ListOpts) ([]client.VolumeGroup, error) {
+	return f.s.vgs[name], nil
+}
+
+func (f *fakeRGs) Create(_ context.Context, rg client.ResourceGroup) error {
+	f.s.call("create rg %s %d", rg.Name, rg.SelectFilter.PlaceCount)
+	return nil
+}
+
+func (f *fakeRGs) CreateVolumeGroup(_ context.Context, name string, vg client.VolumeGroup) error {
+	f.s.call("create vg %s/%d", name, vg.VolumeNumber)
+	return nil
+}
+
+type fakeRDs struct {
+	client.ResourceDefinitionProvider
+	s *state
+}
+
+func (f *fakeRDs) GetAll(context.Context, client.RDGetAllRequest) ([]client.ResourceDefinitionWithVolumeDefinition, error) {
+	return f.s.rds, nil
+}
+
+func (f *fakeRDs) ModifyVolumeDefinition(_ context.Context, name string, nr int, modify client.VolumeDefinitionModify) error {
+	f.s.call("modify vd %s/%d %d", name, nr, modify.SizeKib)
+	return nil
+}
+
+type fakeConnections struct {
+	client.ConnectionProvider
+	s *state
+}
+
+func (f *fakeConnections) GetNodeConnections(context.Context, string, string) ([]client.Connection, error) {
+	return f.s.nodeConns, nil
+}
+
+func (f *fakeConnections) GetResourceConnections(_ context.Context, res string) ([]client.Connection, error) {
+	return f.s.rscConns[res], nil
+}
+
+func (f *fakeConnections) SetNodeConnection(_ context.Context, a, b string, props client.GenericPropsModify) error {
+	f.s.call("set node connection %s-%s %v", a, b, map[string]string(props.OverrideProps))
+	return nil
+}
+
+func (f *fakeConnections) SetResourceConnection(_ context.Context, res, a, b string, props client.GenericPropsModify) error {
+	f.s.call("set resource connection %s %s-%s %v", res, a, b, map[string]string(props.OverrideProps))
+	return nil
+}
+
+type fakeKV struct {
+	client.KeyValueStoreProvider
+	s *state
+}
+
+func (f *fakeKV) List(context.Context) ([]client.KV, error) {
+	return f.s.kvs, nil
+}
+
+func (f *fakeKV) CreateOrModify(_ context.Context, name string, props client.GenericPropsModify) error {
+	f.s.call("modify kv %s %v", name, map[string]string(props.OverrideProps))
+	return nil
+}
+
+type fakeRemotes struct {
+	client.RemoteProvider
+	s *state
+}
+
+func (f *fakeRemotes) GetAll(context.Context, ...*client.ListOpts) (client.RemoteList, error) {
+	return f.s.remotes, nil
+}
+
+func (f *fakeRemotes) CreateS3(_ context.Context, r client.S3Remote) error {
+	f.s.call("create s3 %s %s %s", r.RemoteName, r.Bucket, r.AccessKey)
+	return nil
+}
+
+func newCluster(s *state) *clusterconfig.Cluster {
+	return &clusterconfig.Cluster{
+		Controller:             &fakeController{s: s},
+		Nodes:                  &fakeNodes{s: s},
+		StoragePoolDefinitions: &fakeSPDs{s: s},
+		ResourceGroups:         &fakeRGs{s: s},
+		ResourceDefinitions:    &fakeRDs{s: s},
+		Connections:            &fakeConnections{s: s},
+		KV:                     &fakeKV{s: s},
+		Remotes:                &fakeRemotes{s: s},
+		Now:                    func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) },
+	}
+}
+
+func nif(addr string) client.NetInterface {
+	return client.NetInterface{Name: "default", Address: net.ParseIP(addr), SatellitePort: 3366, SatelliteEncryptionType: "PLAIN"}
+}
+
+func vd(nr int32, sizeKib uint64) client.VolumeDefinition {
+	return client.VolumeDefinition{VolumeNumber: &nr, SizeKib: sizeKib}
+}
+
+func source() *state {
+	return &state{
+		controller: map[string]string{"DrbdOptions/Net/protocol": "C", "Cluster/LocalID": "abc"},
+		nodes: []client.Node{{
+			Name:          "n1",
+			Type:          "SATELLITE",
+			Props:         map[string]string{"Aux/site": "a", "CurStltConnName": "default"},
+			NetInterfaces: []client.NetInterface{nif("10.0.0.1")},
+		}},
+		pools: []client.StoragePool{
+			{StoragePoolName: "data", NodeName: "n1", ProviderKind: client.LVM, Props: map[string]string{"StorDriver/LvmVg": "vg"}, FreeCapacity: 100},
+		},
+		spds: []client.StoragePoolDefinition{{StoragePoolName: "data"}},
+		rgs:  []client.ResourceGroup{{Name: "rg1", SelectFilter: client.AutoSelectFilter{PlaceCount: 2}, Uuid: "1234"}},
+		vgs:  map[string][]client.VolumeGroup{"rg1": {{VolumeNumber: 0, Uuid: "5678"}}},
+		rds: []client.ResourceDefinitionWithVolumeDefinition{{
+			ResourceDefinition: client.ResourceDefinition{Name: "r1", ResourceGroupName: "rg1"},
+			VolumeDefinitions:  []client.VolumeDefinition{vd(0, 1024)},
+		}},
+		nodeConns: []client.Connection{
+			{NodeA: "n1", NodeB: "n2", Props: map[string]string{"Paths/p/n1": "default"}},
+			{NodeA: "n1", NodeB: "n3"},
+		},
+		rscConns: map[string][]client.Connection{"r1": {{NodeA: "n1", NodeB: "n2", Props: map[string]string{"DrbdOptions/Net/protocol": "A"}}}},
+		kvs:      []client.KV{{Name: "app", Props: map[string]string{"k": "v"}}},
+		files:    map[string][]byte{"/etc/a": []byte("hello")},
+		remotes: client.RemoteList{S3Remotes: []client.S3Remote{
+			{RemoteName: "bak", Bucket: "b", AccessKey: "AK", SecretKey: "SK"},
+			{RemoteName: "arch", Bucket: "a"},
+		}},
+	}
+}
+
+func target() *state {
+	return &state{
+		controller: map[string]string{"DrbdOptions/Net/protocol": "A", "Cluster/LocalID": "xyz", "Extra": "1"},
+		nodes: []client.Node{{
+			Name:          "n1",
+			Type:          "SATELLITE",
+			Props:         map[string]string{"CurStltConnName": "default"},
+			NetInterfaces: []client.NetInterface{nif("10.0.0.9")},
+		}},
+		rds: []client.ResourceDefinitionWithVolumeDefinition{{
+			ResourceDefinition: client.ResourceDefinition{Name: "r1", ResourceGroupName: "rg1"},
+			VolumeDefinitions:  []client.VolumeDefinition{vd(0, 512)},
+		}},
+		files: map[string][]byte{"/etc/a": []byte("old")},
+	}
+}
+
+func TestExport(t *testing.T) {
+	doc, err := newCluster(source()).Export(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, clusterconfig.Version, doc.Version)
+	assert.Equal(t, map[string]string{"DrbdOptions/Net/protocol": "C"}, doc.ControllerProps)
+	assert.Equal(t, []clusterconfig.Node{{
+		Name:          "n1",
+		Type:          "SATELLITE",
+		Props:         map[string]string{"Aux/site": "a"},
+		NetInterfaces: []clusterconfig.NetInterface{{Name: "default", Address: "10.0.0.1", SatellitePort: 3366, SatelliteEncryptionType: "PLAIN"}},
+		StoragePools:  []clusterconfig.StoragePool{{Name: "data", ProviderKind: client.LVM, Props: map[string]string{"StorDriver/LvmVg": "vg"}}},
+	}}, doc.Nodes)
+	assert.Equal(t, []client.ResourceGroupTemplate{{
+		Name:         "rg1",
+		SelectFilter: client.AutoSelectFilter{PlaceCount: 2},
+		VolumeGroups: []client.VolumeGroupTemplate{{VolumeNumber: 0}},
+	}}, doc.ResourceGroups)
+	assert.Equal(t, []clusterconfig.ResourceDefinition{{
+		Name:              "r1",
+		ResourceGroup:     "rg1",
+		VolumeDefinitions: []clusterconfig.VolumeDefinition{{VolumeNumber: 0, SizeKib: 1024}},
+	}}, doc.ResourceDefinitions)
+	assert.Equal(t, []clusterconfig.Connection{{NodeA: "n1", NodeB: "n2", Props: map[string]string{"Paths/p/n1": "default"}}}, doc.NodeConnections)
+	assert.Equal(t, []clusterconfig.Connection{{Resource: "r1", NodeA: "n1", NodeB: "n2", Props: map[string]string{"DrbdOptions/Net/protocol": "A"}}}, doc.ResourceConnections)
+	assert.Equal(t, []client.S3Remote{{RemoteName: "arch", Bucket: "a"}, {RemoteName: "bak", Bucket: "b"}}, doc.Remotes.S3)
+
+	var buf bytes.Buffer
+	require.NoError(t, doc.WriteYAML(&buf))
+	assert.Contains(t, buf.String(), "content: aGVsbG8=\n")
+
+	fromYAML, err := clusterconfig.Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, doc, fromYAML)
+
+	buf.Reset()
+	require.NoError(t, doc.WriteJSON(&buf))
+	fromJSON, err := clusterconfig.Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, doc, fromJSON)
+
+	_, err = clusterconfig.Read(bytes.NewBufferString(`{"version": 2}`))
+	assert.EqualError(t, err, "unsupported document version 2, expected 1 to 1")
+}
+
+func TestImport(t *testing.T) {
+	doc, err := newCluster(source()).Export(context.Background())
+	require.NoError(t, err)
+
+	s := target()
+	c := newCluster(s)
+
+	changes, err := c.Import(context.Background(), doc, clusterconfig.ImportOpts{DryRun: true, Prune: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"modify controller properties",
+		"create storage pool definition 'data'",
+		"modify node 'n1'",
+		"modify net interface 'default' on 'n1'",
+		"create storage pool 'data' on 'n1'",
+		"create resource group 'rg1'",
+		"create volume group 0 of 'rg1'",
+		"modify volume definition 0 of 'r1'",
+		"modify node connection 'n1' <-> 'n2'",
+		"modify resource connection 'n1' <-> 'n2' of 'r1'",
+		"modify key-value store 'app'",
+		"modify external file '/etc/a'",
+		"create S3 remote 'arch'",
+		"create S3 remote 'bak'",
+	}, changes)
+	assert.Empty(t, s.calls)
+
+	// The remote cannot be created without its secrets.
+	_, err = c.Import(context.Background(), doc, clusterconfig.ImportOpts{Prune: true})
+	assert.EqualError(t, err, "secrets are required to create remotes [arch bak]")
+	assert.Empty(t, s.calls)
+
+	_, err = c.Import(context.Background(), doc, clusterconfig.ImportOpts{
+		Prune:   true,
+		Secrets: map[string]clusterconfig.RemoteSecret{"arch": {AccessKey: "AK1", SecretKey: "SK1"}, "bak": {AccessKey: "AK2", SecretKey: "SK2"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"modify controller map[DrbdOptions/Net/protocol:C] [Extra]",
+		"create spd data",
+		"modify node n1 map[Aux/site:a]",
+		"modify nif n1/default 10.0.0.1",
+		"create pool n1/data LVM map[StorDriver/LvmVg:vg]",
+		"create rg rg1 2",
+		"create vg rg1/0",
+		"modify vd r1/0 1024",
+		"set node connection n1-n2 map[Paths/p/n1:default]",
+		"set resource connection r1 n1-n2 map[DrbdOptions/Net/protocol:A]",
+		"modify kv app map[k:v]",
+		"modify file /etc/a \"hello\"",
+		"create s3 arch a AK1",
+		"create s3 bak b AK2",
+	}, s.calls)
+
+	// Immutable differences are rejected.
+	s.pools = []client.StoragePool{{StoragePoolName: "data", NodeName: "n1", ProviderKind: client.ZFS}}
+	_, err = c.Import(context.Background(), doc, clusterconfig.ImportOpts{DryRun: true})
+	assert.EqualError(t, err, "storage pool 'data' on node 'n1' has provider ZFS, the document wants LVM")
+}
+
+func TestImportChecks(t *testing.T) {
+	doc, err := newCluster(source()).Export(context.Background())
+	require.NoError(t, err)
+
+	doc.Nodes = append(doc.Nodes, clusterconfig.Node{
+		Name:          "n2",
+		NetInterfaces: []clusterconfig.NetInterface{{Name: "default", Address: "10.0.0.300"}},
+	})
+
+	s := target()
+	s.rds[0].ResourceGroupName = "other"
+	c := newCluster(s)
+
+	// Nothing is changed, although the controller properties come first.
+	_, err = c.Import(context.Background(), doc, clusterconfig.ImportOpts{Prune: true})
+	assert.EqualError(t, err, "node 'n2' does not exist and has no type\n"+
+		"net interface 'default' of node 'n2' has invalid address '10.0.0.300'\n"+
+		"resource definition 'r1' is in resource group 'other', the document wants 'rg1'\n"+
+		"secrets are required to create remotes [arch bak]")
+	assert.Empty(t, s.calls)
+}
+
+func TestImportUnchanged(t *testing.T) {
+	s := source()
+	s.rgs[0].SelectFilter.StoragePool = "data"
+	s.rgs[0].SelectFilter.StoragePoolList = []string{"data"}
+	c := newCluster(s)
+
+	doc, err := c.Export(context.Background())
+	require.NoError(t, err)
+
+	// A document written by hand does not contain the fields the controller fills in.
+	doc.ResourceGroups[0].SelectFilter.StoragePoolList = nil
+
+	changes, err := c.Import(context.Background(), doc, clusterconfig.ImportOpts{Prune: true})
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Empty(t, s.calls)
+}
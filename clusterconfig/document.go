@@ -0,0 +1,161 @@
+// Package clusterconfig exports the declarative configuration of a LINSTOR cluster into a versioned document and
+// imports it into another cluster, applying only the differences.
+//
+// The document covers controller properties, nodes with their net interfaces and storage pools, storage pool
+// definitions, resource groups with volume groups, resource definitions with volume definitions, node and resource
+// connections, the key-value store, external files and remotes. Secrets of remotes are never exported. Runtime
+// state, such as resources, volumes or capacities, is not part of the document.
+package clusterconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/devicelayerkind"
+)
+
+// Version is the version of the document format written by Export. Read rejects newer versions.
+const Version = 1
+
+// Document is the configuration of a cluster.
+type Document struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	ControllerProps        map[string]string              `json:"controller_props,omitempty"`
+	Nodes                  []Node                         `json:"nodes,omitempty"`
+	StoragePoolDefinitions []client.StoragePoolDefinition `json:"storage_pool_definitions,omitempty"`
+	ResourceGroups         []client.ResourceGroupTemplate `json:"resource_groups,omitempty"`
+	ResourceDefinitions    []ResourceDefinition           `json:"resource_definitions,omitempty"`
+	NodeConnections        []Connection                   `json:"node_connections,omitempty"`
+	ResourceConnections    []Connection                   `json:"resource_connections,omitempty"`
+	KeyValueStore          []client.KV                    `json:"key_value_store,omitempty"`
+	ExternalFiles          []client.ExternalFile          `json:"external_files,omitempty"`
+	Remotes                Remotes                        `json:"remotes,omitempty"`
+}
+
+// Node is the configuration of a node.
+type Node struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	Props         map[string]string `json:"props,omitempty"`
+	NetInterfaces []NetInterface    `json:"net_interfaces,omitempty"`
+	StoragePools  []StoragePool     `json:"storage_pools,omitempty"`
+}
+
+// NetInterface is the configuration of a net interface.
+type NetInterface struct {
+	Name                    string `json:"name"`
+	Address                 string `json:"address"`
+	SatellitePort           int32  `json:"satellite_port,omitempty"`
+	SatelliteEncryptionType string `json:"satellite_encryption_type,omitempty"`
+}
+
+// StoragePool is the configuration of a storage pool on a node.
+type StoragePool struct {
+	Name            string              `json:"name"`
+	ProviderKind    client.ProviderKind `json:"provider_kind"`
+	Props           map[string]string   `json:"props,omitempty"`
+	SharedSpace     string              `json:"shared_space,omitempty"`
+	ExternalLocking bool                `json:"external_locking,omitempty"`
+}
+
+// ResourceDefinition is the configuration of a resource definition.
+type ResourceDefinition struct {
+	Name          string                            `json:"name"`
+	ExternalName  string                            `json:"external_name,omitempty"`
+	ResourceGroup string                            `json:"resource_group,omitempty"`
+	Props         map[string]string                 `json:"props,omitempty"`
+	LayerStack    []devicelayerkind.DeviceLayerKind `json:"layer_stack,omitempty"`
+	// VolumeDefinitions of the resource definition. Volume numbers must be unique.
+	VolumeDefinitions []VolumeDefinition `json:"volume_definitions,omitempty"`
+}
+
+// VolumeDefinition is the configuration of a volume definition.
+type VolumeDefinition struct {
+	VolumeNumber int32             `json:"volume_number"`
+	SizeKib      uint64            `json:"size_kib"`
+	Props        map[string]string `json:"props,omitempty"`
+	Flags        []string          `json:"flags,omitempty"`
+}
+
+// Connection is a node connection, or a resource connection if Resource is set.
+type Connection struct {
+	Resource string            `json:"resource,omitempty"`
+	NodeA    string            `json:"node_a"`
+	NodeB    string            `json:"node_b"`
+	Props    map[string]string `json:"props"`
+}
+
+// Remotes are the remotes of the cluster, without access keys and passphrases.
+type Remotes struct {
+	S3      []client.S3Remote      `json:"s3,omitempty"`
+	Linstor []client.LinstorRemote `json:"linstor,omitempty"`
+	Ebs     []client.EbsRemote     `json:"ebs,omitempty"`
+}
+
+// WriteJSON writes the document as indented JSON.
+func (d *Document) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// WriteYAML writes the document as YAML. Field names are the same as in JSON.
+func (d *Document) WriteYAML(w io.Writer) error {
+	// Go through JSON, so that the field names and encodings, such as base64 for external files, match.
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(generic); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// Read reads a document written by WriteJSON or WriteYAML.
+func Read(r io.Reader) (*Document, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) == 0 || trimmed[0] != '{' {
+		var generic interface{}
+		if err := yaml.Unmarshal(raw, &generic); err != nil {
+			return nil, fmt.Errorf("failed to parse document: %w", err)
+		}
+
+		raw, err = json.Marshal(generic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse document: %w", err)
+		}
+	}
+
+	var doc Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	if doc.Version < 1 || doc.Version > Version {
+		return nil, fmt.Errorf("unsupported document version %d, expected 1 to %d", doc.Version, Version)
+	}
+
+	return &doc, nil
+}
@@ -0,0 +1,309 @@
+package clusterconfig
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// volatileProps are properties that LINSTOR maintains itself or that identify a specific cluster. They are
+// neither exported nor imported.
+var volatileProps = map[string]bool{
+	"Cluster/LocalID": true,
+	"CurStltConnName": true,
+	"NodeUname":       true,
+}
+
+// Cluster is a cluster to export the configuration from or import it into.
+type Cluster struct {
+	Controller             client.ControllerProvider
+	Nodes                  client.NodeProvider
+	StoragePoolDefinitions client.StoragePoolDefinitionProvider
+	ResourceGroups         client.ResourceGroupProvider
+	ResourceDefinitions    client.ResourceDefinitionProvider
+	Connections            client.ConnectionProvider
+	KV                     client.KeyValueStoreProvider
+	Remotes                client.RemoteProvider
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// New returns a Cluster using the services of the given client.
+func New(c *client.Client) *Cluster {
+	return &Cluster{
+		Controller:             c.Controller,
+		Nodes:                  c.Nodes,
+		StoragePoolDefinitions: c.StoragePoolDefinitions,
+		ResourceGroups:         c.ResourceGroups,
+		ResourceDefinitions:    c.ResourceDefinitions,
+		Connections:            c.Connections,
+		KV:                     c.KeyValueStore,
+		Remotes:                c.Remote,
+	}
+}
+
+// Export reads the configuration of the cluster. All lists in the document are sorted, so that exports of the same
+// configuration are identical.
+func (c *Cluster) Export(ctx context.Context) (*Document, error) {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+
+	doc := &Document{Version: Version, ExportedAt: now().UTC()}
+
+	props, err := c.Controller.GetProps(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get controller properties: %w", err)
+	}
+	doc.ControllerProps = stableProps(props)
+
+	if err := c.exportNodes(ctx, doc); err != nil {
+		return nil, err
+	}
+
+	spds, err := c.StoragePoolDefinitions.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage pool definitions: %w", err)
+	}
+	for _, spd := range spds {
+		doc.StoragePoolDefinitions = append(doc.StoragePoolDefinitions, client.StoragePoolDefinition{StoragePoolName: spd.StoragePoolName, Props: stableProps(spd.Props)})
+	}
+	sort.Slice(doc.StoragePoolDefinitions, func(i, j int) bool {
+		return doc.StoragePoolDefinitions[i].StoragePoolName < doc.StoragePoolDefinitions[j].StoragePoolName
+	})
+
+	if err := c.exportResourceGroups(ctx, doc); err != nil {
+		return nil, err
+	}
+
+	if err := c.exportResourceDefinitions(ctx, doc); err != nil {
+		return nil, err
+	}
+
+	if err := c.exportConnections(ctx, doc); err != nil {
+		return nil, err
+	}
+
+	kvs, err := c.KV.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list key-value stores: %w", err)
+	}
+	doc.KeyValueStore = kvs
+	sort.Slice(doc.KeyValueStore, func(i, j int) bool { return doc.KeyValueStore[i].Name < doc.KeyValueStore[j].Name })
+
+	files, err := c.Controller.GetExternalFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get external files: %w", err)
+	}
+	for _, f := range files {
+		// The list does not necessarily include the content.
+		file, err := c.Controller.GetExternalFile(ctx, f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get external file '%s': %w", f.Path, err)
+		}
+		doc.ExternalFiles = append(doc.ExternalFiles, file)
+	}
+	sort.Slice(doc.ExternalFiles, func(i, j int) bool { return doc.ExternalFiles[i].Path < doc.ExternalFiles[j].Path })
+
+	remotes, err := c.Remotes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remotes: %w", err)
+	}
+	for _, r := range remotes.S3Remotes {
+		r.AccessKey, r.SecretKey = "", ""
+		doc.Remotes.S3 = append(doc.Remotes.S3, r)
+	}
+	for _, r := range remotes.LinstorRemotes {
+		r.Passphrase = ""
+		doc.Remotes.Linstor = append(doc.Remotes.Linstor, r)
+	}
+	for _, r := range remotes.EbsRemotes {
+		r.AccessKey, r.SecretKey = "", ""
+		doc.Remotes.Ebs = append(doc.Remotes.Ebs, r)
+	}
+	sort.Slice(doc.Remotes.S3, func(i, j int) bool { return doc.Remotes.S3[i].RemoteName < doc.Remotes.S3[j].RemoteName })
+	sort.Slice(doc.Remotes.Linstor, func(i, j int) bool { return doc.Remotes.Linstor[i].RemoteName < doc.Remotes.Linstor[j].RemoteName })
+	sort.Slice(doc.Remotes.Ebs, func(i, j int) bool { return doc.Remotes.Ebs[i].RemoteName < doc.Remotes.Ebs[j].RemoteName })
+
+	return doc, nil
+}
+
+func (c *Cluster) exportNodes(ctx context.Context, doc *Document) error {
+	nodes, err := c.Nodes.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	pools, err := c.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get storage pools: %w", err)
+	}
+
+	poolsByNode := make(map[string][]StoragePool)
+	for _, sp := range pools {
+		poolsByNode[sp.NodeName] = append(poolsByNode[sp.NodeName], StoragePool{
+			Name:            sp.StoragePoolName,
+			ProviderKind:    sp.ProviderKind,
+			Props:           stableProps(sp.Props),
+			SharedSpace:     sp.SharedSpace,
+			ExternalLocking: sp.ExternalLocking,
+		})
+	}
+
+	for _, node := range nodes {
+		n := Node{Name: node.Name, Type: node.Type, Props: stableProps(node.Props), StoragePools: poolsByNode[node.Name]}
+		for _, nif := range node.NetInterfaces {
+			n.NetInterfaces = append(n.NetInterfaces, exportNetInterface(nif))
+		}
+
+		sort.Slice(n.NetInterfaces, func(i, j int) bool { return n.NetInterfaces[i].Name < n.NetInterfaces[j].Name })
+		sort.Slice(n.StoragePools, func(i, j int) bool { return n.StoragePools[i].Name < n.StoragePools[j].Name })
+
+		doc.Nodes = append(doc.Nodes, n)
+	}
+
+	sort.Slice(doc.Nodes, func(i, j int) bool { return doc.Nodes[i].Name < doc.Nodes[j].Name })
+
+	return nil
+}
+
+func exportNetInterface(nif client.NetInterface) NetInterface {
+	return NetInterface{
+		Name:                    nif.Name,
+		Address:                 nif.Address.String(),
+		SatellitePort:           nif.SatellitePort,
+		SatelliteEncryptionType: nif.SatelliteEncryptionType,
+	}
+}
+
+func (c *Cluster) exportResourceGroups(ctx context.Context, doc *Document) error {
+	rgs, err := c.ResourceGroups.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get resource groups: %w", err)
+	}
+
+	for _, rg := range rgs {
+		vgs, err := c.ResourceGroups.GetVolumeGroups(ctx, rg.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get volume groups of '%s': %w", rg.Name, err)
+		}
+
+		tmpl := client.ResourceGroupTemplate{Name: rg.Name, Description: rg.Description, Props: stableProps(rg.Props), SelectFilter: rg.SelectFilter}
+		for _, vg := range vgs {
+			tmpl.VolumeGroups = append(tmpl.VolumeGroups, client.VolumeGroupTemplate{VolumeNumber: vg.VolumeNumber, Props: stableProps(vg.Props), Flags: vg.Flags})
+		}
+		sort.Slice(tmpl.VolumeGroups, func(i, j int) bool { return tmpl.VolumeGroups[i].VolumeNumber < tmpl.VolumeGroups[j].VolumeNumber })
+
+		doc.ResourceGroups = append(doc.ResourceGroups, tmpl)
+	}
+
+	sort.Slice(doc.ResourceGroups, func(i, j int) bool { return doc.ResourceGroups[i].Name < doc.ResourceGroups[j].Name })
+
+	return nil
+}
+
+func (c *Cluster) exportResourceDefinitions(ctx context.Context, doc *Document) error {
+	rds, err := c.ResourceDefinitions.GetAll(ctx, client.RDGetAllRequest{WithVolumeDefinitions: true})
+	if err != nil {
+		return fmt.Errorf("failed to get resource definitions: %w", err)
+	}
+
+	for _, rd := range rds {
+		r := ResourceDefinition{
+			Name:          rd.Name,
+			ExternalName:  rd.ExternalName,
+			ResourceGroup: rd.ResourceGroupName,
+			Props:         stableProps(rd.Props),
+		}
+
+		for _, layer := range rd.LayerData {
+			r.LayerStack = append(r.LayerStack, layer.Type)
+		}
+
+		for _, vd := range rd.VolumeDefinitions {
+			if vd.VolumeNumber == nil {
+				continue
+			}
+
+			r.VolumeDefinitions = append(r.VolumeDefinitions, VolumeDefinition{
+				VolumeNumber: *vd.VolumeNumber,
+				SizeKib:      vd.SizeKib,
+				Props:        stableProps(vd.Props),
+				Flags:        vd.Flags,
+			})
+		}
+		sort.Slice(r.VolumeDefinitions, func(i, j int) bool { return r.VolumeDefinitions[i].VolumeNumber < r.VolumeDefinitions[j].VolumeNumber })
+
+		doc.ResourceDefinitions = append(doc.ResourceDefinitions, r)
+	}
+
+	sort.Slice(doc.ResourceDefinitions, func(i, j int) bool { return doc.ResourceDefinitions[i].Name < doc.ResourceDefinitions[j].Name })
+
+	return nil
+}
+
+// exportConnections exports node and resource connections with properties. Connections without properties carry
+// no configuration.
+func (c *Cluster) exportConnections(ctx context.Context, doc *Document) error {
+	conns, err := c.Connections.GetNodeConnections(ctx, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to get node connections: %w", err)
+	}
+
+	for _, conn := range conns {
+		if props := stableProps(conn.Props); len(props) > 0 {
+			doc.NodeConnections = append(doc.NodeConnections, Connection{NodeA: conn.NodeA, NodeB: conn.NodeB, Props: props})
+		}
+	}
+
+	for _, rd := range doc.ResourceDefinitions {
+		conns, err := c.Connections.GetResourceConnections(ctx, rd.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get resource connections of '%s': %w", rd.Name, err)
+		}
+
+		for _, conn := range conns {
+			if props := stableProps(conn.Props); len(props) > 0 {
+				doc.ResourceConnections = append(doc.ResourceConnections, Connection{Resource: rd.Name, NodeA: conn.NodeA, NodeB: conn.NodeB, Props: props})
+			}
+		}
+	}
+
+	sortConnections(doc.NodeConnections)
+	sortConnections(doc.ResourceConnections)
+
+	return nil
+}
+
+func sortConnections(conns []Connection) {
+	sort.Slice(conns, func(i, j int) bool {
+		a, b := conns[i], conns[j]
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.NodeA != b.NodeA {
+			return a.NodeA < b.NodeA
+		}
+		return a.NodeB < b.NodeB
+	})
+}
+
+// stableProps returns the properties without volatileProps, or nil if none are left.
+func stableProps(props map[string]string) map[string]string {
+	result := make(map[string]string, len(props))
+	for k, v := range props {
+		if !volatileProps[k] {
+			result[k] = v
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
@@ -0,0 +1,770 @@
+package clusterconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// RemoteSecret holds the secrets of a remote, which are not part of the document.
+type RemoteSecret struct {
+	AccessKey  string
+	SecretKey  string
+	Passphrase string
+}
+
+// ImportOpts control Import.
+type ImportOpts struct {
+	// DryRun only reports the changes, without applying them.
+	DryRun bool
+	// Prune deletes properties and volume groups that are not part of the document. Objects such as nodes or
+	// resource definitions are never deleted.
+	Prune bool
+	// Secrets of the remotes, by remote name. Remotes that do not exist yet can only be created if their secrets
+	// are given.
+	Secrets map[string]RemoteSecret
+}
+
+type importer struct {
+	c    *Cluster
+	opts ImportOpts
+	// created are the objects created in this import, by kind and name. In a dry run they do not exist in the
+	// cluster, so their children must not be looked up.
+	created map[string]bool
+	changes []string
+}
+
+// do records the change and runs fn, unless this is a dry run.
+func (im *importer) do(change string, fn func() error) error {
+	im.changes = append(im.changes, change)
+	if im.opts.DryRun {
+		return nil
+	}
+
+	if err := fn(); err != nil {
+		return fmt.Errorf("failed to %s: %w", change, err)
+	}
+
+	return nil
+}
+
+// Import changes the cluster so that it matches the document and returns a description of every change made, or,
+// with DryRun, every change that would be made. Objects are created or modified in dependency order, starting with
+// the controller properties and ending with the remotes. Import stops at the first error.
+//
+// The type of existing nodes, the provider of existing storage pools, and the resource group and layer stack of
+// existing resource definitions cannot be changed. Such differences, new nodes without a type, invalid addresses
+// and missing secrets of new remotes are reported as an error before anything is changed. Volume definitions are
+// only grown, never shrunk.
+func (c *Cluster) Import(ctx context.Context, doc *Document, opts ImportOpts) ([]string, error) {
+	im := &importer{c: c, opts: opts, created: make(map[string]bool)}
+
+	if err := im.check(ctx, doc); err != nil {
+		return nil, err
+	}
+
+	steps := []func(context.Context, *Document) error{
+		im.controller,
+		im.storagePoolDefinitions,
+		im.nodes,
+		im.resourceGroups,
+		im.resourceDefinitions,
+		im.connections,
+		im.keyValueStore,
+		im.externalFiles,
+		im.remotes,
+	}
+
+	for _, step := range steps {
+		if err := step(ctx, doc); err != nil {
+			return im.changes, err
+		}
+	}
+
+	return im.changes, nil
+}
+
+// check reports the problems that can be found before the first change, so that the import does not stop half way.
+func (im *importer) check(ctx context.Context, doc *Document) error {
+	return errors.Join(im.checkNodes(ctx, doc), im.checkResourceDefinitions(ctx, doc), im.checkRemotes(ctx, doc))
+}
+
+func (im *importer) checkNodes(ctx context.Context, doc *Document) error {
+	nodes, err := im.c.Nodes.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	pools, err := im.c.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get storage pools: %w", err)
+	}
+
+	current := make(map[string]client.Node, len(nodes))
+	for _, node := range nodes {
+		current[node.Name] = node
+	}
+
+	currentPools := make(map[string]client.StoragePool, len(pools))
+	for _, sp := range pools {
+		currentPools[sp.NodeName+"/"+sp.StoragePoolName] = sp
+	}
+
+	var errs []error
+	for _, node := range doc.Nodes {
+		existing, ok := current[node.Name]
+		switch {
+		case !ok && node.Type == "":
+			errs = append(errs, fmt.Errorf("node '%s' does not exist and has no type", node.Name))
+		case ok && node.Type != "" && !strings.EqualFold(existing.Type, node.Type):
+			errs = append(errs, fmt.Errorf("node '%s' has type %s, the document wants %s", node.Name, existing.Type, node.Type))
+		}
+
+		for _, nif := range node.NetInterfaces {
+			if net.ParseIP(nif.Address) == nil {
+				errs = append(errs, fmt.Errorf("net interface '%s' of node '%s' has invalid address '%s'", nif.Name, node.Name, nif.Address))
+			}
+		}
+
+		for _, sp := range node.StoragePools {
+			if existing, ok := currentPools[node.Name+"/"+sp.Name]; ok && existing.ProviderKind != sp.ProviderKind {
+				errs = append(errs, fmt.Errorf("storage pool '%s' on node '%s' has provider %s, the document wants %s", sp.Name, node.Name, existing.ProviderKind, sp.ProviderKind))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (im *importer) checkResourceDefinitions(ctx context.Context, doc *Document) error {
+	rds, err := im.c.ResourceDefinitions.GetAll(ctx, client.RDGetAllRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to get resource definitions: %w", err)
+	}
+
+	current := make(map[string]client.ResourceDefinition, len(rds))
+	for _, rd := range rds {
+		current[rd.Name] = rd.ResourceDefinition
+	}
+
+	var errs []error
+	for _, rd := range doc.ResourceDefinitions {
+		existing, ok := current[rd.Name]
+		if !ok {
+			continue
+		}
+
+		if rd.ResourceGroup != "" && existing.ResourceGroupName != rd.ResourceGroup {
+			errs = append(errs, fmt.Errorf("resource definition '%s' is in resource group '%s', the document wants '%s'", rd.Name, existing.ResourceGroupName, rd.ResourceGroup))
+		}
+
+		var stack []string
+		for _, layer := range existing.LayerData {
+			stack = append(stack, string(layer.Type))
+		}
+
+		var wanted []string
+		for _, layer := range rd.LayerStack {
+			wanted = append(wanted, string(layer))
+		}
+
+		if len(wanted) > 0 && !equalStrings(stack, wanted) {
+			errs = append(errs, fmt.Errorf("resource definition '%s' has layer stack %v, the document wants %v", rd.Name, stack, wanted))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkRemotes reports remotes that do not exist and have no secrets. A dry run reports their creation instead.
+func (im *importer) checkRemotes(ctx context.Context, doc *Document) error {
+	if im.opts.DryRun {
+		return nil
+	}
+
+	remotes, err := im.c.Remotes.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get remotes: %w", err)
+	}
+
+	exists := make(map[string]bool)
+	for _, r := range remotes.S3Remotes {
+		exists[r.RemoteName] = true
+	}
+	for _, r := range remotes.LinstorRemotes {
+		exists[r.RemoteName] = true
+	}
+	for _, r := range remotes.EbsRemotes {
+		exists[r.RemoteName] = true
+	}
+
+	var missingSecrets []string
+	for _, name := range remoteNames(doc.Remotes) {
+		if _, ok := im.opts.Secrets[name]; !ok && !exists[name] {
+			missingSecrets = append(missingSecrets, name)
+		}
+	}
+
+	if len(missingSecrets) > 0 {
+		return fmt.Errorf("secrets are required to create remotes %v", missingSecrets)
+	}
+
+	return nil
+}
+
+func (im *importer) controller(ctx context.Context, doc *Document) error {
+	current, err := im.c.Controller.GetProps(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get controller properties: %w", err)
+	}
+
+	modify, changed := diffProps(current, doc.ControllerProps, im.opts.Prune)
+	if !changed {
+		return nil
+	}
+
+	return im.do("modify controller properties", func() error {
+		return im.c.Controller.Modify(ctx, modify)
+	})
+}
+
+func (im *importer) storagePoolDefinitions(ctx context.Context, doc *Document) error {
+	spds, err := im.c.StoragePoolDefinitions.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get storage pool definitions: %w", err)
+	}
+
+	current := make(map[string]client.StoragePoolDefinition, len(spds))
+	for _, spd := range spds {
+		current[spd.StoragePoolName] = spd
+	}
+
+	for _, spd := range doc.StoragePoolDefinitions {
+		existing, ok := current[spd.StoragePoolName]
+		if !ok {
+			err := im.do(fmt.Sprintf("create storage pool definition '%s'", spd.StoragePoolName), func() error {
+				return im.c.StoragePoolDefinitions.Create(ctx, spd)
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		modify, changed := diffProps(existing.Props, spd.Props, im.opts.Prune)
+		if !changed {
+			continue
+		}
+
+		err := im.do(fmt.Sprintf("modify storage pool definition '%s'", spd.StoragePoolName), func() error {
+			return im.c.StoragePoolDefinitions.Modify(ctx, spd.StoragePoolName, client.StoragePoolDefinitionModify{GenericPropsModify: modify})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) nodes(ctx context.Context, doc *Document) error {
+	nodes, err := im.c.Nodes.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	pools, err := im.c.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get storage pools: %w", err)
+	}
+
+	current := make(map[string]client.Node, len(nodes))
+	for _, node := range nodes {
+		current[node.Name] = node
+	}
+
+	currentPools := make(map[string]client.StoragePool, len(pools))
+	for _, sp := range pools {
+		currentPools[sp.NodeName+"/"+sp.StoragePoolName] = sp
+	}
+
+	for _, node := range doc.Nodes {
+		if err := im.node(ctx, node, current, currentPools); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) node(ctx context.Context, node Node, current map[string]client.Node, currentPools map[string]client.StoragePool) error {
+	existing, ok := current[node.Name]
+	if !ok {
+		create := client.Node{Name: node.Name, Type: node.Type, Props: node.Props}
+		for _, nif := range node.NetInterfaces {
+			create.NetInterfaces = append(create.NetInterfaces, importNetInterface(nif))
+		}
+
+		err := im.do(fmt.Sprintf("create node '%s'", node.Name), func() error {
+			return im.c.Nodes.Create(ctx, create)
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		modify, changed := diffProps(existing.Props, node.Props, im.opts.Prune)
+		if changed {
+			err := im.do(fmt.Sprintf("modify node '%s'", node.Name), func() error {
+				return im.c.Nodes.Modify(ctx, node.Name, client.NodeModify{GenericPropsModify: modify})
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := im.netInterfaces(ctx, node, existing); err != nil {
+			return err
+		}
+	}
+
+	for _, sp := range node.StoragePools {
+		existing, ok := currentPools[node.Name+"/"+sp.Name]
+		if !ok {
+			err := im.do(fmt.Sprintf("create storage pool '%s' on '%s'", sp.Name, node.Name), func() error {
+				return im.c.Nodes.CreateStoragePool(ctx, node.Name, client.StoragePool{
+					StoragePoolName: sp.Name,
+					NodeName:        node.Name,
+					ProviderKind:    sp.ProviderKind,
+					Props:           sp.Props,
+					SharedSpace:     sp.SharedSpace,
+					ExternalLocking: sp.ExternalLocking,
+				})
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		modify, changed := diffProps(existing.Props, sp.Props, im.opts.Prune)
+		if !changed {
+			continue
+		}
+
+		err := im.do(fmt.Sprintf("modify storage pool '%s' on '%s'", sp.Name, node.Name), func() error {
+			return im.c.Nodes.ModifyStoragePool(ctx, node.Name, sp.Name, modify)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) netInterfaces(ctx context.Context, node Node, existing client.Node) error {
+	current := make(map[string]NetInterface, len(existing.NetInterfaces))
+	for _, nif := range existing.NetInterfaces {
+		current[nif.Name] = exportNetInterface(nif)
+	}
+
+	for _, nif := range node.NetInterfaces {
+		cur, ok := current[nif.Name]
+		switch {
+		case !ok:
+			err := im.do(fmt.Sprintf("create net interface '%s' on '%s'", nif.Name, node.Name), func() error {
+				return im.c.Nodes.CreateNetInterface(ctx, node.Name, importNetInterface(nif))
+			})
+			if err != nil {
+				return err
+			}
+		case cur != nif:
+			err := im.do(fmt.Sprintf("modify net interface '%s' on '%s'", nif.Name, node.Name), func() error {
+				return im.c.Nodes.ModifyNetInterface(ctx, node.Name, nif.Name, importNetInterface(nif))
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func importNetInterface(nif NetInterface) client.NetInterface {
+	return client.NetInterface{
+		Name:                    nif.Name,
+		Address:                 net.ParseIP(nif.Address),
+		SatellitePort:           nif.SatellitePort,
+		SatelliteEncryptionType: nif.SatelliteEncryptionType,
+	}
+}
+
+func (im *importer) resourceGroups(ctx context.Context, doc *Document) error {
+	changes, err := client.SyncResourceGroupTemplates(ctx, im.c.ResourceGroups, client.SyncOpts{DryRun: im.opts.DryRun, Prune: im.opts.Prune}, doc.ResourceGroups...)
+	im.changes = append(im.changes, changes...)
+	return err
+}
+
+func (im *importer) resourceDefinitions(ctx context.Context, doc *Document) error {
+	rds, err := im.c.ResourceDefinitions.GetAll(ctx, client.RDGetAllRequest{WithVolumeDefinitions: true})
+	if err != nil {
+		return fmt.Errorf("failed to get resource definitions: %w", err)
+	}
+
+	current := make(map[string]client.ResourceDefinitionWithVolumeDefinition, len(rds))
+	for _, rd := range rds {
+		current[rd.Name] = rd
+	}
+
+	for _, rd := range doc.ResourceDefinitions {
+		if err := im.resourceDefinition(ctx, rd, current); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) resourceDefinition(ctx context.Context, rd ResourceDefinition, current map[string]client.ResourceDefinitionWithVolumeDefinition) error {
+	existing, ok := current[rd.Name]
+	if !ok {
+		create := client.ResourceDefinitionCreate{ResourceDefinition: client.ResourceDefinition{
+			Name:              rd.Name,
+			ExternalName:      rd.ExternalName,
+			Props:             rd.Props,
+			ResourceGroupName: rd.ResourceGroup,
+		}}
+		for _, layer := range rd.LayerStack {
+			create.ResourceDefinition.LayerData = append(create.ResourceDefinition.LayerData, client.ResourceDefinitionLayer{Type: layer})
+		}
+
+		err := im.do(fmt.Sprintf("create resource definition '%s'", rd.Name), func() error {
+			return im.c.ResourceDefinitions.Create(ctx, create)
+		})
+		if err != nil {
+			return err
+		}
+		im.created["rd/"+rd.Name] = true
+	} else {
+		modify, changed := diffProps(existing.Props, rd.Props, im.opts.Prune)
+		if changed {
+			err := im.do(fmt.Sprintf("modify resource definition '%s'", rd.Name), func() error {
+				return im.c.ResourceDefinitions.Modify(ctx, rd.Name, modify)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	vds := make(map[int32]client.VolumeDefinition, len(existing.VolumeDefinitions))
+	for _, vd := range existing.VolumeDefinitions {
+		if vd.VolumeNumber != nil {
+			vds[*vd.VolumeNumber] = vd
+		}
+	}
+
+	for _, vd := range rd.VolumeDefinitions {
+		cur, ok := vds[vd.VolumeNumber]
+		if !ok {
+			err := im.do(fmt.Sprintf("create volume definition %d of '%s'", vd.VolumeNumber, rd.Name), func() error {
+				nr := vd.VolumeNumber
+				return im.c.ResourceDefinitions.CreateVolumeDefinition(ctx, rd.Name, client.VolumeDefinitionCreate{
+					VolumeDefinition: client.VolumeDefinition{VolumeNumber: &nr, SizeKib: vd.SizeKib, Props: vd.Props, Flags: vd.Flags},
+				})
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		props, changed := diffProps(cur.Props, vd.Props, im.opts.Prune)
+		modify := client.VolumeDefinitionModify{GenericPropsModify: props}
+		if vd.SizeKib > cur.SizeKib {
+			modify.SizeKib = vd.SizeKib
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+
+		err := im.do(fmt.Sprintf("modify volume definition %d of '%s'", vd.VolumeNumber, rd.Name), func() error {
+			return im.c.ResourceDefinitions.ModifyVolumeDefinition(ctx, rd.Name, int(vd.VolumeNumber), modify)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) connections(ctx context.Context, doc *Document) error {
+	nodeConns, err := im.c.Connections.GetNodeConnections(ctx, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to get node connections: %w", err)
+	}
+
+	current := make(map[string]map[string]string)
+	for _, conn := range nodeConns {
+		current[connectionKey("", conn.NodeA, conn.NodeB)] = conn.Props
+	}
+
+	fetched := make(map[string]bool)
+	for _, conn := range doc.ResourceConnections {
+		if fetched[conn.Resource] || im.created["rd/"+conn.Resource] {
+			continue
+		}
+		fetched[conn.Resource] = true
+
+		rscConns, err := im.c.Connections.GetResourceConnections(ctx, conn.Resource)
+		if err != nil {
+			return fmt.Errorf("failed to get resource connections of '%s': %w", conn.Resource, err)
+		}
+
+		for _, rc := range rscConns {
+			current[connectionKey(conn.Resource, rc.NodeA, rc.NodeB)] = rc.Props
+		}
+	}
+
+	for _, conn := range append(append([]Connection{}, doc.NodeConnections...), doc.ResourceConnections...) {
+		modify, changed := diffProps(current[connectionKey(conn.Resource, conn.NodeA, conn.NodeB)], conn.Props, im.opts.Prune)
+		if !changed {
+			continue
+		}
+
+		if conn.Resource == "" {
+			err = im.do(fmt.Sprintf("modify node connection '%s' <-> '%s'", conn.NodeA, conn.NodeB), func() error {
+				return im.c.Connections.SetNodeConnection(ctx, conn.NodeA, conn.NodeB, modify)
+			})
+		} else {
+			err = im.do(fmt.Sprintf("modify resource connection '%s' <-> '%s' of '%s'", conn.NodeA, conn.NodeB, conn.Resource), func() error {
+				return im.c.Connections.SetResourceConnection(ctx, conn.Resource, conn.NodeA, conn.NodeB, modify)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func connectionKey(resource, a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+
+	return resource + "/" + a + "/" + b
+}
+
+func (im *importer) keyValueStore(ctx context.Context, doc *Document) error {
+	kvs, err := im.c.KV.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list key-value stores: %w", err)
+	}
+
+	current := make(map[string]map[string]string, len(kvs))
+	for _, kv := range kvs {
+		current[kv.Name] = kv.Props
+	}
+
+	for _, kv := range doc.KeyValueStore {
+		modify, changed := diffProps(current[kv.Name], kv.Props, im.opts.Prune)
+		if !changed {
+			continue
+		}
+
+		err := im.do(fmt.Sprintf("modify key-value store '%s'", kv.Name), func() error {
+			return im.c.KV.CreateOrModify(ctx, kv.Name, modify)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) externalFiles(ctx context.Context, doc *Document) error {
+	files, err := im.c.Controller.GetExternalFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get external files: %w", err)
+	}
+
+	exists := make(map[string]bool, len(files))
+	for _, f := range files {
+		exists[f.Path] = true
+	}
+
+	for _, file := range doc.ExternalFiles {
+		change := fmt.Sprintf("create external file '%s'", file.Path)
+		if exists[file.Path] {
+			cur, err := im.c.Controller.GetExternalFile(ctx, file.Path)
+			if err != nil {
+				return fmt.Errorf("failed to get external file '%s': %w", file.Path, err)
+			}
+
+			if bytes.Equal(cur.Content, file.Content) {
+				continue
+			}
+
+			change = fmt.Sprintf("modify external file '%s'", file.Path)
+		}
+
+		err := im.do(change, func() error {
+			return im.c.Controller.ModifyExternalFile(ctx, file.Path, file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remotes creates missing remotes and updates the non-secret settings of existing ones. Secrets of existing remotes
+// are only changed if they are given in ImportOpts.Secrets.
+func (im *importer) remotes(ctx context.Context, doc *Document) error {
+	remotes, err := im.c.Remotes.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get remotes: %w", err)
+	}
+
+	s3 := make(map[string]client.S3Remote)
+	for _, r := range remotes.S3Remotes {
+		r.AccessKey, r.SecretKey = "", ""
+		s3[r.RemoteName] = r
+	}
+
+	linstor := make(map[string]client.LinstorRemote)
+	for _, r := range remotes.LinstorRemotes {
+		r.Passphrase = ""
+		linstor[r.RemoteName] = r
+	}
+
+	ebs := make(map[string]client.EbsRemote)
+	for _, r := range remotes.EbsRemotes {
+		r.AccessKey, r.SecretKey = "", ""
+		ebs[r.RemoteName] = r
+	}
+
+	for _, r := range doc.Remotes.S3 {
+		cur, ok := s3[r.RemoteName]
+		secret, hasSecret := im.opts.Secrets[r.RemoteName]
+		if ok && cur == r && !hasSecret {
+			continue
+		}
+
+		r.AccessKey, r.SecretKey = secret.AccessKey, secret.SecretKey
+		err := im.doRemote("S3", r.RemoteName, ok, func() error { return im.c.Remotes.CreateS3(ctx, r) }, func() error { return im.c.Remotes.ModifyS3(ctx, r.RemoteName, r) })
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range doc.Remotes.Linstor {
+		cur, ok := linstor[r.RemoteName]
+		secret, hasSecret := im.opts.Secrets[r.RemoteName]
+		if ok && cur == r && !hasSecret {
+			continue
+		}
+
+		r.Passphrase = secret.Passphrase
+		err := im.doRemote("LINSTOR", r.RemoteName, ok, func() error { return im.c.Remotes.CreateLinstor(ctx, r) }, func() error { return im.c.Remotes.ModifyLinstor(ctx, r.RemoteName, r) })
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range doc.Remotes.Ebs {
+		cur, ok := ebs[r.RemoteName]
+		secret, hasSecret := im.opts.Secrets[r.RemoteName]
+		if ok && cur == r && !hasSecret {
+			continue
+		}
+
+		r.AccessKey, r.SecretKey = secret.AccessKey, secret.SecretKey
+		err := im.doRemote("EBS", r.RemoteName, ok, func() error { return im.c.Remotes.CreateEbs(ctx, r) }, func() error { return im.c.Remotes.ModifyEbs(ctx, r.RemoteName, r) })
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) doRemote(kind, name string, exists bool, create, modify func() error) error {
+	if exists {
+		return im.do(fmt.Sprintf("modify %s remote '%s'", kind, name), modify)
+	}
+
+	return im.do(fmt.Sprintf("create %s remote '%s'", kind, name), create)
+}
+
+func remoteNames(r Remotes) []string {
+	var names []string
+	for _, s := range r.S3 {
+		names = append(names, s.RemoteName)
+	}
+	for _, l := range r.Linstor {
+		names = append(names, l.RemoteName)
+	}
+	for _, e := range r.Ebs {
+		names = append(names, e.RemoteName)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// diffProps returns the modification that turns current into wanted, ignoring volatileProps. Properties that are
+// not wanted are only deleted with prune.
+func diffProps(current, wanted map[string]string, prune bool) (client.GenericPropsModify, bool) {
+	current, wanted = stableProps(current), stableProps(wanted)
+
+	var modify client.GenericPropsModify
+	for k, v := range wanted {
+		if cur, ok := current[k]; !ok || cur != v {
+			if modify.OverrideProps == nil {
+				modify.OverrideProps = make(client.OverrideProps)
+			}
+			modify.OverrideProps[k] = v
+		}
+	}
+
+	if prune {
+		for k := range current {
+			if _, ok := wanted[k]; !ok {
+				modify.DeleteProps = append(modify.DeleteProps, k)
+			}
+		}
+		sort.Strings(modify.DeleteProps)
+	}
+
+	return modify, len(modify.OverrideProps) > 0 || len(modify.DeleteProps) > 0
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
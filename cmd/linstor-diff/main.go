@@ -0,0 +1,112 @@
+// Command linstor-diff compares the configuration of two LINSTOR clusters.
+//
+// Each side is either a document written by clusterconfig or the URL of a controller. The exit status is 0 if
+// there are no differences, 1 if there are and 2 on errors.
+//
+//	linstor-diff -a http://linstor-a:3370 -b export-b.yaml -node-map node-b1=node-a1 -ignore 'external_files/*'
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/clusterconfig"
+	"github.com/LINBIT/golinstor/diff"
+)
+
+func main() {
+	a := flag.String("a", "", "controller URL or export of the first cluster")
+	b := flag.String("b", "", "controller URL or export of the second cluster")
+	ignore := flag.String("ignore", "", "comma separated path patterns to ignore, in addition to UUIDs")
+	nodeMap := flag.String("node-map", "", "comma separated node names of the second cluster, as b-name=a-name")
+	asJSON := flag.Bool("json", false, "write the report as JSON")
+	flag.Parse()
+
+	if *a == "" || *b == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := diff.Options{Ignore: append([]string{}, diff.DefaultIgnore...)}
+	if *ignore != "" {
+		opts.Ignore = append(opts.Ignore, strings.Split(*ignore, ",")...)
+	}
+
+	if *nodeMap != "" {
+		opts.NodeNames = make(map[string]string)
+		for _, pair := range strings.Split(*nodeMap, ",") {
+			from, to, ok := strings.Cut(pair, "=")
+			if !ok {
+				fail(fmt.Errorf("invalid node mapping '%s', expected b-name=a-name", pair))
+			}
+			opts.NodeNames[from] = to
+		}
+	}
+
+	ctx := context.Background()
+
+	docA, err := load(ctx, *a)
+	if err != nil {
+		fail(err)
+	}
+
+	docB, err := load(ctx, *b)
+	if err != nil {
+		fail(err)
+	}
+
+	report := diff.Compare(docA, docB, opts)
+	if *asJSON {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	if len(report.Differences) > 0 {
+		os.Exit(1)
+	}
+}
+
+// load reads an export file, or exports the cluster if source is not a file but a controller URL.
+func load(ctx context.Context, source string) (*clusterconfig.Document, error) {
+	f, err := os.Open(source)
+	if err == nil {
+		defer f.Close()
+
+		doc, err := clusterconfig.Read(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %w", source, err)
+		}
+
+		return doc, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	c, err := client.NewClient(client.Controllers([]string{source}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for '%s': %w", source, err)
+	}
+
+	doc, err := clusterconfig.New(c).Export(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export '%s': %w", source, err)
+	}
+
+	return doc, nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
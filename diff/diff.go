@@ -0,0 +1,414 @@
+// Package diff compares the configuration of two LINSTOR clusters field by field.
+//
+// The comparison covers controller properties, resource group select filters, storage pool definitions, DRBD
+// options and external files. It works on clusterconfig documents, so clusters can be compared live or from
+// earlier exports. Expected differences, such as UUIDs or node names, are excluded with Options.
+package diff
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/clusterconfig"
+)
+
+// KeyPrefixDrbdOptions is the prefix of properties that configure DRBD.
+const KeyPrefixDrbdOptions = "DrbdOptions/"
+
+// Kind is the kind of a difference.
+type Kind string
+
+const (
+	// Changed means both clusters have the field, but with different values.
+	Changed Kind = "changed"
+	// OnlyInA means only the first cluster has the field.
+	OnlyInA Kind = "only-in-a"
+	// OnlyInB means only the second cluster has the field.
+	OnlyInB Kind = "only-in-b"
+)
+
+// Difference is a single field that differs between the clusters.
+type Difference struct {
+	// Path of the field, such as "controller_props/Autoplacer/Weights/MaxFreeSpace" or
+	// "resource_groups/rg1/select_filter/place_count".
+	Path string `json:"path"`
+	Kind Kind   `json:"kind"`
+	// A is the value in the first cluster. Empty if Kind is OnlyInB.
+	A string `json:"a,omitempty"`
+	// B is the value in the second cluster. Empty if Kind is OnlyInA.
+	B string `json:"b,omitempty"`
+}
+
+func (d Difference) String() string {
+	switch d.Kind {
+	case OnlyInA:
+		return fmt.Sprintf("- %s: %s", d.Path, d.A)
+	case OnlyInB:
+		return fmt.Sprintf("+ %s: %s", d.Path, d.B)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", d.Path, d.A, d.B)
+	}
+}
+
+// DefaultIgnore are ignore patterns for fields that always differ between clusters.
+var DefaultIgnore = []string{"*Uuid*", "*UUID*", "*uuid*"}
+
+// Options control what counts as a difference.
+type Options struct {
+	// Ignore are patterns of paths to leave out of the report. "*" matches any sequence of characters, including
+	// "/", and "?" matches a single character.
+	Ignore []string
+	// NodeNames maps node names of the second cluster to the corresponding names in the first. Mapped names are
+	// replaced in property keys, property values and select filters before comparing.
+	NodeNames map[string]string
+}
+
+// Report is the result of a comparison.
+type Report struct {
+	Differences []Difference `json:"differences"`
+}
+
+// WriteText writes a human readable form of the report. Fields only in the first cluster are prefixed with "-",
+// fields only in the second with "+" and changed fields with "~".
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, d := range r.Differences {
+		b.WriteString(d.String())
+		b.WriteString("\n")
+	}
+
+	if len(r.Differences) == 0 {
+		b.WriteString("no differences found\n")
+	} else {
+		fmt.Fprintf(&b, "%d differences\n", len(r.Differences))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Clusters exports the configuration of both clusters and compares it.
+func Clusters(ctx context.Context, a, b *client.Client, opts Options) (*Report, error) {
+	docA, err := clusterconfig.New(a).Export(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export first cluster: %w", err)
+	}
+
+	docB, err := clusterconfig.New(b).Export(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export second cluster: %w", err)
+	}
+
+	return Compare(docA, docB, opts), nil
+}
+
+// Compare compares two documents.
+//
+// DRBD options of the controller and resource groups are reported below "drbd_options" instead of with the other
+// properties. DRBD options of resource definitions and nodes are compared only if the object exists in both
+// documents.
+func Compare(a, b *clusterconfig.Document, opts Options) *Report {
+	c := &comparer{nodeNames: opts.NodeNames}
+	for _, pattern := range opts.Ignore {
+		c.ignore = append(c.ignore, compilePattern(pattern))
+	}
+
+	c.props("controller_props", withoutDrbdOptions(a.ControllerProps), withoutDrbdOptions(c.mapProps(b.ControllerProps)))
+	c.storagePoolDefinitions(a.StoragePoolDefinitions, b.StoragePoolDefinitions)
+	c.resourceGroups(a.ResourceGroups, b.ResourceGroups)
+	c.drbdOptions(a, b)
+	c.externalFiles(a.ExternalFiles, b.ExternalFiles)
+
+	return &Report{Differences: c.differences}
+}
+
+type comparer struct {
+	ignore      []*regexp.Regexp
+	nodeNames   map[string]string
+	differences []Difference
+}
+
+func (c *comparer) add(d Difference) {
+	for _, re := range c.ignore {
+		if re.MatchString(d.Path) {
+			return
+		}
+	}
+
+	c.differences = append(c.differences, d)
+}
+
+// value compares a single field. ok reports whether the field exists.
+func (c *comparer) value(path string, a string, okA bool, b string, okB bool) {
+	switch {
+	case okA && !okB:
+		c.add(Difference{Path: path, Kind: OnlyInA, A: a})
+	case !okA && okB:
+		c.add(Difference{Path: path, Kind: OnlyInB, B: b})
+	case okA && okB && a != b:
+		c.add(Difference{Path: path, Kind: Changed, A: a, B: b})
+	}
+}
+
+func (c *comparer) props(prefix string, a, b map[string]string) {
+	for _, k := range sortedKeys(union(a, b)) {
+		va, okA := a[k]
+		vb, okB := b[k]
+		c.value(prefix+"/"+k, va, okA, vb, okB)
+	}
+}
+
+func (c *comparer) storagePoolDefinitions(a, b []client.StoragePoolDefinition) {
+	byNameA := make(map[string]client.StoragePoolDefinition)
+	for _, spd := range a {
+		byNameA[spd.StoragePoolName] = spd
+	}
+
+	byNameB := make(map[string]client.StoragePoolDefinition)
+	for _, spd := range b {
+		byNameB[spd.StoragePoolName] = spd
+	}
+
+	for _, name := range sortedKeys(union(byNameA, byNameB)) {
+		path := "storage_pool_definitions/" + name
+		spdA, okA := byNameA[name]
+		spdB, okB := byNameB[name]
+		if !okA || !okB {
+			c.value(path, "present", okA, "present", okB)
+			continue
+		}
+
+		c.props(path+"/props", spdA.Props, c.mapProps(spdB.Props))
+	}
+}
+
+func (c *comparer) resourceGroups(a, b []client.ResourceGroupTemplate) {
+	byNameA := make(map[string]client.ResourceGroupTemplate)
+	for _, rg := range a {
+		byNameA[rg.Name] = rg
+	}
+
+	byNameB := make(map[string]client.ResourceGroupTemplate)
+	for _, rg := range b {
+		byNameB[rg.Name] = rg
+	}
+
+	for _, name := range sortedKeys(union(byNameA, byNameB)) {
+		path := "resource_groups/" + name
+		rgA, okA := byNameA[name]
+		rgB, okB := byNameB[name]
+		if !okA || !okB {
+			c.value(path, "present", okA, "present", okB)
+			continue
+		}
+
+		filterB := rgB.SelectFilter
+		filterB.NodeNameList = c.mapNodes(filterB.NodeNameList)
+		c.selectFilter(path+"/select_filter", rgA.SelectFilter, filterB)
+	}
+}
+
+// selectFilter compares the select filters by their JSON fields, so that new fields are compared without changes
+// here.
+func (c *comparer) selectFilter(prefix string, a, b client.AutoSelectFilter) {
+	fieldsA, fieldsB := jsonFields(a), jsonFields(b)
+	for _, k := range sortedKeys(union(fieldsA, fieldsB)) {
+		va, okA := fieldsA[k]
+		vb, okB := fieldsB[k]
+		c.value(prefix+"/"+k, va, okA, vb, okB)
+	}
+}
+
+func (c *comparer) drbdOptions(a, b *clusterconfig.Document) {
+	c.props("drbd_options/controller", drbdOptions(a.ControllerProps), drbdOptions(c.mapProps(b.ControllerProps)))
+
+	propsA := make(map[string]map[string]string)
+	for _, rg := range a.ResourceGroups {
+		propsA[rg.Name] = rg.Props
+	}
+
+	propsB := make(map[string]map[string]string)
+	for _, rg := range b.ResourceGroups {
+		propsB[rg.Name] = rg.Props
+	}
+
+	for _, name := range sortedKeys(union(propsA, propsB)) {
+		c.props("drbd_options/resource_groups/"+name, drbdOptions(propsA[name]), drbdOptions(c.mapProps(propsB[name])))
+	}
+
+	propsA = make(map[string]map[string]string)
+	for _, rd := range a.ResourceDefinitions {
+		propsA[rd.Name] = rd.Props
+	}
+
+	propsB = make(map[string]map[string]string)
+	for _, rd := range b.ResourceDefinitions {
+		propsB[rd.Name] = rd.Props
+	}
+
+	for _, name := range sortedKeys(propsA) {
+		if props, ok := propsB[name]; ok {
+			c.props("drbd_options/resource_definitions/"+name, drbdOptions(propsA[name]), drbdOptions(c.mapProps(props)))
+		}
+	}
+
+	propsA = make(map[string]map[string]string)
+	for _, node := range a.Nodes {
+		propsA[node.Name] = node.Props
+	}
+
+	propsB = make(map[string]map[string]string)
+	for _, node := range b.Nodes {
+		propsB[c.mapNode(node.Name)] = node.Props
+	}
+
+	for _, name := range sortedKeys(propsA) {
+		if props, ok := propsB[name]; ok {
+			c.props("drbd_options/nodes/"+name, drbdOptions(propsA[name]), drbdOptions(c.mapProps(props)))
+		}
+	}
+}
+
+func (c *comparer) externalFiles(a, b []client.ExternalFile) {
+	contentA := make(map[string]string)
+	for _, f := range a {
+		contentA[f.Path] = describeContent(f.Content)
+	}
+
+	contentB := make(map[string]string)
+	for _, f := range b {
+		contentB[f.Path] = describeContent(f.Content)
+	}
+
+	for _, path := range sortedKeys(union(contentA, contentB)) {
+		va, okA := contentA[path]
+		vb, okB := contentB[path]
+		c.value("external_files"+path, va, okA, vb, okB)
+	}
+}
+
+func (c *comparer) mapNode(name string) string {
+	if mapped, ok := c.nodeNames[name]; ok {
+		return mapped
+	}
+
+	return name
+}
+
+func (c *comparer) mapNodes(names []string) []string {
+	if len(c.nodeNames) == 0 || names == nil {
+		return names
+	}
+
+	result := make([]string, len(names))
+	for i, name := range names {
+		result[i] = c.mapNode(name)
+	}
+
+	return result
+}
+
+// mapProps replaces node names in the key segments and values of properties of the second cluster.
+func (c *comparer) mapProps(props map[string]string) map[string]string {
+	if len(c.nodeNames) == 0 {
+		return props
+	}
+
+	result := make(map[string]string, len(props))
+	for k, v := range props {
+		result[strings.Join(c.mapNodes(strings.Split(k, "/")), "/")] = c.mapNode(v)
+	}
+
+	return result
+}
+
+func drbdOptions(props map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range props {
+		if strings.HasPrefix(k, KeyPrefixDrbdOptions) {
+			result[strings.TrimPrefix(k, KeyPrefixDrbdOptions)] = v
+		}
+	}
+
+	return result
+}
+
+func withoutDrbdOptions(props map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range props {
+		if !strings.HasPrefix(k, KeyPrefixDrbdOptions) {
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+// jsonFields returns the JSON encoded value of every field that is set.
+func jsonFields(v interface{}) map[string]string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(fields))
+	for k, v := range fields {
+		result[k] = string(v)
+	}
+
+	return result
+}
+
+// describeContent summarizes the content of an external file, which may be large or binary.
+func describeContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("%d bytes, sha256 %x", len(content), sum[:8])
+}
+
+func compilePattern(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+func union[V any](a, b map[string]V) map[string]bool {
+	result := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		result[k] = true
+	}
+	for k := range b {
+		result[k] = true
+	}
+
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,125 @@
+package diff_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/clusterconfig"
+	"github.com/LINBIT/golinstor/diff"
+)
+
+func documents() (*clusterconfig.Document, *clusterconfig.Document) {
+	a := &clusterconfig.Document{
+		Version: clusterconfig.Version,
+		ControllerProps: map[string]string{
+			"DrbdOptions/Net/protocol":           "C",
+			"Autoplacer/Weights/MaxFreeSpace":    "1",
+			"Aux/ClusterUuid":                    "aaaa",
+			"DrbdOptions/AutoEvictAllowEviction": "true",
+		},
+		Nodes: []clusterconfig.Node{
+			{Name: "a1", Props: map[string]string{"DrbdOptions/Net/max-buffers": "8000"}},
+			{Name: "a2"},
+		},
+		StoragePoolDefinitions: []client.StoragePoolDefinition{
+			{StoragePoolName: "data", Props: map[string]string{"PrefNic": "data"}},
+			{StoragePoolName: "old"},
+		},
+		ResourceGroups: []client.ResourceGroupTemplate{
+			{
+				Name:         "rg1",
+				Props:        map[string]string{"DrbdOptions/Resource/quorum": "majority"},
+				SelectFilter: client.AutoSelectFilter{PlaceCount: 2, NodeNameList: []string{"a1", "a2"}, StoragePool: "data"},
+			},
+		},
+		ResourceDefinitions: []clusterconfig.ResourceDefinition{
+			{Name: "r1", Props: map[string]string{"DrbdOptions/Disk/on-io-error": "detach"}},
+			{Name: "r2", Props: map[string]string{"DrbdOptions/Disk/on-io-error": "detach"}},
+		},
+		ExternalFiles: []client.ExternalFile{
+			{Path: "/etc/a", Content: []byte("hello")},
+			{Path: "/etc/b", Content: []byte("same")},
+		},
+	}
+
+	b := &clusterconfig.Document{
+		Version: clusterconfig.Version,
+		ControllerProps: map[string]string{
+			"DrbdOptions/Net/protocol": "A",
+			"Aux/ClusterUuid":          "bbbb",
+			"Aux/extra":                "b1",
+		},
+		Nodes: []clusterconfig.Node{
+			{Name: "b1", Props: map[string]string{"DrbdOptions/Net/max-buffers": "10000"}},
+			{Name: "b2"},
+		},
+		StoragePoolDefinitions: []client.StoragePoolDefinition{
+			{StoragePoolName: "data", Props: map[string]string{"PrefNic": "fast"}},
+		},
+		ResourceGroups: []client.ResourceGroupTemplate{
+			{
+				Name:         "rg1",
+				SelectFilter: client.AutoSelectFilter{PlaceCount: 3, NodeNameList: []string{"b1", "b2"}, StoragePool: "data"},
+			},
+			{Name: "rg2"},
+		},
+		ResourceDefinitions: []clusterconfig.ResourceDefinition{
+			{Name: "r1", Props: map[string]string{"DrbdOptions/Disk/on-io-error": "pass_on"}},
+		},
+		ExternalFiles: []client.ExternalFile{
+			{Path: "/etc/a", Content: []byte("hello!")},
+			{Path: "/etc/b", Content: []byte("same")},
+			{Path: "/etc/c", Content: []byte{}},
+		},
+	}
+
+	return a, b
+}
+
+func TestCompare(t *testing.T) {
+	a, b := documents()
+
+	report := diff.Compare(a, b, diff.Options{
+		Ignore:    diff.DefaultIgnore,
+		NodeNames: map[string]string{"b1": "a1", "b2": "a2"},
+	})
+
+	assert.Equal(t, []diff.Difference{
+		{Path: "controller_props/Autoplacer/Weights/MaxFreeSpace", Kind: diff.OnlyInA, A: "1"},
+		{Path: "controller_props/Aux/extra", Kind: diff.OnlyInB, B: "a1"},
+		{Path: "storage_pool_definitions/data/props/PrefNic", Kind: diff.Changed, A: "data", B: "fast"},
+		{Path: "storage_pool_definitions/old", Kind: diff.OnlyInA, A: "present"},
+		{Path: "resource_groups/rg1/select_filter/place_count", Kind: diff.Changed, A: "2", B: "3"},
+		{Path: "resource_groups/rg2", Kind: diff.OnlyInB, B: "present"},
+		{Path: "drbd_options/controller/AutoEvictAllowEviction", Kind: diff.OnlyInA, A: "true"},
+		{Path: "drbd_options/controller/Net/protocol", Kind: diff.Changed, A: "C", B: "A"},
+		{Path: "drbd_options/resource_groups/rg1/Resource/quorum", Kind: diff.OnlyInA, A: "majority"},
+		{Path: "drbd_options/resource_definitions/r1/Disk/on-io-error", Kind: diff.Changed, A: "detach", B: "pass_on"},
+		{Path: "drbd_options/nodes/a1/Net/max-buffers", Kind: diff.Changed, A: "8000", B: "10000"},
+		{Path: "external_files/etc/a", Kind: diff.Changed, A: "5 bytes, sha256 2cf24dba5fb0a30e", B: "6 bytes, sha256 ce06092fb948d9ff"},
+		{Path: "external_files/etc/c", Kind: diff.OnlyInB, B: "0 bytes, sha256 e3b0c44298fc1c14"},
+	}, report.Differences)
+}
+
+func TestCompareIgnore(t *testing.T) {
+	a, b := documents()
+
+	report := diff.Compare(a, b, diff.Options{Ignore: []string{"*uid", "drbd_options/*", "external_files/*", "resource_groups/rg?/*"}})
+
+	var buf bytes.Buffer
+	assert.NoError(t, report.WriteText(&buf))
+	assert.Equal(t, `- controller_props/Autoplacer/Weights/MaxFreeSpace: 1
++ controller_props/Aux/extra: b1
+~ storage_pool_definitions/data/props/PrefNic: data -> fast
+- storage_pool_definitions/old: present
++ resource_groups/rg2: present
+5 differences
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, diff.Compare(a, a, diff.Options{}).WriteText(&buf))
+	assert.Equal(t, "no differences found\n", buf.String())
+}
@@ -0,0 +1,408 @@
+// Package dr implements disaster recovery between two LINSTOR clusters.
+//
+// Resources are replicated by shipping snapshots from the primary to the secondary cluster using a LINSTOR remote.
+// On failover, the secondary is promoted, after which the direction of the replication can be reversed.
+//
+// All operations only act on resources that are not yet in the desired state, so they can be safely repeated, for
+// example after an interrupted failover.
+package dr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+)
+
+const (
+	// KeyRole is the resource definition property storing the replication role of a resource.
+	KeyRole = linstor.NamespcAuxiliary + "/DR/Role"
+	// KeyPeer is the resource definition property storing the name of the other site.
+	KeyPeer = linstor.NamespcAuxiliary + "/DR/Peer"
+
+	RolePrimary   = "primary"
+	RoleSecondary = "secondary"
+)
+
+// Site is one of the two LINSTOR clusters.
+type Site struct {
+	Name                string
+	Backups             client.BackupProvider
+	Remotes             client.RemoteProvider
+	Resources           client.ResourceProvider
+	ResourceDefinitions client.ResourceDefinitionProvider
+	// Remote is the LINSTOR remote on this site that points to the other site.
+	Remote client.LinstorRemote
+	// Nodes are the nodes that run the resources when this site is primary.
+	Nodes []string
+	// StorPool receives shipped snapshots and is used for resources created on promotion.
+	StorPool string
+}
+
+// NewSite returns a Site using the services of the given client.
+func NewSite(name string, c *client.Client, remote client.LinstorRemote, storPool string, nodes ...string) *Site {
+	return &Site{
+		Name:                name,
+		Backups:             c.Backup,
+		Remotes:             c.Remote,
+		Resources:           c.Resources,
+		ResourceDefinitions: c.ResourceDefinitions,
+		Remote:              remote,
+		Nodes:               nodes,
+		StorPool:            storPool,
+	}
+}
+
+// Replication replicates Resources from Primary to Secondary.
+type Replication struct {
+	Primary   *Site
+	Secondary *Site
+	// Resources are the resource definitions to replicate. They have the same names on both sites.
+	Resources []string
+	// OnError is called for errors during Run. Optional.
+	OnError func(err error)
+	// Now is used to compute the RPO lag. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// ResourceStatus is the replication state of a single resource.
+type ResourceStatus struct {
+	Resource string
+	// LastShipped is the time the last successful shipping finished. Zero if there was none yet.
+	LastShipped time.Time
+	// Lag is the time since LastShipped, i.e. the data that would be lost on failover. Negative if nothing was
+	// shipped yet.
+	Lag time.Duration
+	// InProgress is set if a shipping is currently running.
+	InProgress bool
+}
+
+// Status reports the RPO lag of all resources, based on the backups known to the primary's remote.
+func (r *Replication) Status(ctx context.Context) ([]ResourceStatus, error) {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+
+	result := make([]ResourceStatus, 0, len(r.Resources))
+	for _, rsc := range r.Resources {
+		list, err := r.Primary.Backups.GetAll(ctx, r.Primary.Remote.RemoteName, rsc, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backups of '%s': %w", rsc, err)
+		}
+
+		status := ResourceStatus{Resource: rsc, Lag: -1}
+		if list != nil {
+			for _, b := range list.Linstor {
+				if b.OriginRsc != rsc {
+					continue
+				}
+
+				if b.Shipping {
+					status.InProgress = true
+				}
+
+				if b.Success && b.FinishedTimestamp != nil && b.FinishedTimestamp.After(status.LastShipped) {
+					status.LastShipped = b.FinishedTimestamp.Time
+				}
+			}
+		}
+
+		if !status.LastShipped.IsZero() {
+			status.Lag = now().Sub(status.LastShipped)
+		}
+
+		result = append(result, status)
+	}
+
+	return result, nil
+}
+
+// EnsureRemote creates or updates the remotes on both sites.
+func (r *Replication) EnsureRemote(ctx context.Context) error {
+	for _, site := range []*Site{r.Primary, r.Secondary} {
+		if err := site.ensureRemote(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Site) ensureRemote(ctx context.Context) error {
+	remotes, err := s.Remotes.GetAllLinstor(ctx)
+	if err != nil {
+		return fmt.Errorf("site '%s': failed to list remotes: %w", s.Name, err)
+	}
+
+	for _, existing := range remotes {
+		if existing.RemoteName != s.Remote.RemoteName {
+			continue
+		}
+
+		if existing.Url == s.Remote.Url && (s.Remote.ClusterId == "" || existing.ClusterId == s.Remote.ClusterId) {
+			return nil
+		}
+
+		err := s.Remotes.ModifyLinstor(ctx, s.Remote.RemoteName, s.Remote)
+		if err != nil {
+			return fmt.Errorf("site '%s': failed to modify remote '%s': %w", s.Name, s.Remote.RemoteName, err)
+		}
+
+		return nil
+	}
+
+	err = s.Remotes.CreateLinstor(ctx, s.Remote)
+	if err != nil {
+		return fmt.Errorf("site '%s': failed to create remote '%s': %w", s.Name, s.Remote.RemoteName, err)
+	}
+
+	return nil
+}
+
+// ShipOnce ships the latest state of all resources that are not currently being shipped.
+//
+// Errors of single resources do not stop the shipping of the other resources, they are returned together.
+func (r *Replication) ShipOnce(ctx context.Context) error {
+	statuses, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, status := range statuses {
+		if status.InProgress {
+			continue
+		}
+
+		// Never overwrite a resource that already took over.
+		rd, err := r.Secondary.ResourceDefinitions.Get(ctx, status.Resource)
+		if err != nil && !errors.Is(err, client.NotFoundError) {
+			errs = append(errs, fmt.Errorf("site '%s': failed to get resource definition '%s': %w", r.Secondary.Name, status.Resource, err))
+			continue
+		}
+
+		if rd.Props[KeyRole] == RolePrimary {
+			errs = append(errs, fmt.Errorf("site '%s': resource '%s' was promoted, reverse the replication first", r.Secondary.Name, status.Resource))
+			continue
+		}
+
+		_, err = r.Primary.Backups.Ship(ctx, r.Primary.Remote.RemoteName, client.BackupShipRequest{
+			SrcRscName:  status.Resource,
+			DstRscName:  status.Resource,
+			DstStorPool: r.Secondary.StorPool,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to ship '%s' to site '%s': %w", status.Resource, r.Secondary.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Run calls ShipOnce every interval, until the context is cancelled. The interval defaults to 5 seconds.
+func (r *Replication) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.ShipOnce(ctx); err != nil && r.OnError != nil {
+			r.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Promote makes the secondary site take over all resources.
+//
+// For every resource, the data is reset to the newest shipped snapshot: existing replicas are rolled back to it,
+// or, if the resource is not deployed yet, the snapshot is restored onto the secondary's nodes. Diskful replicas
+// are then created on the secondary's nodes where they are missing, and the resource definitions are marked as
+// primary. Resources that are still receiving a shipment are not promoted. If the old primary is still reachable,
+// call Demote on it before using the resources.
+func (r *Replication) Promote(ctx context.Context) error {
+	var errs []error
+	for _, rsc := range r.Resources {
+		if err := r.Secondary.promote(ctx, rsc, r.Primary.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Site) promote(ctx context.Context, rsc, peer string) error {
+	rd, err := s.ResourceDefinitions.Get(ctx, rsc)
+	if err != nil {
+		return fmt.Errorf("site '%s': failed to get resource definition '%s': %w", s.Name, rsc, err)
+	}
+
+	if rd.Props[KeyRole] == RolePrimary {
+		return nil
+	}
+
+	snaps, err := s.Resources.GetSnapshots(ctx, rsc)
+	if err != nil {
+		return fmt.Errorf("site '%s': failed to get snapshots of '%s': %w", s.Name, rsc, err)
+	}
+
+	var latest *client.Snapshot
+	for i := range snaps {
+		snap := &snaps[i]
+		if receiving(snap) {
+			return fmt.Errorf("site '%s': resource '%s' is still receiving snapshot '%s'", s.Name, rsc, snap.Name)
+		}
+
+		if slices.Contains(snap.Flags, linstor.FlagSuccessful) && (latest == nil || createTime(snap).After(createTime(latest))) {
+			latest = snap
+		}
+	}
+
+	if latest == nil {
+		return fmt.Errorf("site '%s': resource '%s' has no shipped snapshot to restore", s.Name, rsc)
+	}
+
+	resources, err := s.Resources.GetAll(ctx, rsc)
+	if err != nil {
+		return fmt.Errorf("site '%s': failed to get resources of '%s': %w", s.Name, rsc, err)
+	}
+
+	deployed := make(map[string]bool, len(resources))
+	for _, res := range resources {
+		deployed[res.NodeName] = true
+	}
+
+	if len(resources) > 0 {
+		if err := s.Resources.RollbackSnapshot(ctx, rsc, latest.Name); err != nil {
+			return fmt.Errorf("site '%s': failed to roll back '%s' to snapshot '%s': %w", s.Name, rsc, latest.Name, err)
+		}
+	} else {
+		err := s.Resources.RestoreSnapshot(ctx, rsc, latest.Name, client.SnapshotRestore{ToResource: rsc, Nodes: s.Nodes})
+		if err != nil {
+			return fmt.Errorf("site '%s': failed to restore snapshot '%s' of '%s': %w", s.Name, latest.Name, rsc, err)
+		}
+
+		for _, node := range s.Nodes {
+			deployed[node] = true
+		}
+	}
+
+	for _, node := range s.Nodes {
+		if deployed[node] {
+			continue
+		}
+
+		err := s.Resources.Create(ctx, client.ResourceCreate{Resource: client.Resource{
+			Name:     rsc,
+			NodeName: node,
+			Props:    map[string]string{linstor.KeyStorPoolName: s.StorPool},
+		}})
+		if err != nil {
+			return fmt.Errorf("site '%s': failed to create '%s' on node '%s': %w", s.Name, rsc, node, err)
+		}
+	}
+
+	return s.setRole(ctx, rsc, RolePrimary, peer)
+}
+
+// receiving returns true if the snapshot is a shipment that has not finished yet.
+func receiving(snap *client.Snapshot) bool {
+	return slices.Contains(snap.Flags, linstor.FlagBackupTarget) && !slices.Contains(snap.Flags, linstor.FlagSuccessful)
+}
+
+// createTime returns the earliest creation time of the snapshot on any node.
+func createTime(snap *client.Snapshot) time.Time {
+	var t time.Time
+	for _, node := range snap.Snapshots {
+		if node.CreateTimestamp != nil && (t.IsZero() || node.CreateTimestamp.Before(t)) {
+			t = node.CreateTimestamp.Time
+		}
+	}
+
+	return t
+}
+
+// Demote marks the resources on the primary site as secondary, so that they can receive shipped snapshots.
+// The resources must not be in use.
+func (r *Replication) Demote(ctx context.Context) error {
+	var errs []error
+	for _, rsc := range r.Resources {
+		if err := r.Primary.demote(ctx, rsc, r.Secondary.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Site) demote(ctx context.Context, rsc, peer string) error {
+	rd, err := s.ResourceDefinitions.Get(ctx, rsc)
+	if err != nil {
+		return fmt.Errorf("site '%s': failed to get resource definition '%s': %w", s.Name, rsc, err)
+	}
+
+	if rd.Props[KeyRole] == RoleSecondary {
+		return nil
+	}
+
+	resources, err := s.Resources.GetAll(ctx, rsc)
+	if err != nil {
+		return fmt.Errorf("site '%s': failed to get resources of '%s': %w", s.Name, rsc, err)
+	}
+
+	for _, res := range resources {
+		if res.State != nil && res.State.InUse != nil && *res.State.InUse {
+			return fmt.Errorf("site '%s': resource '%s' is in use on node '%s'", s.Name, rsc, res.NodeName)
+		}
+	}
+
+	return s.setRole(ctx, rsc, RoleSecondary, peer)
+}
+
+func (s *Site) setRole(ctx context.Context, rsc, role, peer string) error {
+	err := s.ResourceDefinitions.Modify(ctx, rsc, client.GenericPropsModify{
+		OverrideProps: client.OverrideProps{KeyRole: role, KeyPeer: peer},
+	})
+	if err != nil {
+		return fmt.Errorf("site '%s': failed to mark '%s' as %s: %w", s.Name, rsc, role, err)
+	}
+
+	return nil
+}
+
+// Reverse returns the replication in the opposite direction, after the secondary was promoted.
+//
+// It demotes the old primary and ensures the remotes exist. If the old primary is not reachable, Reverse fails and
+// can be retried once it is back.
+func (r *Replication) Reverse(ctx context.Context) (*Replication, error) {
+	if err := r.Demote(ctx); err != nil {
+		return nil, err
+	}
+
+	reversed := &Replication{
+		Primary:   r.Secondary,
+		Secondary: r.Primary,
+		Resources: r.Resources,
+		OnError:   r.OnError,
+		Now:       r.Now,
+	}
+
+	if err := reversed.EnsureRemote(ctx); err != nil {
+		return nil, err
+	}
+
+	return reversed, nil
+}
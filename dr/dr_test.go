@@ -0,0 +1,230 @@
+package dr_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/dr"
+)
+
+type fakeSite struct {
+	backups   map[string]client.Backup
+	shipped   []client.BackupShipRequest
+	remotes   []client.LinstorRemote
+	resources map[string][]client.Resource
+	rds       map[string]client.ResourceDefinition
+	snaps     map[string][]client.Snapshot
+	restored  []string
+}
+
+func newFakeSite() *fakeSite {
+	return &fakeSite{
+		backups:   map[string]client.Backup{},
+		resources: map[string][]client.Resource{},
+		rds:       map[string]client.ResourceDefinition{},
+		snaps:     map[string][]client.Snapshot{},
+	}
+}
+
+func (f *fakeSite) site(name string, nodes ...string) *dr.Site {
+	return &dr.Site{
+		Name:                name,
+		Backups:             fakeBackups{f: f},
+		Remotes:             fakeRemotes{f: f},
+		Resources:           fakeResources{f: f},
+		ResourceDefinitions: fakeRDs{f: f},
+		Remote:              client.LinstorRemote{RemoteName: "to-other", Url: "http://other:3370"},
+		Nodes:               nodes,
+		StorPool:            "pool",
+	}
+}
+
+type fakeBackups struct {
+	client.BackupProvider
+	f *fakeSite
+}
+
+func (b fakeBackups) GetAll(_ context.Context, _ string, rscName string, _ string) (*client.BackupList, error) {
+	list := &client.BackupList{Linstor: map[string]client.Backup{}}
+	for id, backup := range b.f.backups {
+		if backup.OriginRsc == rscName {
+			list.Linstor[id] = backup
+		}
+	}
+	return list, nil
+}
+
+func (b fakeBackups) Ship(_ context.Context, _ string, request client.BackupShipRequest) (string, error) {
+	b.f.shipped = append(b.f.shipped, request)
+	return "snap", nil
+}
+
+type fakeRemotes struct {
+	client.RemoteProvider
+	f *fakeSite
+}
+
+func (r fakeRemotes) GetAllLinstor(_ context.Context, _ ...*client.ListOpts) ([]client.LinstorRemote, error) {
+	return r.f.remotes, nil
+}
+
+func (r fakeRemotes) CreateLinstor(_ context.Context, create client.LinstorRemote) error {
+	r.f.remotes = append(r.f.remotes, create)
+	return nil
+}
+
+type fakeResources struct {
+	client.ResourceProvider
+	f *fakeSite
+}
+
+func (r fakeResources) GetAll(_ context.Context, resName string, _ ...*client.ListOpts) ([]client.Resource, error) {
+	return r.f.resources[resName], nil
+}
+
+func (r fakeResources) GetSnapshots(_ context.Context, resName string, _ ...*client.ListOpts) ([]client.Snapshot, error) {
+	return r.f.snaps[resName], nil
+}
+
+func (r fakeResources) RollbackSnapshot(_ context.Context, resName, snapName string) error {
+	r.f.restored = append(r.f.restored, "rollback "+resName+"/"+snapName)
+	return nil
+}
+
+func (r fakeResources) RestoreSnapshot(_ context.Context, origResName, snapName string, restore client.SnapshotRestore) error {
+	r.f.restored = append(r.f.restored, "restore "+origResName+"/"+snapName)
+	for _, node := range restore.Nodes {
+		r.f.resources[restore.ToResource] = append(r.f.resources[restore.ToResource], client.Resource{Name: restore.ToResource, NodeName: node})
+	}
+	return nil
+}
+
+func (r fakeResources) Create(_ context.Context, res client.ResourceCreate) error {
+	r.f.resources[res.Resource.Name] = append(r.f.resources[res.Resource.Name], res.Resource)
+	return nil
+}
+
+type fakeRDs struct {
+	client.ResourceDefinitionProvider
+	f *fakeSite
+}
+
+func (r fakeRDs) Get(_ context.Context, resDefName string, _ ...*client.ListOpts) (client.ResourceDefinition, error) {
+	rd, ok := r.f.rds[resDefName]
+	if !ok {
+		return rd, client.NotFoundError
+	}
+	return rd, nil
+}
+
+func (r fakeRDs) Modify(_ context.Context, resDefName string, props client.GenericPropsModify) error {
+	rd := r.f.rds[resDefName]
+	if rd.Props == nil {
+		rd.Props = map[string]string{}
+	}
+	for k, v := range props.OverrideProps {
+		rd.Props[k] = v
+	}
+	r.f.rds[resDefName] = rd
+	return nil
+}
+
+func snap(name string, created time.Time, flags ...string) client.Snapshot {
+	return client.Snapshot{
+		Name:      name,
+		Flags:     flags,
+		Snapshots: []client.SnapshotNode{{CreateTimestamp: &client.TimeStampMs{Time: created}}},
+	}
+}
+
+func TestReplication(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	primary, secondary := newFakeSite(), newFakeSite()
+	primary.backups["b1"] = client.Backup{OriginRsc: "rsc1", Success: true, FinishedTimestamp: &client.TimeStampMs{Time: now.Add(-5 * time.Minute)}}
+	primary.backups["b2"] = client.Backup{OriginRsc: "rsc2", Shipping: true}
+	primary.rds["rsc1"] = client.ResourceDefinition{Name: "rsc1"}
+	primary.rds["rsc2"] = client.ResourceDefinition{Name: "rsc2"}
+	secondary.rds["rsc1"] = client.ResourceDefinition{Name: "rsc1"}
+	secondary.rds["rsc2"] = client.ResourceDefinition{Name: "rsc2"}
+	secondary.resources["rsc1"] = []client.Resource{{Name: "rsc1", NodeName: "b1"}}
+	secondary.snaps["rsc1"] = []client.Snapshot{
+		snap("s2", now.Add(-5*time.Minute), linstor.FlagSuccessful),
+		snap("s1", now.Add(-time.Hour), linstor.FlagSuccessful),
+	}
+	secondary.snaps["rsc2"] = []client.Snapshot{
+		snap("s1", now.Add(-time.Hour), linstor.FlagSuccessful),
+		snap("s2", now, linstor.FlagBackupTarget),
+	}
+
+	repl := &dr.Replication{
+		Primary:   primary.site("a", "a1", "a2"),
+		Secondary: secondary.site("b", "b1", "b2"),
+		Resources: []string{"rsc1", "rsc2"},
+		Now:       func() time.Time { return now },
+	}
+
+	status, err := repl.Status(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []dr.ResourceStatus{
+		{Resource: "rsc1", LastShipped: now.Add(-5 * time.Minute), Lag: 5 * time.Minute},
+		{Resource: "rsc2", Lag: -1, InProgress: true},
+	}, status)
+
+	require.NoError(t, repl.ShipOnce(context.Background()))
+	assert.Equal(t, []client.BackupShipRequest{{SrcRscName: "rsc1", DstRscName: "rsc1", DstStorPool: "pool"}}, primary.shipped)
+
+	require.NoError(t, repl.EnsureRemote(context.Background()))
+	require.NoError(t, repl.EnsureRemote(context.Background()))
+	assert.Len(t, primary.remotes, 1)
+	assert.Len(t, secondary.remotes, 1)
+
+	// A resource that is still receiving a shipment is not promoted.
+	err = repl.Promote(context.Background())
+	assert.EqualError(t, err, "site 'b': resource 'rsc2' is still receiving snapshot 's2'")
+	assert.Equal(t, []string{"rollback rsc1/s2"}, secondary.restored)
+	assert.Equal(t, dr.RolePrimary, secondary.rds["rsc1"].Props[dr.KeyRole])
+	assert.Empty(t, secondary.rds["rsc2"].Props[dr.KeyRole])
+
+	// Promotion is idempotent.
+	secondary.snaps["rsc2"][1].Flags = []string{linstor.FlagBackupTarget, linstor.FlagSuccessful}
+	require.NoError(t, repl.Promote(context.Background()))
+	require.NoError(t, repl.Promote(context.Background()))
+	assert.Equal(t, []string{"rollback rsc1/s2", "restore rsc2/s2"}, secondary.restored)
+	assert.Len(t, secondary.resources["rsc1"], 2)
+	assert.Len(t, secondary.resources["rsc2"], 2)
+	assert.Equal(t, dr.RolePrimary, secondary.rds["rsc2"].Props[dr.KeyRole])
+	assert.Error(t, repl.ShipOnce(context.Background()))
+	assert.Len(t, primary.shipped, 1)
+
+	// Reversing fails while the old primary is in use, and can be retried.
+	inUse := true
+	primary.resources["rsc2"] = []client.Resource{{Name: "rsc2", NodeName: "a1", State: &client.ResourceState{InUse: &inUse}}}
+	_, err = repl.Reverse(context.Background())
+	assert.Error(t, err)
+
+	inUse = false
+	reversed, err := repl.Reverse(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "b", reversed.Primary.Name)
+	assert.Equal(t, dr.RoleSecondary, primary.rds["rsc2"].Props[dr.KeyRole])
+	assert.Equal(t, "b", primary.rds["rsc2"].Props[dr.KeyPeer])
+}
+
+func TestReplicationRunDefaultInterval(t *testing.T) {
+	primary, secondary := newFakeSite(), newFakeSite()
+	repl := &dr.Replication{
+		Primary:   primary.site("a", "a1"),
+		Secondary: secondary.site("b", "b1"),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, repl.Run(ctx, 0), context.Canceled)
+}
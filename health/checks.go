@@ -0,0 +1,358 @@
+package health
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/devicelayerkind"
+)
+
+const nodeOnline = "ONLINE"
+
+// DefaultChecks returns all checks of this package with default thresholds.
+func DefaultChecks() []Check {
+	return []Check{
+		NodeStatus(),
+		StoragePools(90, 97),
+		DegradedResources(),
+		Quorum(),
+		ErrorReports(10),
+		Unsupported(),
+	}
+}
+
+// NodeStatus finds nodes that are not online, evicted or being evacuated.
+func NodeStatus() Check {
+	return Check{Name: "node-status", Run: func(s *State) []Finding {
+		var findings []Finding
+		for _, node := range s.Nodes {
+			obj := []Object{{Kind: "node", Name: node.Name}}
+			switch {
+			case slices.Contains(node.Flags, linstor.FlagEvicted):
+				findings = append(findings, Finding{
+					Severity: Warning,
+					Summary:  fmt.Sprintf("node '%s' is evicted", node.Name),
+					Objects:  obj,
+					Fix:      "restore the node once it is repaired, or delete it if it is gone for good",
+				})
+			case slices.Contains(node.Flags, linstor.FlagEvacuate):
+				findings = append(findings, Finding{
+					Severity: Info,
+					Summary:  fmt.Sprintf("node '%s' is being evacuated", node.Name),
+					Objects:  obj,
+				})
+			case node.ConnectionStatus != nodeOnline:
+				findings = append(findings, Finding{
+					Severity: Critical,
+					Summary:  fmt.Sprintf("node '%s' is %s", node.Name, node.ConnectionStatus),
+					Objects:  obj,
+					Fix:      "check that the satellite is running on the node and reachable from the controller",
+				})
+			}
+		}
+
+		return findings
+	}}
+}
+
+// StoragePools finds storage pools with error reports, and pools whose usage exceeds the given percentages.
+func StoragePools(warnPercent, critPercent float64) Check {
+	return Check{Name: "storage-pools", Run: func(s *State) []Finding {
+		var findings []Finding
+		for _, sp := range s.StoragePools {
+			obj := []Object{{Kind: "storage-pool", Name: sp.StoragePoolName, Node: sp.NodeName}}
+
+			for _, rc := range sp.Reports {
+				if uint64(rc.RetCode)&linstor.MaskError != linstor.MaskError {
+					continue
+				}
+
+				findings = append(findings, Finding{
+					Severity: Critical,
+					Summary:  fmt.Sprintf("storage pool '%s' on '%s': %s", sp.StoragePoolName, sp.NodeName, strings.TrimSpace(rc.Message)),
+					Objects:  obj,
+					Fix:      rc.Correction,
+				})
+			}
+
+			if sp.ProviderKind == client.DISKLESS || sp.TotalCapacity <= 0 {
+				continue
+			}
+
+			used := 100 * float64(sp.TotalCapacity-sp.FreeCapacity) / float64(sp.TotalCapacity)
+			severity := Info
+			switch {
+			case used >= critPercent:
+				severity = Critical
+			case used >= warnPercent:
+				severity = Warning
+			default:
+				continue
+			}
+
+			findings = append(findings, Finding{
+				Severity: severity,
+				Summary:  fmt.Sprintf("storage pool '%s' on '%s' is %.1f%% full", sp.StoragePoolName, sp.NodeName, used),
+				Objects:  obj,
+				Fix:      "add capacity to the pool or move resources to other pools",
+			})
+		}
+
+		return findings
+	}}
+}
+
+// DegradedResources finds failed resources, volumes that are not up to date and DRBD connections that are down.
+func DegradedResources() Check {
+	return Check{Name: "degraded-resources", Run: func(s *State) []Finding {
+		online := onlineNodes(s)
+
+		var findings []Finding
+		for _, rsc := range s.Resources {
+			// Problems on offline nodes are already reported by NodeStatus.
+			if !online[rsc.NodeName] {
+				continue
+			}
+
+			obj := []Object{{Kind: "resource", Name: rsc.Name, Node: rsc.NodeName}}
+
+			if slices.Contains(rsc.Flags, linstor.FlagFailed) || slices.Contains(rsc.Flags, linstor.FlagFailedDeployment) {
+				findings = append(findings, Finding{
+					Severity: Critical,
+					Summary:  fmt.Sprintf("resource '%s' on '%s' failed", rsc.Name, rsc.NodeName),
+					Objects:  obj,
+					Fix:      "check the error reports of the node, then delete and recreate the resource",
+				})
+				continue
+			}
+
+			for _, vol := range rsc.Volumes {
+				switch vol.State.DiskState {
+				case "", "UpToDate", "Diskless", "Created":
+					continue
+				}
+
+				findings = append(findings, Finding{
+					Severity: Warning,
+					Summary:  fmt.Sprintf("volume %d of resource '%s' on '%s' is %s", vol.VolumeNumber, rsc.Name, rsc.NodeName, vol.State.DiskState),
+					Objects:  obj,
+					Fix:      "wait for the resync to finish, or check the backing device if the state does not change",
+				})
+			}
+
+			drbd := drbdLayer(rsc.LayerObject)
+			if drbd == nil {
+				continue
+			}
+
+			var down []string
+			for peer, conn := range drbd.Connections {
+				if !conn.Connected && online[peer] {
+					down = append(down, peer)
+				}
+			}
+
+			if len(down) > 0 {
+				sort.Strings(down)
+				findings = append(findings, Finding{
+					Severity: Warning,
+					Summary:  fmt.Sprintf("resource '%s' on '%s' is not connected to %s", rsc.Name, rsc.NodeName, strings.Join(down, ", ")),
+					Objects:  obj,
+					Fix:      "check the network between the nodes, then run 'drbdadm adjust' on the affected nodes",
+				})
+			}
+		}
+
+		return findings
+	}}
+}
+
+// Quorum finds resource definitions where a majority of the replicas, including diskless tie-breakers, is not
+// reachable, so that DRBD quorum is lost or would be lost if one more node fails. Resources with a single replica
+// have no quorum to lose and are skipped.
+func Quorum() Check {
+	return Check{Name: "quorum", Run: func(s *State) []Finding {
+		online := onlineNodes(s)
+
+		type counts struct{ total, reachable int }
+		byName := make(map[string]*counts)
+		var names []string
+		for _, rsc := range s.Resources {
+			if drbdLayer(rsc.LayerObject) == nil {
+				continue
+			}
+
+			c, ok := byName[rsc.Name]
+			if !ok {
+				c = &counts{}
+				byName[rsc.Name] = c
+				names = append(names, rsc.Name)
+			}
+
+			c.total++
+			if online[rsc.NodeName] && !slices.Contains(rsc.Flags, linstor.FlagFailed) {
+				c.reachable++
+			}
+		}
+
+		sort.Strings(names)
+
+		var findings []Finding
+		for _, name := range names {
+			c := byName[name]
+			if c.total == 1 {
+				continue
+			}
+
+			obj := []Object{{Kind: "resource-definition", Name: name}}
+			switch {
+			case 2*c.reachable <= c.total:
+				findings = append(findings, Finding{
+					Severity: Critical,
+					Summary:  fmt.Sprintf("resource '%s' has no quorum: %d of %d replicas reachable", name, c.reachable, c.total),
+					Objects:  obj,
+					Fix:      "bring the offline nodes back; do not force the resource primary unless the other replicas are lost",
+				})
+			case c.reachable < c.total && 2*(c.reachable-1) <= c.total:
+				findings = append(findings, Finding{
+					Severity: Warning,
+					Summary:  fmt.Sprintf("resource '%s' loses quorum if one more replica fails: %d of %d replicas reachable", name, c.reachable, c.total),
+					Objects:  obj,
+					Fix:      "restore the missing replicas or add a diskless tie-breaker",
+				})
+			}
+		}
+
+		return findings
+	}}
+}
+
+// ErrorReports reports a spike if at least threshold error reports were created in the error report window.
+func ErrorReports(threshold int) Check {
+	return Check{Name: "error-reports", Run: func(s *State) []Finding {
+		if len(s.ErrorReports) == 0 {
+			return nil
+		}
+
+		byNode := make(map[string]int)
+		for _, report := range s.ErrorReports {
+			byNode[report.NodeName]++
+		}
+
+		var nodes []string
+		for node := range byNode {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+
+		var objects []Object
+		var parts []string
+		for _, node := range nodes {
+			parts = append(parts, fmt.Sprintf("%s: %d", node, byNode[node]))
+			if node != "" {
+				objects = append(objects, Object{Kind: "node", Name: node})
+			}
+		}
+
+		severity := Info
+		if len(s.ErrorReports) >= threshold {
+			severity = Warning
+		}
+
+		return []Finding{{
+			Severity: severity,
+			Summary:  fmt.Sprintf("%d new error reports (%s)", len(s.ErrorReports), strings.Join(parts, ", ")),
+			Objects:  objects,
+			Fix:      "inspect the reports with 'linstor error-reports list'",
+		}}
+	}}
+}
+
+// Unsupported finds layers and storage providers that are not available on a node. It is a warning if a storage
+// pool or resource on the node uses them, and informational otherwise.
+func Unsupported() Check {
+	return Check{Name: "unsupported", Run: func(s *State) []Finding {
+		var findings []Finding
+		for _, node := range s.Nodes {
+			for _, kind := range sortedKeys(node.UnsupportedProviders) {
+				used := false
+				for _, sp := range s.StoragePools {
+					if sp.NodeName == node.Name && sp.ProviderKind == kind {
+						used = true
+					}
+				}
+
+				findings = append(findings, unsupportedFinding(node.Name, "storage provider", string(kind), node.UnsupportedProviders[kind], used))
+			}
+
+			for _, kind := range sortedKeys(node.UnsupportedLayers) {
+				used := false
+				for _, rsc := range s.Resources {
+					if rsc.NodeName == node.Name && usesLayer(rsc.LayerObject, kind) {
+						used = true
+					}
+				}
+
+				findings = append(findings, unsupportedFinding(node.Name, "layer", string(kind), node.UnsupportedLayers[kind], used))
+			}
+		}
+
+		return findings
+	}}
+}
+
+func unsupportedFinding(node, what, kind string, reasons []string, used bool) Finding {
+	f := Finding{
+		Severity: Info,
+		Summary:  fmt.Sprintf("%s %s is not supported on '%s'", what, kind, node),
+		Objects:  []Object{{Kind: "node", Name: node}},
+	}
+
+	if len(reasons) > 0 {
+		f.Summary += ": " + strings.Join(reasons, "; ")
+	}
+
+	if used {
+		f.Severity = Warning
+		f.Summary += " (in use)"
+		f.Fix = "install the missing tools or kernel modules on the node and reconnect it"
+	}
+
+	return f
+}
+
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	return keys
+}
+
+func onlineNodes(s *State) map[string]bool {
+	online := make(map[string]bool, len(s.Nodes))
+	for _, node := range s.Nodes {
+		online[node.Name] = node.ConnectionStatus == nodeOnline
+	}
+
+	return online
+}
+
+func drbdLayer(layer *client.ResourceLayer) *client.DrbdResource {
+	if drbd := layer.Find(devicelayerkind.Drbd); drbd != nil {
+		return drbd.Drbd
+	}
+
+	return nil
+}
+
+func usesLayer(layer *client.ResourceLayer, kind devicelayerkind.DeviceLayerKind) bool {
+	return layer.Find(kind) != nil
+}
@@ -0,0 +1,244 @@
+// Package health diagnoses problems in a LINSTOR cluster.
+//
+// A Doctor collects the state of the cluster once and passes it to a list of checks. Each check returns findings
+// with a severity, the affected objects and a suggested fix. Checks are plain values, so callers can remove
+// default checks or add their own.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// Severity of a finding.
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+var severityNames = []string{"info", "warning", "critical"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+
+	return severityNames[s]
+}
+
+// MarshalText implements encoding.TextMarshaler, so severities are rendered as names in JSON.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Severity) UnmarshalText(text []byte) error {
+	for i, name := range severityNames {
+		if name == string(text) {
+			*s = Severity(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown severity '%s'", text)
+}
+
+// Object is a LINSTOR object affected by a finding.
+type Object struct {
+	// Kind is one of "node", "storage-pool", "resource" or "resource-definition".
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Node is set for objects that belong to a node, such as storage pools and resources.
+	Node string `json:"node,omitempty"`
+}
+
+func (o Object) String() string {
+	if o.Node != "" {
+		return fmt.Sprintf("%s/%s@%s", o.Kind, o.Name, o.Node)
+	}
+
+	return o.Kind + "/" + o.Name
+}
+
+// Finding is a single problem found by a check.
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Summary  string   `json:"summary"`
+	Objects  []Object `json:"objects,omitempty"`
+	// Fix is a suggestion on how to resolve the problem. Optional.
+	Fix string `json:"fix,omitempty"`
+}
+
+// State is the cluster state passed to the checks.
+type State struct {
+	Nodes        []client.Node
+	StoragePools []client.StoragePool
+	Resources    []client.ResourceWithVolumes
+	// ErrorReports are the reports created within Doctor.ErrorReportWindow.
+	ErrorReports []client.ErrorReport
+	// Now is the time the state was collected.
+	Now time.Time
+}
+
+// Check inspects the cluster state and returns its findings.
+type Check struct {
+	Name string
+	Run  func(s *State) []Finding
+}
+
+// Doctor runs checks against a cluster.
+type Doctor struct {
+	Nodes      client.NodeProvider
+	Resources  client.ResourceProvider
+	Controller client.ControllerProvider
+	Checks     []Check
+	// ErrorReportWindow is how far back error reports are fetched. Defaults to one hour.
+	ErrorReportWindow time.Duration
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewDoctor returns a Doctor using the services of the given client and the DefaultChecks.
+func NewDoctor(c *client.Client) *Doctor {
+	return &Doctor{
+		Nodes:      c.Nodes,
+		Resources:  c.Resources,
+		Controller: c.Controller,
+		Checks:     DefaultChecks(),
+	}
+}
+
+// Collect fetches the cluster state.
+func (d *Doctor) Collect(ctx context.Context) (*State, error) {
+	now := time.Now
+	if d.Now != nil {
+		now = d.Now
+	}
+
+	window := d.ErrorReportWindow
+	if window == 0 {
+		window = time.Hour
+	}
+
+	s := &State{Now: now()}
+
+	var err error
+	s.Nodes, err = d.Nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	s.StoragePools, err = d.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage pools: %w", err)
+	}
+
+	s.Resources, err = d.Resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+
+	s.ErrorReports, err = d.Controller.GetErrorReportsSince(ctx, s.Now.Add(-window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get error reports: %w", err)
+	}
+
+	return s, nil
+}
+
+// Run collects the cluster state and runs all checks.
+func (d *Doctor) Run(ctx context.Context) (*Report, error) {
+	s, err := d.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return Diagnose(s, d.Checks...), nil
+}
+
+// Diagnose runs the checks against an already collected state.
+func Diagnose(s *State, checks ...Check) *Report {
+	report := &Report{Time: s.Now, Findings: []Finding{}}
+	for _, check := range checks {
+		for _, f := range check.Run(s) {
+			f.Check = check.Name
+			report.Findings = append(report.Findings, f)
+		}
+	}
+
+	// Most severe first, the order within a check is kept.
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Severity > report.Findings[j].Severity
+	})
+
+	return report
+}
+
+// Report is the result of running the checks.
+type Report struct {
+	Time     time.Time `json:"time"`
+	Findings []Finding `json:"findings"`
+}
+
+// Healthy returns true if there are no findings of at least Warning severity.
+func (r *Report) Healthy() bool {
+	return r.Count(Warning) == 0 && r.Count(Critical) == 0
+}
+
+// Count returns the number of findings with the given severity.
+func (r *Report) Count(severity Severity) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+
+	return n
+}
+
+// WriteText writes a human readable form of the report.
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, f := range r.Findings {
+		fmt.Fprintf(&b, "[%s] %s: %s\n", strings.ToUpper(f.Severity.String()), f.Check, f.Summary)
+		if len(f.Objects) > 0 {
+			objects := make([]string, len(f.Objects))
+			for i, o := range f.Objects {
+				objects[i] = o.String()
+			}
+			fmt.Fprintf(&b, "    affected: %s\n", strings.Join(objects, ", "))
+		}
+
+		if f.Fix != "" {
+			fmt.Fprintf(&b, "    fix: %s\n", f.Fix)
+		}
+	}
+
+	if len(r.Findings) == 0 {
+		b.WriteString("no problems found\n")
+	} else {
+		fmt.Fprintf(&b, "%d critical, %d warning, %d info\n", r.Count(Critical), r.Count(Warning), r.Count(Info))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
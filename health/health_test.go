@@ -0,0 +1,296 @@
+package health_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/devicelayerkind"
+	"github.com/LINBIT/golinstor/health"
+)
+
+// errorRc is an error return code, which does not fit into an int64 constant.
+var errorRc uint64 = linstor.MaskError | 1
+
+func drbdResource(name, node string, connections map[string]bool, diskState string) client.ResourceWithVolumes {
+	conns := make(map[string]client.DrbdConnection)
+	for peer, connected := range connections {
+		conns[peer] = client.DrbdConnection{Connected: connected}
+	}
+
+	return client.ResourceWithVolumes{
+		Resource: client.Resource{
+			Name:     name,
+			NodeName: node,
+			LayerObject: &client.ResourceLayer{
+				Type: devicelayerkind.Drbd,
+				Drbd: &client.DrbdResource{Connections: conns},
+				Children: []client.ResourceLayer{
+					{Type: devicelayerkind.Storage},
+				},
+			},
+		},
+		Volumes: []client.Volume{{State: client.VolumeState{DiskState: diskState}}},
+	}
+}
+
+func testState() *health.State {
+	return &health.State{
+		Now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Nodes: []client.Node{
+			{Name: "n1", ConnectionStatus: "ONLINE"},
+			{Name: "n2", ConnectionStatus: "ONLINE", UnsupportedLayers: map[devicelayerkind.DeviceLayerKind][]string{
+				devicelayerkind.Writecache: {"dm-writecache kernel module not loaded"},
+			}},
+			{Name: "n3", ConnectionStatus: "OFFLINE"},
+		},
+		StoragePools: []client.StoragePool{
+			{StoragePoolName: "pool", NodeName: "n1", ProviderKind: client.LVM, TotalCapacity: 1000, FreeCapacity: 20},
+			{StoragePoolName: "pool", NodeName: "n2", ProviderKind: client.LVM, TotalCapacity: 1000, FreeCapacity: 500, Reports: []client.ApiCallRc{
+				{RetCode: int64(errorRc), Message: "volume group not found"},
+			}},
+		},
+		Resources: []client.ResourceWithVolumes{
+			drbdResource("r1", "n1", map[string]bool{"n2": false, "n3": false}, "UpToDate"),
+			drbdResource("r1", "n2", map[string]bool{"n1": false, "n3": false}, "Outdated"),
+			drbdResource("r1", "n3", map[string]bool{"n1": false, "n2": false}, "DUnknown"),
+			drbdResource("r2", "n1", map[string]bool{"n3": false}, "UpToDate"),
+			drbdResource("r2", "n3", map[string]bool{"n1": false}, "DUnknown"),
+			// A single replica has no quorum to lose.
+			drbdResource("r3", "n3", nil, "DUnknown"),
+		},
+		ErrorReports: []client.ErrorReport{{NodeName: "n1"}, {NodeName: "n1"}, {NodeName: "n2"}},
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	report := health.Diagnose(testState(), health.DefaultChecks()...)
+
+	var summaries []string
+	for _, f := range report.Findings {
+		summaries = append(summaries, f.Severity.String()+" "+f.Check+": "+f.Summary)
+	}
+
+	assert.Equal(t, []string{
+		"critical node-status: node 'n3' is OFFLINE",
+		"critical storage-pools: storage pool 'pool' on 'n1' is 98.0% full",
+		"critical storage-pools: storage pool 'pool' on 'n2': volume group not found",
+		"critical quorum: resource 'r2' has no quorum: 1 of 2 replicas reachable",
+		"warning degraded-resources: resource 'r1' on 'n1' is not connected to n2",
+		"warning degraded-resources: volume 0 of resource 'r1' on 'n2' is Outdated",
+		"warning degraded-resources: resource 'r1' on 'n2' is not connected to n1",
+		"warning quorum: resource 'r1' loses quorum if one more replica fails: 2 of 3 replicas reachable",
+		"info error-reports: 3 new error reports (n1: 2, n2: 1)",
+		"info unsupported: layer WRITECACHE is not supported on 'n2': dm-writecache kernel module not loaded",
+	}, summaries)
+	assert.False(t, report.Healthy())
+	assert.Equal(t, 4, report.Count(health.Critical))
+
+	var custom health.Check
+	custom.Name = "custom"
+	custom.Run = func(s *health.State) []health.Finding {
+		return []health.Finding{{Severity: health.Info, Summary: "hello"}}
+	}
+
+	report = health.Diagnose(&health.State{}, custom, health.ErrorReports(1))
+	assert.True(t, report.Healthy())
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, "custom", report.Findings[0].Check)
+}
+
+func TestReportOutput(t *testing.T) {
+	state := &health.State{
+		Now:   time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Nodes: []client.Node{{Name: "n1", ConnectionStatus: "OFFLINE"}},
+	}
+	report := health.Diagnose(state, health.NodeStatus())
+
+	var text bytes.Buffer
+	require.NoError(t, report.WriteText(&text))
+	assert.Equal(t, `[CRITICAL] node-status: node 'n1' is OFFLINE
+    affected: node/n1
+    fix: check that the satellite is running on the node and reachable from the controller
+1 critical, 0 warning, 0 info
+`, text.String())
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteJSON(&buf))
+
+	var decoded health.Report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, report, &decoded)
+	assert.Contains(t, buf.String(), `"severity": "critical"`)
+
+	text.Reset()
+	require.NoError(t, health.Diagnose(&health.State{}).WriteText(&text))
+	assert.Equal(t, "no problems found\n", text.String())
+}
+
+type fakeNodes struct {
+	client.NodeProvider
+	state *health.State
+}
+
+func (f *fakeNodes) GetAll(context.Context, ...*client.ListOpts) ([]client.Node, error) {
+	return f.state.Nodes, nil
+}
+
+func (f *fakeNodes) GetStoragePoolView(context.Context, ...*client.ListOpts) ([]client.StoragePool, error) {
+	return f.state.StoragePools, nil
+}
+
+type fakeResources struct {
+	client.ResourceProvider
+	state *health.State
+}
+
+func (f *fakeResources) GetResourceView(context.Context, ...*client.ListOpts) ([]client.ResourceWithVolumes, error) {
+	return f.state.Resources, nil
+}
+
+type fakeController struct {
+	client.ControllerProvider
+	state *health.State
+	since time.Time
+}
+
+func (f *fakeController) GetErrorReportsSince(_ context.Context, since time.Time, _ ...*client.ListOpts) ([]client.ErrorReport, error) {
+	f.since = since
+	return f.state.ErrorReports, nil
+}
+
+func TestDoctor(t *testing.T) {
+	state := testState()
+	controller := &fakeController{state: state}
+	doctor := &health.Doctor{
+		Nodes:      &fakeNodes{state: state},
+		Resources:  &fakeResources{state: state},
+		Controller: controller,
+		Checks:     []health.Check{health.NodeStatus()},
+		Now:        func() time.Time { return state.Now },
+	}
+
+	report, err := doctor.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, state.Now.Add(-time.Hour), controller.since)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, []health.Object{{Kind: "node", Name: "n3"}}, report.Findings[0].Objects)
+}
+
+func replica(node string, flags []string, inUse bool, diskState string, peers map[string]string) client.ResourceWithVolumes {
+	conns := make(map[string]client.DrbdConnection)
+	for peer, msg := range peers {
+		conns[peer] = client.DrbdConnection{Connected: msg == "Connected", Message: msg}
+	}
+
+	return client.ResourceWithVolumes{
+		Resource: client.Resource{
+			Name:        "rsc",
+			NodeName:    node,
+			Flags:       flags,
+			State:       &client.ResourceState{InUse: &inUse},
+			LayerObject: &client.ResourceLayer{Type: devicelayerkind.Drbd, Drbd: &client.DrbdResource{Connections: conns}},
+		},
+		Volumes: []client.Volume{{State: client.VolumeState{DiskState: diskState}}},
+	}
+}
+
+func TestAnalyzeReplicas(t *testing.T) {
+	all := map[string]string{"n1": "Connected", "n2": "Connected", "n3": "Connected"}
+	peers := func(node string, override map[string]string) map[string]string {
+		result := make(map[string]string)
+		for peer, msg := range all {
+			if peer != node {
+				result[peer] = msg
+			}
+		}
+		for peer, msg := range override {
+			result[peer] = msg
+		}
+		return result
+	}
+
+	tiebreaker := []string{linstor.FlagDrbdDiskless, linstor.FlagTieBreaker}
+
+	cases := []struct {
+		name      string
+		resources []client.ResourceWithVolumes
+		state     health.ResourceState
+		reasons   []string
+	}{{
+		name: "healthy",
+		resources: []client.ResourceWithVolumes{
+			replica("n1", nil, true, "UpToDate", peers("n1", nil)),
+			replica("n2", nil, false, "UpToDate", peers("n2", nil)),
+			replica("n3", tiebreaker, false, "Diskless", peers("n3", nil)),
+		},
+		state: health.StateHealthy,
+	}, {
+		name: "resyncing",
+		resources: []client.ResourceWithVolumes{
+			replica("n1", nil, false, "UpToDate", peers("n1", nil)),
+			replica("n2", nil, false, "SyncTarget(12.50%)", peers("n2", nil)),
+			replica("n3", tiebreaker, false, "Diskless", peers("n3", nil)),
+		},
+		state:   health.StateResyncing,
+		reasons: []string{"resyncing: n2"},
+	}, {
+		name: "degraded",
+		resources: []client.ResourceWithVolumes{
+			replica("n1", nil, false, "UpToDate", peers("n1", map[string]string{"n2": "Connecting"})),
+			replica("n2", nil, false, "Outdated", peers("n2", map[string]string{"n1": "Connecting"})),
+			replica("n3", tiebreaker, false, "Diskless", peers("n3", nil)),
+		},
+		state:   health.StateDegraded,
+		reasons: []string{"disconnected: n1->n2, n2->n1", "not up to date: n2", "1 of 2 replicas up to date"},
+	}, {
+		name: "split brain",
+		resources: []client.ResourceWithVolumes{
+			replica("n1", nil, true, "UpToDate", peers("n1", map[string]string{"n2": "StandAlone"})),
+			replica("n2", nil, true, "UpToDate", peers("n2", map[string]string{"n1": "StandAlone"})),
+			replica("n3", tiebreaker, false, "Diskless", peers("n3", nil)),
+		},
+		state:   health.StateSplitBrainSuspected,
+		reasons: []string{"stand-alone connections: n1->n2, n2->n1", "in use on more than one node: n1, n2"},
+	}, {
+		name: "quorum lost",
+		resources: []client.ResourceWithVolumes{
+			replica("n1", nil, false, "UpToDate", peers("n1", map[string]string{"n2": "Connecting", "n3": "Connecting"})),
+			replica("n2", nil, false, "DUnknown", nil),
+			replica("n3", tiebreaker, false, "DUnknown", nil),
+		},
+		state: health.StateQuorumLost,
+		reasons: []string{
+			"no replica is connected to a majority of the replicas",
+			"disconnected: n1->n2, n1->n3, n2->n1, n2->n3, n3->n1, n3->n2",
+			"not up to date: n2",
+			"1 of 2 replicas up to date",
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := health.AnalyzeReplicas("rsc", 2, c.resources)
+			assert.Equal(t, c.state, h.State)
+			assert.Equal(t, c.reasons, h.Reasons)
+			assert.Equal(t, []string{"n3"}, h.TieBreakers)
+			assert.Empty(t, h.Diskless)
+			assert.Len(t, h.Replicas, 3)
+		})
+	}
+
+	h := health.AnalyzeReplicas("rsc", 3, cases[0].resources)
+	assert.Equal(t, health.StateDegraded, h.State)
+	assert.Equal(t, 2, h.UpToDate)
+	assert.Equal(t, 1, h.MissingReplicas())
+	assert.True(t, h.Connections["n1"]["n2"].Connected)
+	assert.Equal(t, health.RoleTieBreaker, h.Replicas[2].Role)
+	assert.True(t, h.Replicas[0].Quorum)
+}
@@ -0,0 +1,261 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+)
+
+// ResourceState classifies the replicas of a resource definition as a whole.
+type ResourceState string
+
+const (
+	StateHealthy   ResourceState = "healthy"
+	StateResyncing ResourceState = "resyncing"
+	StateDegraded  ResourceState = "degraded"
+	// StateSplitBrainSuspected is set if a connection is StandAlone, or if more than one replica is in use.
+	StateSplitBrainSuspected ResourceState = "split-brain-suspected"
+	StateQuorumLost          ResourceState = "quorum-lost"
+)
+
+// ReplicaRole is the role of a replica in the resource.
+type ReplicaRole string
+
+const (
+	RoleDiskful    ReplicaRole = "diskful"
+	RoleDiskless   ReplicaRole = "diskless"
+	RoleTieBreaker ReplicaRole = "tiebreaker"
+)
+
+// Replica is the state of a resource on a single node.
+type Replica struct {
+	Node string      `json:"node"`
+	Role ReplicaRole `json:"role"`
+	// DiskStates are the disk states of the volumes, by volume number.
+	DiskStates map[int32]string `json:"disk_states,omitempty"`
+	InUse      bool             `json:"in_use"`
+	// Quorum is set if the replica, together with the peers it is connected to, forms a majority.
+	Quorum bool `json:"quorum"`
+}
+
+// UpToDate returns true if the replica is diskful and all its volumes are up to date.
+func (r *Replica) UpToDate() bool {
+	if r.Role != RoleDiskful || len(r.DiskStates) == 0 {
+		return false
+	}
+
+	for _, state := range r.DiskStates {
+		if state != "UpToDate" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Resyncing returns true if the replica is diskful and at least one volume is being synchronized.
+func (r *Replica) Resyncing() bool {
+	if r.Role != RoleDiskful {
+		return false
+	}
+
+	for _, state := range r.DiskStates {
+		if isResyncState(state) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isResyncState(state string) bool {
+	return state == "Inconsistent" || strings.HasPrefix(state, "SyncTarget")
+}
+
+// ReplicaHealth is the analysis of all replicas of a resource definition.
+type ReplicaHealth struct {
+	Resource string        `json:"resource"`
+	State    ResourceState `json:"state"`
+	// Reasons explain the State. Empty if the resource is healthy.
+	Reasons  []string  `json:"reasons,omitempty"`
+	Replicas []Replica `json:"replicas"`
+	// Connections is the connection matrix: Connections[a][b] is the connection from a to b, as reported by a.
+	// Missing entries mean that a did not report a connection to b, for example because a is offline.
+	Connections map[string]map[string]client.DrbdConnection `json:"connections"`
+	// UpToDate is the number of diskful replicas with all volumes up to date.
+	UpToDate int `json:"up_to_date"`
+	// PlaceCount is the number of diskful replicas wanted by the resource group. 0 if unknown.
+	PlaceCount  int      `json:"place_count"`
+	Diskless    []string `json:"diskless,omitempty"`
+	TieBreakers []string `json:"tiebreakers,omitempty"`
+}
+
+// MissingReplicas returns how many up to date replicas are missing to reach PlaceCount.
+func (h *ReplicaHealth) MissingReplicas() int {
+	if h.UpToDate >= h.PlaceCount {
+		return 0
+	}
+
+	return h.PlaceCount - h.UpToDate
+}
+
+// FetchReplicaHealth analyzes the replicas of a resource definition, taking PlaceCount from its resource group.
+func FetchReplicaHealth(ctx context.Context, resources client.ResourceProvider, rds client.ResourceDefinitionProvider, groups client.ResourceGroupProvider, resName string) (*ReplicaHealth, error) {
+	rd, err := rds.Get(ctx, resName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource definition '%s': %w", resName, err)
+	}
+
+	placeCount := 0
+	if rd.ResourceGroupName != "" {
+		rg, err := groups.Get(ctx, rd.ResourceGroupName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get resource group '%s': %w", rd.ResourceGroupName, err)
+		}
+		placeCount = int(rg.SelectFilter.PlaceCount)
+	}
+
+	rscs, err := resources.GetResourceView(ctx, &client.ListOpts{Resource: []string{resName}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources of '%s': %w", resName, err)
+	}
+
+	return AnalyzeReplicas(resName, placeCount, rscs), nil
+}
+
+// AnalyzeReplicas analyzes the replicas of the resource definition resName. Resources of other resource
+// definitions are ignored, so the complete resource view can be passed.
+func AnalyzeReplicas(resName string, placeCount int, resources []client.ResourceWithVolumes) *ReplicaHealth {
+	h := &ReplicaHealth{
+		Resource:    resName,
+		PlaceCount:  placeCount,
+		Replicas:    []Replica{},
+		Connections: make(map[string]map[string]client.DrbdConnection),
+	}
+
+	for _, rsc := range resources {
+		if rsc.Name != resName {
+			continue
+		}
+
+		replica := Replica{Node: rsc.NodeName, Role: RoleDiskful, DiskStates: make(map[int32]string)}
+		switch {
+		case slices.Contains(rsc.Flags, linstor.FlagTieBreaker):
+			replica.Role = RoleTieBreaker
+			h.TieBreakers = append(h.TieBreakers, rsc.NodeName)
+		case slices.Contains(rsc.Flags, linstor.FlagDiskless) || slices.Contains(rsc.Flags, linstor.FlagDrbdDiskless):
+			replica.Role = RoleDiskless
+			h.Diskless = append(h.Diskless, rsc.NodeName)
+		}
+
+		for _, vol := range rsc.Volumes {
+			replica.DiskStates[vol.VolumeNumber] = vol.State.DiskState
+		}
+
+		if rsc.State != nil && rsc.State.InUse != nil {
+			replica.InUse = *rsc.State.InUse
+		}
+
+		if drbd := drbdLayer(rsc.LayerObject); drbd != nil {
+			h.Connections[rsc.NodeName] = drbd.Connections
+		}
+
+		h.Replicas = append(h.Replicas, replica)
+	}
+
+	sort.Slice(h.Replicas, func(i, j int) bool { return h.Replicas[i].Node < h.Replicas[j].Node })
+	sort.Strings(h.Diskless)
+	sort.Strings(h.TieBreakers)
+
+	h.classify()
+
+	return h
+}
+
+func (h *ReplicaHealth) classify() {
+	total := len(h.Replicas)
+	var inUse, resyncing []string
+	var disconnected, standAlone, notUpToDate []string
+	anyQuorum := false
+
+	for i := range h.Replicas {
+		r := &h.Replicas[i]
+
+		votes := 1
+		for _, peer := range h.Replicas {
+			if peer.Node == r.Node {
+				continue
+			}
+
+			conn, ok := h.Connections[r.Node][peer.Node]
+			switch {
+			case ok && conn.Connected:
+				votes++
+			case ok && strings.Contains(conn.Message, "StandAlone"):
+				standAlone = append(standAlone, r.Node+"->"+peer.Node)
+			default:
+				disconnected = append(disconnected, r.Node+"->"+peer.Node)
+			}
+		}
+
+		r.Quorum = 2*votes > total
+		anyQuorum = anyQuorum || r.Quorum
+
+		if r.InUse {
+			inUse = append(inUse, r.Node)
+		}
+
+		switch {
+		case r.UpToDate():
+			h.UpToDate++
+		case r.Resyncing():
+			resyncing = append(resyncing, r.Node)
+		case r.Role == RoleDiskful:
+			notUpToDate = append(notUpToDate, r.Node)
+		}
+	}
+
+	switch {
+	case total == 0:
+		h.State = StateDegraded
+		h.Reasons = append(h.Reasons, "no replicas")
+		return
+	case total > 1 && !anyQuorum:
+		h.State = StateQuorumLost
+		h.Reasons = append(h.Reasons, "no replica is connected to a majority of the replicas")
+	case len(standAlone) > 0 || len(inUse) > 1:
+		h.State = StateSplitBrainSuspected
+		if len(standAlone) > 0 {
+			h.Reasons = append(h.Reasons, "stand-alone connections: "+strings.Join(standAlone, ", "))
+		}
+		if len(inUse) > 1 {
+			h.Reasons = append(h.Reasons, "in use on more than one node: "+strings.Join(inUse, ", "))
+		}
+		return
+	case len(disconnected) > 0 || len(notUpToDate) > 0 || h.UpToDate+len(resyncing) < h.PlaceCount:
+		h.State = StateDegraded
+	case len(resyncing) > 0:
+		h.State = StateResyncing
+	default:
+		h.State = StateHealthy
+		return
+	}
+
+	if len(disconnected) > 0 {
+		h.Reasons = append(h.Reasons, "disconnected: "+strings.Join(disconnected, ", "))
+	}
+	if len(notUpToDate) > 0 {
+		h.Reasons = append(h.Reasons, "not up to date: "+strings.Join(notUpToDate, ", "))
+	}
+	if len(resyncing) > 0 {
+		h.Reasons = append(h.Reasons, "resyncing: "+strings.Join(resyncing, ", "))
+	}
+	if h.UpToDate+len(resyncing) < h.PlaceCount {
+		h.Reasons = append(h.Reasons, fmt.Sprintf("%d of %d replicas up to date", h.UpToDate, h.PlaceCount))
+	}
+}
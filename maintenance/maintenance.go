@@ -0,0 +1,416 @@
+// Package maintenance takes LINSTOR satellites out of service and brings them back.
+//
+// The workflow has three steps:
+//   - Cordon stops the autoplacer from placing new resources on the node.
+//   - Drain evacuates the node and waits until no diskful resources are left on it.
+//   - Uncordon cancels a running evacuation and makes the node available again.
+//
+// The current phase is stored in the node's properties, so an interrupted Drain can be resumed by calling it again,
+// even from another process.
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/health"
+)
+
+const (
+	// KeyPhase is the node property storing the maintenance phase.
+	KeyPhase = linstor.NamespcAuxiliary + "/Maintenance/Phase"
+	// KeyAutoplaceTarget is the node property storing the value of AutoplaceTarget before the node was cordoned.
+	KeyAutoplaceTarget = linstor.NamespcAuxiliary + "/Maintenance/" + linstor.KeyAutoplaceAllowTarget
+
+	// noPreviousValue is stored in KeyAutoplaceTarget if AutoplaceTarget was not set before.
+	noPreviousValue = "-"
+)
+
+// Phase of a node's maintenance.
+type Phase string
+
+const (
+	// PhaseNone means the node is not in maintenance.
+	PhaseNone     Phase = ""
+	PhaseCordoned Phase = "cordoned"
+	PhaseDraining Phase = "draining"
+	PhaseDrained  Phase = "drained"
+)
+
+// Progress is reported while a node is drained.
+type Progress struct {
+	Node  string
+	Phase Phase
+	// Remaining are the diskful resources still on the node.
+	Remaining []string
+}
+
+// UnsafeError is returned by Drain if the safety checks fail.
+type UnsafeError struct {
+	Node     string
+	Problems []string
+}
+
+func (e *UnsafeError) Error() string {
+	return fmt.Sprintf("draining node '%s' is unsafe: %s", e.Node, strings.Join(e.Problems, "; "))
+}
+
+// DrainOpts control Drain.
+type DrainOpts struct {
+	// Target and DoNotTarget restrict the nodes that receive the evacuated resources, see client.NodeEvacuate.
+	Target      []string
+	DoNotTarget []string
+	// Timeout for the whole drain. When it expires, the node stays in PhaseDraining and Drain can be called again
+	// to resume waiting. 0 means no timeout.
+	Timeout time.Duration
+	// Force skips the safety checks.
+	Force bool
+}
+
+// Maintenance orchestrates node maintenance.
+type Maintenance struct {
+	Nodes               client.NodeProvider
+	Resources           client.ResourceProvider
+	ResourceDefinitions client.ResourceDefinitionProvider
+	ResourceGroups      client.ResourceGroupProvider
+	// Progress is called after every poll during Drain. Optional.
+	Progress func(Progress)
+	// Interval between polls during Drain. Defaults to 5 seconds.
+	Interval time.Duration
+}
+
+// New returns a Maintenance using the services of the given client.
+func New(c *client.Client) *Maintenance {
+	return &Maintenance{
+		Nodes:               c.Nodes,
+		Resources:           c.Resources,
+		ResourceDefinitions: c.ResourceDefinitions,
+		ResourceGroups:      c.ResourceGroups,
+	}
+}
+
+// Phase returns the maintenance phase of the node.
+func (m *Maintenance) Phase(ctx context.Context, nodeName string) (Phase, error) {
+	node, err := m.Nodes.Get(ctx, nodeName)
+	if err != nil {
+		return PhaseNone, err
+	}
+
+	return Phase(node.Props[KeyPhase]), nil
+}
+
+func (m *Maintenance) setPhase(ctx context.Context, nodeName string, phase Phase) error {
+	return m.Nodes.Modify(ctx, nodeName, client.NodeModify{GenericPropsModify: client.GenericPropsModify{
+		OverrideProps: map[string]string{KeyPhase: string(phase)},
+	}})
+}
+
+// Cordon disables autoplacement on the node. It does nothing if the node is already in maintenance.
+func (m *Maintenance) Cordon(ctx context.Context, nodeName string) error {
+	node, err := m.Nodes.Get(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	if Phase(node.Props[KeyPhase]) != PhaseNone {
+		return nil
+	}
+
+	previous, ok := node.Props[linstor.KeyAutoplaceAllowTarget]
+	if !ok {
+		previous = noPreviousValue
+	}
+
+	return m.Nodes.Modify(ctx, nodeName, client.NodeModify{GenericPropsModify: client.GenericPropsModify{
+		OverrideProps: map[string]string{
+			linstor.KeyAutoplaceAllowTarget: "false",
+			KeyAutoplaceTarget:              previous,
+			KeyPhase:                        string(PhaseCordoned),
+		},
+	}})
+}
+
+// Drain cordons the node, checks that it can be drained safely, evacuates it and waits until no diskful resources
+// are left. If the node is already draining, the checks and the evacuation are skipped and Drain only waits.
+func (m *Maintenance) Drain(ctx context.Context, nodeName string, opts DrainOpts) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if err := m.Cordon(ctx, nodeName); err != nil {
+		return fmt.Errorf("failed to cordon node '%s': %w", nodeName, err)
+	}
+
+	node, err := m.Nodes.Get(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	phase := Phase(node.Props[KeyPhase])
+	if phase == PhaseDrained {
+		return nil
+	}
+
+	if phase != PhaseDraining || !slices.Contains(node.Flags, linstor.FlagEvacuate) {
+		if !opts.Force {
+			problems, err := m.Check(ctx, nodeName, opts)
+			if err != nil {
+				return err
+			}
+
+			if len(problems) > 0 {
+				return &UnsafeError{Node: nodeName, Problems: problems}
+			}
+		}
+
+		if err := m.setPhase(ctx, nodeName, PhaseDraining); err != nil {
+			return err
+		}
+
+		err := m.Nodes.Evacuate(ctx, nodeName, client.NodeEvacuate{Target: opts.Target, DoNotTarget: opts.DoNotTarget})
+		if err != nil {
+			return fmt.Errorf("failed to evacuate node '%s': %w", nodeName, err)
+		}
+	}
+
+	if err := m.wait(ctx, nodeName); err != nil {
+		return err
+	}
+
+	return m.setPhase(ctx, nodeName, PhaseDrained)
+}
+
+func (m *Maintenance) wait(ctx context.Context, nodeName string) error {
+	interval := m.Interval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		remaining, err := m.diskfulResources(ctx, nodeName)
+		if err != nil {
+			return err
+		}
+
+		if m.Progress != nil {
+			m.Progress(Progress{Node: nodeName, Phase: PhaseDraining, Remaining: remaining})
+		}
+
+		if len(remaining) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("node '%s' still has %d diskful resources: %w", nodeName, len(remaining), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Maintenance) diskfulResources(ctx context.Context, nodeName string) ([]string, error) {
+	rscs, err := m.Resources.GetResourceView(ctx, &client.ListOpts{Node: []string{nodeName}})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, rsc := range rscs {
+		if rsc.NodeName == nodeName && rsc.Diskful() {
+			names = append(names, rsc.Name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Abort cancels a running evacuation. The node stays cordoned, resources that were already moved are not moved
+// back.
+func (m *Maintenance) Abort(ctx context.Context, nodeName string) error {
+	node, err := m.Nodes.Get(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(node.Flags, linstor.FlagEvacuate) {
+		if err := m.Nodes.Restore(ctx, nodeName, client.NodeRestore{}); err != nil {
+			return fmt.Errorf("failed to cancel evacuation of node '%s': %w", nodeName, err)
+		}
+	}
+
+	if Phase(node.Props[KeyPhase]) == PhaseNone {
+		return nil
+	}
+
+	return m.setPhase(ctx, nodeName, PhaseCordoned)
+}
+
+// Uncordon ends the maintenance: it cancels a running evacuation, restores the previous AutoplaceTarget value and
+// removes the maintenance properties.
+func (m *Maintenance) Uncordon(ctx context.Context, nodeName string) error {
+	node, err := m.Nodes.Get(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(node.Flags, linstor.FlagEvacuate) || slices.Contains(node.Flags, linstor.FlagEvicted) {
+		if err := m.Nodes.Restore(ctx, nodeName, client.NodeRestore{}); err != nil {
+			return fmt.Errorf("failed to restore node '%s': %w", nodeName, err)
+		}
+	}
+
+	previous, ok := node.Props[KeyAutoplaceTarget]
+	if !ok && Phase(node.Props[KeyPhase]) == PhaseNone {
+		return nil
+	}
+
+	modify := client.GenericPropsModify{DeleteProps: []string{KeyPhase, KeyAutoplaceTarget}}
+	switch previous {
+	case "", noPreviousValue:
+		modify.DeleteProps = append(modify.DeleteProps, linstor.KeyAutoplaceAllowTarget)
+	default:
+		modify.OverrideProps = map[string]string{linstor.KeyAutoplaceAllowTarget: previous}
+	}
+
+	return m.Nodes.Modify(ctx, nodeName, client.NodeModify{GenericPropsModify: modify})
+}
+
+// Check returns the reasons why draining the node is unsafe:
+//   - a resource on the node would lose quorum while its replica is moved,
+//   - a resource would drop below its replica count, because no other node can receive the replica.
+//
+// The replica count is the PlaceCount of the resource group, or the current number of diskful replicas if the
+// resource group does not set it.
+func (m *Maintenance) Check(ctx context.Context, nodeName string, opts DrainOpts) ([]string, error) {
+	nodes, err := m.Nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	rscs, err := m.Resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+
+	placeCounts, err := m.placeCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	online := make(map[string]bool, len(nodes))
+	var targets []string
+	for _, node := range nodes {
+		online[node.Name] = node.ConnectionStatus == "ONLINE"
+		if node.Name != nodeName && isTarget(node, opts) {
+			targets = append(targets, node.Name)
+		}
+	}
+
+	var onNode []string
+	for _, rsc := range rscs {
+		if rsc.NodeName == nodeName && rsc.Diskful() {
+			onNode = append(onNode, rsc.Name)
+		}
+	}
+	sort.Strings(onNode)
+
+	var problems []string
+	for _, name := range onNode {
+		h := health.AnalyzeReplicas(name, placeCounts[name], rscs)
+
+		total, reachable, upToDate, diskfulReplicas := len(h.Replicas), 0, 0, 0
+		hasReplica := make(map[string]bool, total)
+		for _, r := range h.Replicas {
+			hasReplica[r.Node] = true
+			if r.Role == health.RoleDiskful {
+				diskfulReplicas++
+			}
+
+			if r.Node == nodeName || !online[r.Node] {
+				continue
+			}
+
+			if r.UpToDate() {
+				upToDate++
+			}
+
+			if r.UpToDate() || r.Role != health.RoleDiskful {
+				reachable++
+			}
+		}
+
+		if total > 1 && 2*reachable <= total {
+			problems = append(problems, fmt.Sprintf("resource '%s' would lose quorum: %d of %d replicas reachable without '%s'", name, reachable, total, nodeName))
+		}
+
+		want := h.PlaceCount
+		if want == 0 {
+			want = diskfulReplicas
+		}
+
+		free := 0
+		for _, target := range targets {
+			if !hasReplica[target] {
+				free++
+			}
+		}
+
+		if free == 0 && upToDate < want {
+			problems = append(problems, fmt.Sprintf("resource '%s' would drop to %d of %d replicas: no node available to receive it", name, upToDate, want))
+		}
+	}
+
+	return problems, nil
+}
+
+func (m *Maintenance) placeCounts(ctx context.Context) (map[string]int, error) {
+	rgs, err := m.ResourceGroups.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource groups: %w", err)
+	}
+
+	byGroup := make(map[string]int, len(rgs))
+	for _, rg := range rgs {
+		byGroup[rg.Name] = int(rg.SelectFilter.PlaceCount)
+	}
+
+	rds, err := m.ResourceDefinitions.GetAll(ctx, client.RDGetAllRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource definitions: %w", err)
+	}
+
+	counts := make(map[string]int, len(rds))
+	for _, rd := range rds {
+		counts[rd.Name] = byGroup[rd.ResourceGroupName]
+	}
+
+	return counts, nil
+}
+
+// isTarget returns true if evacuated resources may be placed on the node.
+func isTarget(node client.Node, opts DrainOpts) bool {
+	switch {
+	case node.ConnectionStatus != "ONLINE",
+		slices.Contains(node.Flags, linstor.FlagEvacuate),
+		slices.Contains(node.Flags, linstor.FlagEvicted),
+		strings.EqualFold(node.Props[linstor.KeyAutoplaceAllowTarget], "false"),
+		len(opts.Target) > 0 && !slices.Contains(opts.Target, node.Name),
+		slices.Contains(opts.DoNotTarget, node.Name):
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,256 @@
+package maintenance_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/devicelayerkind"
+	"github.com/LINBIT/golinstor/maintenance"
+)
+
+type cluster struct {
+	nodes     map[string]*client.Node
+	resources []client.ResourceWithVolumes
+	// drainAfter is the number of polls after the evacuation until the resources are moved.
+	drainAfter int
+	polls      int
+	evacuated  []client.NodeEvacuate
+	restored   int
+}
+
+type fakeNodes struct {
+	client.NodeProvider
+	c *cluster
+}
+
+func (f *fakeNodes) Get(_ context.Context, name string, _ ...*client.ListOpts) (client.Node, error) {
+	node, ok := f.c.nodes[name]
+	if !ok {
+		return client.Node{}, client.NotFoundError
+	}
+	return *node, nil
+}
+
+func (f *fakeNodes) GetAll(context.Context, ...*client.ListOpts) ([]client.Node, error) {
+	var nodes []client.Node
+	for _, name := range []string{"n1", "n2", "n3", "n4"} {
+		if node, ok := f.c.nodes[name]; ok {
+			nodes = append(nodes, *node)
+		}
+	}
+	return nodes, nil
+}
+
+func (f *fakeNodes) Modify(_ context.Context, name string, modify client.NodeModify) error {
+	node := f.c.nodes[name]
+	props := make(map[string]string)
+	for k, v := range node.Props {
+		props[k] = v
+	}
+	for k, v := range modify.OverrideProps {
+		props[k] = v
+	}
+	for _, k := range modify.DeleteProps {
+		delete(props, k)
+	}
+	node.Props = props
+	return nil
+}
+
+func (f *fakeNodes) Evacuate(_ context.Context, name string, evacuate client.NodeEvacuate) error {
+	f.c.evacuated = append(f.c.evacuated, evacuate)
+	f.c.nodes[name].Flags = []string{linstor.FlagEvacuate}
+	return nil
+}
+
+func (f *fakeNodes) Restore(_ context.Context, name string, _ client.NodeRestore) error {
+	f.c.restored++
+	f.c.nodes[name].Flags = nil
+	return nil
+}
+
+type fakeResources struct {
+	client.ResourceProvider
+	c *cluster
+}
+
+func (f *fakeResources) GetResourceView(_ context.Context, opts ...*client.ListOpts) ([]client.ResourceWithVolumes, error) {
+	if len(opts) == 0 {
+		return f.c.resources, nil
+	}
+
+	f.c.polls++
+	if f.c.polls <= f.c.drainAfter {
+		var result []client.ResourceWithVolumes
+		for _, rsc := range f.c.resources {
+			if rsc.NodeName == opts[0].Node[0] {
+				result = append(result, rsc)
+			}
+		}
+		return result, nil
+	}
+
+	return nil, nil
+}
+
+type fakeRDs struct {
+	client.ResourceDefinitionProvider
+}
+
+func (f *fakeRDs) GetAll(context.Context, client.RDGetAllRequest) ([]client.ResourceDefinitionWithVolumeDefinition, error) {
+	return []client.ResourceDefinitionWithVolumeDefinition{
+		{ResourceDefinition: client.ResourceDefinition{Name: "r1", ResourceGroupName: "rg"}},
+	}, nil
+}
+
+type fakeRGs struct {
+	client.ResourceGroupProvider
+}
+
+func (f *fakeRGs) GetAll(context.Context, ...*client.ListOpts) ([]client.ResourceGroup, error) {
+	return []client.ResourceGroup{{Name: "rg", SelectFilter: client.AutoSelectFilter{PlaceCount: 2}}}, nil
+}
+
+func replica(node string, flags ...string) client.ResourceWithVolumes {
+	conns := map[string]client.DrbdConnection{}
+	for _, peer := range []string{"n1", "n2", "n3"} {
+		if peer != node {
+			conns[peer] = client.DrbdConnection{Connected: true}
+		}
+	}
+
+	state := "UpToDate"
+	if len(flags) > 0 {
+		state = "Diskless"
+	}
+
+	return client.ResourceWithVolumes{
+		Resource: client.Resource{
+			Name:        "r1",
+			NodeName:    node,
+			Flags:       flags,
+			LayerObject: &client.ResourceLayer{Type: devicelayerkind.Drbd, Drbd: &client.DrbdResource{Connections: conns}},
+		},
+		Volumes: []client.Volume{{State: client.VolumeState{DiskState: state}}},
+	}
+}
+
+func newCluster() *cluster {
+	node := func(name string) *client.Node {
+		return &client.Node{Name: name, ConnectionStatus: "ONLINE"}
+	}
+
+	c := &cluster{
+		nodes: map[string]*client.Node{"n1": node("n1"), "n2": node("n2"), "n3": node("n3"), "n4": node("n4")},
+		resources: []client.ResourceWithVolumes{
+			replica("n1"),
+			replica("n2"),
+			replica("n3", linstor.FlagDrbdDiskless, linstor.FlagTieBreaker),
+		},
+		drainAfter: 2,
+	}
+	c.nodes["n1"].Props = map[string]string{linstor.KeyAutoplaceAllowTarget: "true"}
+
+	return c
+}
+
+func newMaintenance(c *cluster) *maintenance.Maintenance {
+	return &maintenance.Maintenance{
+		Nodes:               &fakeNodes{c: c},
+		Resources:           &fakeResources{c: c},
+		ResourceDefinitions: &fakeRDs{},
+		ResourceGroups:      &fakeRGs{},
+		Interval:            time.Millisecond,
+	}
+}
+
+func TestDrainAndUncordon(t *testing.T) {
+	c := newCluster()
+	m := newMaintenance(c)
+
+	var progress [][]string
+	m.Progress = func(p maintenance.Progress) {
+		progress = append(progress, p.Remaining)
+	}
+
+	err := m.Drain(context.Background(), "n1", maintenance.DrainOpts{DoNotTarget: []string{"n3"}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"r1"}, {"r1"}, nil}, progress)
+	assert.Equal(t, []client.NodeEvacuate{{DoNotTarget: []string{"n3"}}}, c.evacuated)
+	assert.Equal(t, "false", c.nodes["n1"].Props[linstor.KeyAutoplaceAllowTarget])
+
+	phase, err := m.Phase(context.Background(), "n1")
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PhaseDrained, phase)
+
+	// Draining again does nothing.
+	require.NoError(t, m.Drain(context.Background(), "n1", maintenance.DrainOpts{}))
+	assert.Len(t, c.evacuated, 1)
+
+	require.NoError(t, m.Uncordon(context.Background(), "n1"))
+	assert.Equal(t, 1, c.restored)
+	assert.Equal(t, map[string]string{linstor.KeyAutoplaceAllowTarget: "true"}, c.nodes["n1"].Props)
+}
+
+func TestDrainUnsafe(t *testing.T) {
+	c := newCluster()
+	m := newMaintenance(c)
+
+	// Only n2 could receive the replica, but it already has one.
+	err := m.Drain(context.Background(), "n1", maintenance.DrainOpts{Target: []string{"n2"}})
+	var unsafe *maintenance.UnsafeError
+	require.ErrorAs(t, err, &unsafe)
+	assert.Equal(t, []string{"resource 'r1' would drop to 1 of 2 replicas: no node available to receive it"}, unsafe.Problems)
+	assert.Empty(t, c.evacuated)
+
+	phase, err := m.Phase(context.Background(), "n1")
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PhaseCordoned, phase)
+
+	// The tie-breaker is offline, so moving n1 leaves 1 of 3 replicas.
+	c.nodes["n3"].ConnectionStatus = "OFFLINE"
+	problems, err := m.Check(context.Background(), "n1", maintenance.DrainOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"resource 'r1' would lose quorum: 1 of 3 replicas reachable without 'n1'"}, problems)
+
+	require.NoError(t, m.Uncordon(context.Background(), "n1"))
+	assert.Equal(t, 0, c.restored)
+	assert.Equal(t, map[string]string{linstor.KeyAutoplaceAllowTarget: "true"}, c.nodes["n1"].Props)
+}
+
+func TestDrainTimeoutResumeAndAbort(t *testing.T) {
+	c := newCluster()
+	c.drainAfter = 1000
+	m := newMaintenance(c)
+
+	err := m.Drain(context.Background(), "n2", maintenance.DrainOpts{Timeout: 10 * time.Millisecond})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+	phase, err := m.Phase(context.Background(), "n2")
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PhaseDraining, phase)
+
+	// Resuming does not evacuate again.
+	c.drainAfter = c.polls + 1
+	require.NoError(t, m.Drain(context.Background(), "n2", maintenance.DrainOpts{}))
+	assert.Len(t, c.evacuated, 1)
+
+	c.nodes["n2"].Flags = []string{linstor.FlagEvacuate}
+	require.NoError(t, m.Abort(context.Background(), "n2"))
+	assert.Equal(t, 1, c.restored)
+
+	phase, err = m.Phase(context.Background(), "n2")
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PhaseCordoned, phase)
+	assert.Equal(t, "false", c.nodes["n2"].Props[linstor.KeyAutoplaceAllowTarget])
+
+	require.NoError(t, m.Uncordon(context.Background(), "n2"))
+	assert.Empty(t, c.nodes["n2"].Props)
+}
@@ -0,0 +1,314 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+)
+
+// Status is the state of a move, as stored in the checkpoint.
+type Status string
+
+const (
+	StatusPending Status = ""
+	// StatusStarted is set once the target replica is created. The move is complete when the target is up to date
+	// and LINSTOR removed the source replica.
+	StatusStarted Status = "started"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Checkpoint is the stored progress of the move of one resource.
+type Checkpoint struct {
+	Status Status
+	// ToNode and ToPool are the target of a started move. They take precedence over the plan on resume, so that a
+	// recomputed plan does not start a second move of the same resource.
+	ToNode string
+	ToPool string
+	Error  string
+}
+
+// Event reports the progress of a move.
+type Event struct {
+	Move   Move
+	Status Status
+	// Err is set if Status is StatusFailed.
+	Err error
+}
+
+// Executor runs a Plan.
+type Executor struct {
+	Resources client.ResourceProvider
+	KV        client.KeyValueStoreProvider
+	// Parallel is the maximum number of moves, and thus resyncs, at once. Defaults to 1.
+	Parallel int
+	// Progress is called whenever a move changes its status. Calls are serialized. Optional.
+	Progress func(Event)
+	// Interval between polls while waiting for a move. Defaults to 5 seconds.
+	Interval time.Duration
+
+	mu sync.Mutex
+}
+
+// NewExecutor returns an Executor using the services of the given client.
+func NewExecutor(c *client.Client) *Executor {
+	return &Executor{
+		Resources: c.Resources,
+		KV:        c.KeyValueStore,
+	}
+}
+
+// CheckpointName returns the name of the key-value store instance holding the checkpoints of the plan.
+func CheckpointName(plan *Plan) string {
+	return "migration-" + plan.Node + "-" + plan.StoragePool
+}
+
+func checkpointKey(resource, field string) string {
+	return "Migration/" + resource + "/" + field
+}
+
+// Checkpoints returns the stored checkpoints of the plan, by resource name.
+func (e *Executor) Checkpoints(ctx context.Context, plan *Plan) (map[string]Checkpoint, error) {
+	kvs, err := e.KV.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list key-value stores: %w", err)
+	}
+
+	checkpoints := make(map[string]Checkpoint)
+	for _, kv := range kvs {
+		if kv.Name != CheckpointName(plan) {
+			continue
+		}
+
+		for _, move := range plan.Moves {
+			status, ok := kv.Props[checkpointKey(move.Resource, "Status")]
+			if !ok {
+				continue
+			}
+
+			checkpoints[move.Resource] = Checkpoint{
+				Status: Status(status),
+				ToNode: kv.Props[checkpointKey(move.Resource, "ToNode")],
+				ToPool: kv.Props[checkpointKey(move.Resource, "ToPool")],
+				Error:  kv.Props[checkpointKey(move.Resource, "Error")],
+			}
+		}
+	}
+
+	return checkpoints, nil
+}
+
+// Reset deletes the checkpoints of the plan.
+func (e *Executor) Reset(ctx context.Context, plan *Plan) error {
+	err := e.KV.Delete(ctx, CheckpointName(plan))
+	if err != nil && !errors.Is(err, client.NotFoundError) {
+		return fmt.Errorf("failed to delete checkpoints: %w", err)
+	}
+
+	return nil
+}
+
+// Execute runs the moves of the plan in order, at most Parallel at once.
+//
+// Every move creates a diskless replica on the target node if needed, migrates the disk to the target pool and
+// waits until the target is up to date and the source replica is removed. Progress is stored in the key-value
+// store, see CheckpointName. Calling Execute again, with the same or a recomputed plan, skips completed moves,
+// waits for started ones and retries failed ones. When all moves are done, the checkpoints are deleted.
+//
+// A failed move does not stop the others. All errors are returned, joined into one error.
+func (e *Executor) Execute(ctx context.Context, plan *Plan) error {
+	checkpoints, err := e.Checkpoints(ctx, plan)
+	if err != nil {
+		return err
+	}
+
+	parallel := e.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	var errs []error
+	var errsMu sync.Mutex
+
+	for _, move := range plan.Moves {
+		cp := checkpoints[move.Resource]
+		if cp.Status == StatusDone {
+			e.report(Event{Move: move, Status: StatusDone})
+			continue
+		}
+
+		if cp.Status == StatusStarted {
+			move.ToNode, move.ToPool = cp.ToNode, cp.ToPool
+		}
+
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+
+		if ctx.Err() != nil {
+			errsMu.Lock()
+			errs = append(errs, ctx.Err())
+			errsMu.Unlock()
+			break
+		}
+
+		wg.Add(1)
+		go func(move Move) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := e.run(ctx, plan, move); err != nil {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("failed to move '%s': %w", move.Resource, err))
+				errsMu.Unlock()
+			}
+		}(move)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return e.Reset(ctx, plan)
+}
+
+func (e *Executor) run(ctx context.Context, plan *Plan, move Move) error {
+	err := e.start(ctx, plan, move)
+	if err == nil {
+		e.report(Event{Move: move, Status: StatusStarted})
+		err = e.wait(ctx, move)
+	}
+
+	if err != nil {
+		if ctx.Err() == nil {
+			_ = e.checkpoint(ctx, plan, move, StatusFailed, err.Error())
+		}
+		e.report(Event{Move: move, Status: StatusFailed, Err: err})
+		return err
+	}
+
+	if err := e.checkpoint(ctx, plan, move, StatusDone, ""); err != nil {
+		return err
+	}
+
+	e.report(Event{Move: move, Status: StatusDone})
+
+	return nil
+}
+
+// start creates the diskless target replica, records the move as started and migrates the disk. The checkpoint is
+// written before the migration, so a resumed move issues the migration again as long as the target is still
+// diskless.
+func (e *Executor) start(ctx context.Context, plan *Plan, move Move) error {
+	rsc, err := e.Resources.Get(ctx, move.Resource, move.ToNode)
+	switch {
+	case errors.Is(err, client.NotFoundError):
+		err = e.Resources.Create(ctx, client.ResourceCreate{Resource: client.Resource{
+			Name:     move.Resource,
+			NodeName: move.ToNode,
+			Flags:    []string{linstor.FlagDiskless},
+		}})
+		if err != nil {
+			return fmt.Errorf("failed to create diskless resource on '%s': %w", move.ToNode, err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to get resource on '%s': %w", move.ToNode, err)
+	case rsc.Diskful():
+		// Already migrated, or the target received a replica by other means. Either way, wait for it.
+		return e.checkpoint(ctx, plan, move, StatusStarted, "")
+	}
+
+	if err := e.checkpoint(ctx, plan, move, StatusStarted, ""); err != nil {
+		return err
+	}
+
+	err = e.Resources.Migrate(ctx, move.Resource, move.FromNode, move.ToNode, move.ToPool)
+	if err != nil {
+		return fmt.Errorf("failed to migrate to '%s': %w", move.ToNode, err)
+	}
+
+	return nil
+}
+
+func (e *Executor) wait(ctx context.Context, move Move) error {
+	interval := e.Interval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		rscs, err := e.Resources.GetResourceView(ctx, &client.ListOpts{Resource: []string{move.Resource}})
+		if err != nil {
+			return err
+		}
+
+		sourceGone, targetUpToDate := true, false
+		for _, rsc := range rscs {
+			if rsc.Name != move.Resource {
+				continue
+			}
+
+			switch rsc.NodeName {
+			case move.FromNode:
+				sourceGone = false
+			case move.ToNode:
+				targetUpToDate = rsc.Diskful() && client.VolumesUpToDate(rsc.Volumes)
+			}
+		}
+
+		if sourceGone && targetUpToDate {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for '%s' to be up to date on '%s': %w", move.Resource, move.ToNode, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Executor) checkpoint(ctx context.Context, plan *Plan, move Move, status Status, msg string) error {
+	modify := client.GenericPropsModify{OverrideProps: map[string]string{
+		checkpointKey(move.Resource, "Status"): string(status),
+		checkpointKey(move.Resource, "ToNode"): move.ToNode,
+		checkpointKey(move.Resource, "ToPool"): move.ToPool,
+	}}
+
+	if msg != "" {
+		modify.OverrideProps[checkpointKey(move.Resource, "Error")] = msg
+	} else {
+		modify.DeleteProps = []string{checkpointKey(move.Resource, "Error")}
+	}
+
+	err := e.KV.CreateOrModify(ctx, CheckpointName(plan), modify)
+	if err != nil {
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+func (e *Executor) report(ev Event) {
+	if e.Progress == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Progress(ev)
+}
@@ -0,0 +1,293 @@
+package migration_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/migration"
+)
+
+type cluster struct {
+	mu        sync.Mutex
+	resources map[string]*client.ResourceWithVolumes
+	kv        map[string]map[string]string
+	migrated  []string
+	failOn    string
+}
+
+func key(res, node string) string {
+	return res + "@" + node
+}
+
+func (c *cluster) add(res, node string, sizeKib int64) {
+	c.resources[key(res, node)] = &client.ResourceWithVolumes{
+		Resource: client.Resource{Name: res, NodeName: node},
+		Volumes: []client.Volume{{
+			StoragePoolName: "data",
+			UsableSizeKib:   sizeKib,
+			State:           client.VolumeState{DiskState: "UpToDate"},
+		}},
+	}
+}
+
+type fakeNodes struct {
+	client.NodeProvider
+}
+
+func (f *fakeNodes) GetAll(context.Context, ...*client.ListOpts) ([]client.Node, error) {
+	node := func(name, site string) client.Node {
+		return client.Node{Name: name, ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/site": site}}
+	}
+
+	return []client.Node{node("n1", "a"), node("n2", "b"), node("n3", "c"), node("n4", "c")}, nil
+}
+
+func (f *fakeNodes) GetStoragePoolView(context.Context, ...*client.ListOpts) ([]client.StoragePool, error) {
+	pool := func(node string, free int64) client.StoragePool {
+		return client.StoragePool{StoragePoolName: "data", NodeName: node, ProviderKind: client.LVM, FreeCapacity: free}
+	}
+
+	return []client.StoragePool{
+		pool("n1", 0),
+		pool("n2", 1000),
+		pool("n3", 60),
+		pool("n4", 500),
+		{StoragePoolName: "DfltDisklessStorPool", NodeName: "n2", ProviderKind: client.DISKLESS},
+	}, nil
+}
+
+type fakeResources struct {
+	client.ResourceProvider
+	c *cluster
+}
+
+func (f *fakeResources) GetResourceView(context.Context, ...*client.ListOpts) ([]client.ResourceWithVolumes, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	var result []client.ResourceWithVolumes
+	for _, rsc := range f.c.resources {
+		result = append(result, *rsc)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return key(result[i].Name, result[i].NodeName) < key(result[j].Name, result[j].NodeName)
+	})
+
+	return result, nil
+}
+
+func (f *fakeResources) Get(_ context.Context, res, node string, _ ...*client.ListOpts) (client.Resource, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	rsc, ok := f.c.resources[key(res, node)]
+	if !ok {
+		return client.Resource{}, client.NotFoundError
+	}
+
+	return rsc.Resource, nil
+}
+
+func (f *fakeResources) Create(_ context.Context, create client.ResourceCreate) error {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	f.c.resources[key(create.Resource.Name, create.Resource.NodeName)] = &client.ResourceWithVolumes{Resource: create.Resource}
+
+	return nil
+}
+
+func (f *fakeResources) Migrate(_ context.Context, res, from, to, pool string) error {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	if res == f.c.failOn {
+		return errors.New("migration refused")
+	}
+
+	source := f.c.resources[key(res, from)]
+	target := f.c.resources[key(res, to)]
+	target.Flags = nil
+	target.Volumes = []client.Volume{{
+		StoragePoolName: pool,
+		UsableSizeKib:   source.Volumes[0].UsableSizeKib,
+		State:           client.VolumeState{DiskState: "UpToDate"},
+	}}
+	delete(f.c.resources, key(res, from))
+	f.c.migrated = append(f.c.migrated, res)
+
+	return nil
+}
+
+type fakeRDs struct {
+	client.ResourceDefinitionProvider
+}
+
+func (f *fakeRDs) GetAll(context.Context, client.RDGetAllRequest) ([]client.ResourceDefinitionWithVolumeDefinition, error) {
+	return []client.ResourceDefinitionWithVolumeDefinition{
+		{ResourceDefinition: client.ResourceDefinition{Name: "r1", ResourceGroupName: "rg"}},
+		{ResourceDefinition: client.ResourceDefinition{Name: "r2", ResourceGroupName: "rg"}},
+		{ResourceDefinition: client.ResourceDefinition{Name: "r3", ResourceGroupName: "rg"}},
+	}, nil
+}
+
+type fakeRGs struct {
+	client.ResourceGroupProvider
+}
+
+func (f *fakeRGs) GetAll(context.Context, ...*client.ListOpts) ([]client.ResourceGroup, error) {
+	return []client.ResourceGroup{{
+		Name:         "rg",
+		SelectFilter: client.AutoSelectFilter{PlaceCount: 2, ReplicasOnDifferent: []string{"Aux/site"}},
+	}}, nil
+}
+
+type fakeKV struct {
+	client.KeyValueStoreProvider
+	c *cluster
+}
+
+func (f *fakeKV) List(context.Context) ([]client.KV, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	var kvs []client.KV
+	for name, props := range f.c.kv {
+		copied := make(map[string]string, len(props))
+		for k, v := range props {
+			copied[k] = v
+		}
+		kvs = append(kvs, client.KV{Name: name, Props: copied})
+	}
+
+	return kvs, nil
+}
+
+func (f *fakeKV) CreateOrModify(_ context.Context, name string, modify client.GenericPropsModify) error {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	if f.c.kv[name] == nil {
+		f.c.kv[name] = make(map[string]string)
+	}
+	for k, v := range modify.OverrideProps {
+		f.c.kv[name][k] = v
+	}
+	for _, k := range modify.DeleteProps {
+		delete(f.c.kv[name], k)
+	}
+
+	return nil
+}
+
+func (f *fakeKV) Delete(_ context.Context, name string) error {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	if _, ok := f.c.kv[name]; !ok {
+		return client.NotFoundError
+	}
+	delete(f.c.kv, name)
+
+	return nil
+}
+
+func newCluster() *cluster {
+	c := &cluster{resources: make(map[string]*client.ResourceWithVolumes), kv: make(map[string]map[string]string)}
+	c.add("r1", "n1", 100)
+	c.add("r1", "n2", 100)
+	c.add("r2", "n1", 50)
+	c.add("r2", "n4", 50)
+	c.add("r3", "n1", 2000)
+	c.resources[key("r2", "n2")] = &client.ResourceWithVolumes{
+		Resource: client.Resource{Name: "r2", NodeName: "n2", Flags: []string{linstor.FlagDiskless}},
+	}
+
+	return c
+}
+
+func newSource(c *cluster) *migration.Source {
+	return &migration.Source{
+		Nodes:               &fakeNodes{},
+		Resources:           &fakeResources{c: c},
+		ResourceDefinitions: &fakeRDs{},
+		ResourceGroups:      &fakeRGs{},
+	}
+}
+
+func TestPlanDrain(t *testing.T) {
+	c := newCluster()
+
+	plan, err := migration.PlanDrain(context.Background(), newSource(c), "n1", "data", migration.PlanOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, []migration.Move{
+		// n3 has too little space left, n2 already has a replica.
+		{Resource: "r1", FromNode: "n1", FromPool: "data", ToNode: "n4", ToPool: "data", SizeKib: 100},
+		// n3 is on the same site as the replica on n4, the diskless replica on n2 does not count.
+		{Resource: "r2", FromNode: "n1", FromPool: "data", ToNode: "n2", ToPool: "data", SizeKib: 50},
+	}, plan.Moves)
+	assert.Equal(t, []migration.Unplaceable{{Resource: "r3", Reason: "no target with 2000 KiB free"}}, plan.Unplaceable)
+	assert.False(t, plan.Complete())
+
+	plan, err = migration.PlanDrain(context.Background(), newSource(c), "n1", "data", migration.PlanOpts{DoNotTarget: []string{"n2"}})
+	require.NoError(t, err)
+	assert.Equal(t, []migration.Unplaceable{
+		{Resource: "r3", Reason: "no target with 2000 KiB free"},
+		{Resource: "r2", Reason: "no target satisfies the replica constraints of the resource group"},
+	}, plan.Unplaceable)
+
+	_, err = migration.PlanDrain(context.Background(), newSource(c), "n1", "other", migration.PlanOpts{})
+	assert.True(t, errors.Is(err, client.NotFoundError), err)
+}
+
+func TestExecute(t *testing.T) {
+	c := newCluster()
+	delete(c.resources, key("r3", "n1"))
+
+	plan, err := migration.PlanDrain(context.Background(), newSource(c), "n1", "data", migration.PlanOpts{})
+	require.NoError(t, err)
+	require.True(t, plan.Complete())
+
+	var events []migration.Status
+	e := &migration.Executor{
+		Resources: &fakeResources{c: c},
+		KV:        &fakeKV{c: c},
+		Parallel:  2,
+		Interval:  time.Millisecond,
+		Progress: func(ev migration.Event) {
+			events = append(events, ev.Status)
+		},
+	}
+
+	c.failOn = "r2"
+	err = e.Execute(context.Background(), plan)
+	assert.ErrorContains(t, err, "failed to move 'r2'")
+
+	checkpoints, err := e.Checkpoints(context.Background(), plan)
+	require.NoError(t, err)
+	assert.Equal(t, migration.StatusDone, checkpoints["r1"].Status)
+	assert.Equal(t, migration.StatusFailed, checkpoints["r2"].Status)
+	assert.Equal(t, "failed to migrate to 'n2': migration refused", checkpoints["r2"].Error)
+	assert.Equal(t, []string{"r1"}, c.migrated)
+
+	// Resuming skips r1 and retries r2.
+	c.failOn = ""
+	events = nil
+	require.NoError(t, e.Execute(context.Background(), plan))
+	assert.Equal(t, []string{"r1", "r2"}, c.migrated)
+	assert.Equal(t, []migration.Status{migration.StatusDone, migration.StatusStarted, migration.StatusDone}, events)
+	assert.Empty(t, c.kv)
+
+	assert.NotContains(t, c.resources, key("r1", "n1"))
+	assert.NotContains(t, c.resources, key("r2", "n1"))
+	assert.Empty(t, c.resources[key("r2", "n2")].Flags)
+}
@@ -0,0 +1,280 @@
+// Package migration empties storage pools by moving their resources to other nodes.
+//
+// PlanDrain computes a Plan that moves every resource with a volume in the pool to a pool on another node,
+// respecting the ReplicasOnSame and ReplicasOnDifferent settings of the resource groups and the free capacity of the targets. An
+// Executor runs the plan with limited parallelism, storing checkpoints in the LINSTOR key-value store so that an
+// interrupted run can be resumed.
+package migration
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/placement"
+)
+
+// Move migrates the replica of a resource from one node to another.
+type Move struct {
+	Resource string `json:"resource"`
+	FromNode string `json:"from_node"`
+	FromPool string `json:"from_pool"`
+	ToNode   string `json:"to_node"`
+	ToPool   string `json:"to_pool"`
+	// SizeKib is the size of all volumes of the replica.
+	SizeKib int64 `json:"size_kib"`
+}
+
+func (m Move) String() string {
+	return fmt.Sprintf("%s: %s/%s -> %s/%s", m.Resource, m.FromNode, m.FromPool, m.ToNode, m.ToPool)
+}
+
+// Unplaceable is a resource for which no target was found.
+type Unplaceable struct {
+	Resource string `json:"resource"`
+	Reason   string `json:"reason"`
+}
+
+// Plan is an ordered list of moves that empties a storage pool.
+type Plan struct {
+	Node        string        `json:"node"`
+	StoragePool string        `json:"storage_pool"`
+	Moves       []Move        `json:"moves"`
+	Unplaceable []Unplaceable `json:"unplaceable,omitempty"`
+}
+
+// Complete returns true if all resources of the pool can be moved.
+func (p *Plan) Complete() bool {
+	return len(p.Unplaceable) == 0
+}
+
+// PlanOpts control PlanDrain.
+type PlanOpts struct {
+	// TargetPools restricts the storage pools that receive resources, by name. Optional.
+	TargetPools []string
+	// DoNotTarget excludes nodes from receiving resources. Optional.
+	DoNotTarget []string
+}
+
+// Source provides the cluster state used by PlanDrain.
+type Source struct {
+	Nodes               client.NodeProvider
+	Resources           client.ResourceProvider
+	ResourceDefinitions client.ResourceDefinitionProvider
+	ResourceGroups      client.ResourceGroupProvider
+}
+
+// NewSource returns a Source using the services of the given client.
+func NewSource(c *client.Client) *Source {
+	return &Source{
+		Nodes:               c.Nodes,
+		Resources:           c.Resources,
+		ResourceDefinitions: c.ResourceDefinitions,
+		ResourceGroups:      c.ResourceGroups,
+	}
+}
+
+type target struct {
+	pool client.StoragePool
+	node client.Node
+	free int64
+}
+
+// PlanDrain plans moving all resources with a volume in the storage pool poolName on nodeName to other nodes.
+//
+// Resources are planned largest first, so that large resources still find space. A target must be in a pool the
+// autoplacer may use, as checked by placement.Ineligible, on a node that does not have a diskful replica of the
+// resource yet. The target node must satisfy the replica constraints of the resource group, as checked by
+// placement.ReplicasAllowed, together with the other diskful replicas. Among the valid targets, pools with the same name as the source pool are preferred, then
+// pools with more free space.
+func PlanDrain(ctx context.Context, src *Source, nodeName, poolName string, opts PlanOpts) (*Plan, error) {
+	nodes, err := src.Nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	pools, err := src.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage pools: %w", err)
+	}
+
+	rscs, err := src.Resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+
+	filters, err := selectFilters(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	nodesByName := make(map[string]client.Node, len(nodes))
+	for _, node := range nodes {
+		nodesByName[node.Name] = node
+	}
+
+	var targets []*target
+	found := false
+	for _, sp := range pools {
+		if sp.NodeName == nodeName && sp.StoragePoolName == poolName {
+			found = true
+			continue
+		}
+
+		node, ok := nodesByName[sp.NodeName]
+		if !ok || !eligible(node, sp, nodeName, opts) {
+			continue
+		}
+
+		targets = append(targets, &target{pool: sp, node: node, free: sp.FreeCapacity})
+	}
+
+	if !found {
+		return nil, fmt.Errorf("storage pool '%s' on node '%s': %w", poolName, nodeName, client.NotFoundError)
+	}
+
+	// replicas maps resource names to the nodes with a diskful replica.
+	replicas := make(map[string][]string)
+	var moves []Move
+	for _, rsc := range rscs {
+		if !rsc.Diskful() {
+			continue
+		}
+
+		replicas[rsc.Name] = append(replicas[rsc.Name], rsc.NodeName)
+
+		if rsc.NodeName != nodeName {
+			continue
+		}
+
+		var size int64
+		inPool := false
+		for _, vol := range rsc.Volumes {
+			size += vol.UsableSizeKib
+			if vol.StoragePoolName == poolName {
+				inPool = true
+			}
+		}
+
+		if inPool {
+			moves = append(moves, Move{Resource: rsc.Name, FromNode: nodeName, FromPool: poolName, SizeKib: size})
+		}
+	}
+
+	sort.SliceStable(moves, func(i, j int) bool {
+		if moves[i].SizeKib != moves[j].SizeKib {
+			return moves[i].SizeKib > moves[j].SizeKib
+		}
+		return moves[i].Resource < moves[j].Resource
+	})
+
+	plan := &Plan{Node: nodeName, StoragePool: poolName, Moves: []Move{}}
+	for _, move := range moves {
+		t, reason := pickTarget(targets, move, replicas[move.Resource], nodesByName, filters[move.Resource])
+		if t == nil {
+			plan.Unplaceable = append(plan.Unplaceable, Unplaceable{Resource: move.Resource, Reason: reason})
+			continue
+		}
+
+		t.free -= move.SizeKib
+		replicas[move.Resource] = append(replicas[move.Resource], t.node.Name)
+		move.ToNode = t.node.Name
+		move.ToPool = t.pool.StoragePoolName
+		plan.Moves = append(plan.Moves, move)
+	}
+
+	return plan, nil
+}
+
+// selectFilters returns the select filter of the resource group of every resource definition.
+func selectFilters(ctx context.Context, src *Source) (map[string]client.AutoSelectFilter, error) {
+	rgs, err := src.ResourceGroups.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource groups: %w", err)
+	}
+
+	byGroup := make(map[string]client.AutoSelectFilter, len(rgs))
+	for _, rg := range rgs {
+		byGroup[rg.Name] = rg.SelectFilter
+	}
+
+	rds, err := src.ResourceDefinitions.GetAll(ctx, client.RDGetAllRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource definitions: %w", err)
+	}
+
+	filters := make(map[string]client.AutoSelectFilter, len(rds))
+	for _, rd := range rds {
+		filters[rd.Name] = byGroup[rd.ResourceGroupName]
+	}
+
+	return filters, nil
+}
+
+func eligible(node client.Node, sp client.StoragePool, source string, opts PlanOpts) bool {
+	switch {
+	case node.Name == source,
+		placement.Ineligible(node, sp) != "",
+		len(opts.TargetPools) > 0 && !slices.Contains(opts.TargetPools, sp.StoragePoolName),
+		slices.Contains(opts.DoNotTarget, node.Name):
+		return false
+	}
+
+	return true
+}
+
+func pickTarget(targets []*target, move Move, replicaNodes []string, nodes map[string]client.Node, filter client.AutoSelectFilter) (*target, string) {
+	var others []map[string]string
+	for _, name := range replicaNodes {
+		if name != move.FromNode {
+			others = append(others, nodes[name].Props)
+		}
+	}
+
+	var candidates []*target
+	space, conflict := false, false
+	for _, t := range targets {
+		if slices.Contains(replicaNodes, t.node.Name) {
+			continue
+		}
+
+		if !placement.ReplicasAllowed(filter, t.node.Props, others) {
+			conflict = true
+			continue
+		}
+
+		if t.free < move.SizeKib {
+			space = true
+			continue
+		}
+
+		candidates = append(candidates, t)
+	}
+
+	if len(candidates) == 0 {
+		switch {
+		case space:
+			return nil, fmt.Sprintf("no target with %d KiB free", move.SizeKib)
+		case conflict:
+			return nil, "no target satisfies the replica constraints of the resource group"
+		default:
+			return nil, "no eligible target node"
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		aSame, bSame := a.pool.StoragePoolName == move.FromPool, b.pool.StoragePoolName == move.FromPool
+		if aSame != bSame {
+			return aSame
+		}
+		if a.free != b.free {
+			return a.free > b.free
+		}
+		return a.node.Name < b.node.Name
+	})
+
+	return candidates[0], ""
+}
@@ -0,0 +1,109 @@
+package orphan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// SafeKinds are the kinds cleaned up by default. They hold no data and no configuration that is still in use.
+// Resource definitions, snapshots, storage pools and external files must be requested explicitly: an empty storage
+// pool may just not have received a resource yet, an external file may be about to be attached.
+var SafeKinds = []Kind{KindOrphanedKV, KindDanglingConnection}
+
+// CleanupOpts control Cleanup.
+type CleanupOpts struct {
+	// Kinds to clean up. Defaults to SafeKinds.
+	Kinds []Kind
+	// MinAge skips orphans that are younger, or whose age is unknown. 0 disables the check.
+	MinAge time.Duration
+	// DryRun only returns the actions that would be taken.
+	DryRun bool
+}
+
+// Cleanup scans the cluster and removes the orphans of the selected kinds. The scan is done right before the
+// cleanup, so that objects that came back into use since an earlier report are left alone.
+//
+// Resource definitions, snapshots, key-value store instances, external files and storage pools are deleted.
+// Dangling connections cannot be deleted, their properties are removed instead. The actions taken, or with DryRun
+// the actions that would be taken, are returned. Failed actions do not stop the cleanup, all errors are returned,
+// joined into one error.
+func (s *Scanner) Cleanup(ctx context.Context, opts CleanupOpts) ([]string, error) {
+	report, err := s.Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := opts.Kinds
+	if len(kinds) == 0 {
+		kinds = SafeKinds
+	}
+
+	var actions []string
+	var errs []error
+	for _, o := range report.Orphans {
+		if !slices.Contains(kinds, o.Kind) || (opts.MinAge > 0 && o.Age < opts.MinAge) {
+			continue
+		}
+
+		action, run := s.cleanupAction(o)
+		actions = append(actions, action)
+
+		if opts.DryRun {
+			continue
+		}
+
+		if err := run(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to %s: %w", action, err))
+		}
+	}
+
+	return actions, errors.Join(errs...)
+}
+
+func (s *Scanner) cleanupAction(o Orphan) (string, func(context.Context) error) {
+	switch o.Kind {
+	case KindEmptyResourceDefinition:
+		return fmt.Sprintf("delete resource definition '%s'", o.Name), func(ctx context.Context) error {
+			return s.ResourceDefinitions.Delete(ctx, o.Name)
+		}
+	case KindStaleSnapshot:
+		return fmt.Sprintf("delete snapshot '%s' of '%s'", o.Name, o.Resource), func(ctx context.Context) error {
+			return s.Resources.DeleteSnapshot(ctx, o.Resource, o.Name)
+		}
+	case KindOrphanedKV:
+		return fmt.Sprintf("delete key-value store '%s'", o.Name), func(ctx context.Context) error {
+			return s.KV.Delete(ctx, o.Name)
+		}
+	case KindUnusedExternalFile:
+		return fmt.Sprintf("delete external file '%s'", o.Name), func(ctx context.Context) error {
+			return s.Controller.DeleteExternalFile(ctx, o.Name)
+		}
+	case KindEmptyStoragePool:
+		return fmt.Sprintf("delete storage pool '%s' on '%s'", o.Name, o.Nodes[0]), func(ctx context.Context) error {
+			return s.Nodes.DeleteStoragePool(ctx, o.Nodes[0], o.Name)
+		}
+	case KindDanglingConnection:
+		return fmt.Sprintf("delete properties of connection '%s' <-> '%s' of '%s'", o.Nodes[0], o.Nodes[1], o.Resource), func(ctx context.Context) error {
+			conns, err := s.Resources.GetConnections(ctx, o.Resource, o.Nodes[0], o.Nodes[1])
+			if err != nil {
+				return err
+			}
+
+			var keys []string
+			for _, conn := range conns {
+				for k := range conn.Props {
+					keys = append(keys, k)
+				}
+			}
+
+			return s.Resources.ModifyConnection(ctx, o.Resource, o.Nodes[0], o.Nodes[1], client.GenericPropsModify{DeleteProps: keys})
+		}
+	}
+
+	return fmt.Sprintf("ignore unknown kind %s", o.Kind), func(context.Context) error { return nil }
+}
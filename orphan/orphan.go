@@ -0,0 +1,508 @@
+// Package orphan finds LINSTOR objects that are no longer used or reference objects that no longer exist, and
+// optionally cleans them up.
+package orphan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+)
+
+// KeyPrefixFiles is the prefix of the properties that attach an external file to the controller, a resource group
+// or a resource definition. The rest of the key is the path of the file, without the leading slash.
+const KeyPrefixFiles = "Files/"
+
+// Kind is the kind of an orphan.
+type Kind string
+
+const (
+	// KindEmptyResourceDefinition is a resource definition without resources or snapshots.
+	KindEmptyResourceDefinition Kind = "empty-resource-definition"
+	// KindStaleSnapshot is a snapshot whose resource definition was deleted or recreated after the snapshot.
+	KindStaleSnapshot Kind = "stale-snapshot"
+	// KindOrphanedKV is a key-value store instance that is empty or whose owner no longer exists.
+	KindOrphanedKV Kind = "orphaned-kv"
+	// KindUnusedExternalFile is an external file that is not attached anywhere.
+	KindUnusedExternalFile Kind = "unused-external-file"
+	// KindEmptyStoragePool is a storage pool without volumes.
+	KindEmptyStoragePool Kind = "empty-storage-pool"
+	// KindDanglingConnection is a resource connection with properties that references a deleted node.
+	KindDanglingConnection Kind = "dangling-connection"
+)
+
+// Orphan is an unused or inconsistent object.
+type Orphan struct {
+	Kind Kind `json:"kind"`
+	// Name of the resource definition, snapshot, key-value store instance, external file or storage pool. Empty
+	// for connections.
+	Name string `json:"name,omitempty"`
+	// Resource is the resource definition of a snapshot or connection.
+	Resource string `json:"resource,omitempty"`
+	// Nodes are the node of a storage pool, or the two nodes of a connection.
+	Nodes  []string `json:"nodes,omitempty"`
+	Reason string   `json:"reason"`
+	// Age of the object. 0 if LINSTOR does not report when it was created.
+	Age time.Duration `json:"age,omitempty"`
+	// SizeKib is the space used or reserved by the object. 0 if unknown or not applicable.
+	SizeKib int64 `json:"size_kib,omitempty"`
+}
+
+func (o Orphan) String() string {
+	switch o.Kind {
+	case KindStaleSnapshot:
+		return o.Resource + "/" + o.Name
+	case KindEmptyStoragePool:
+		return o.Name + "@" + strings.Join(o.Nodes, ",")
+	case KindDanglingConnection:
+		return o.Resource + ": " + strings.Join(o.Nodes, " <-> ")
+	}
+
+	return o.Name
+}
+
+// State is the cluster state the scan works on.
+type State struct {
+	Nodes               []client.Node
+	StoragePools        []client.StoragePool
+	Resources           []client.ResourceWithVolumes
+	ResourceDefinitions []client.ResourceDefinitionWithVolumeDefinition
+	ResourceGroups      []client.ResourceGroup
+	Snapshots           []client.Snapshot
+	KVs                 []client.KV
+	ExternalFiles       []client.ExternalFile
+	ControllerProps     map[string]string
+	// Connections are the resource connections, by resource definition.
+	Connections map[string][]client.ResourceConnection
+	// Now is the time the state was collected.
+	Now time.Time
+}
+
+// Scanner finds orphans.
+type Scanner struct {
+	Nodes               client.NodeProvider
+	Resources           client.ResourceProvider
+	ResourceDefinitions client.ResourceDefinitionProvider
+	ResourceGroups      client.ResourceGroupProvider
+	Controller          client.ControllerProvider
+	KV                  client.KeyValueStoreProvider
+	// OwnerOfKV decides whether a key-value store instance belongs to an object that no longer exists, returning
+	// the reason or "". Empty instances are always reported. Optional.
+	OwnerOfKV func(kv client.KV, s *State) string
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// New returns a Scanner using the services of the given client.
+func New(c *client.Client) *Scanner {
+	return &Scanner{
+		Nodes:               c.Nodes,
+		Resources:           c.Resources,
+		ResourceDefinitions: c.ResourceDefinitions,
+		ResourceGroups:      c.ResourceGroups,
+		Controller:          c.Controller,
+		KV:                  c.KeyValueStore,
+	}
+}
+
+// Collect fetches the cluster state.
+func (s *Scanner) Collect(ctx context.Context) (*State, error) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+
+	state := &State{Now: now(), Connections: make(map[string][]client.ResourceConnection)}
+
+	var err error
+	state.Nodes, err = s.Nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	state.StoragePools, err = s.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage pools: %w", err)
+	}
+
+	state.Resources, err = s.Resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+
+	state.ResourceDefinitions, err = s.ResourceDefinitions.GetAll(ctx, client.RDGetAllRequest{WithVolumeDefinitions: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource definitions: %w", err)
+	}
+
+	state.ResourceGroups, err = s.ResourceGroups.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource groups: %w", err)
+	}
+
+	state.Snapshots, err = s.Resources.GetSnapshotView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots: %w", err)
+	}
+
+	state.KVs, err = s.KV.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list key-value stores: %w", err)
+	}
+
+	state.ExternalFiles, err = s.Controller.GetExternalFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get external files: %w", err)
+	}
+
+	state.ControllerProps, err = s.Controller.GetProps(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get controller properties: %w", err)
+	}
+
+	for _, rd := range state.ResourceDefinitions {
+		conns, err := s.Resources.GetConnections(ctx, rd.Name, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get connections of '%s': %w", rd.Name, err)
+		}
+
+		state.Connections[rd.Name] = conns
+	}
+
+	return state, nil
+}
+
+// Scan collects the cluster state and finds all orphans in it.
+func (s *Scanner) Scan(ctx context.Context) (*Report, error) {
+	state, err := s.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{Time: state.Now, Orphans: Find(state, s.OwnerOfKV)}, nil
+}
+
+// Find returns the orphans in the state, sorted by kind and name. ownerOfKV is optional, see Scanner.OwnerOfKV.
+func Find(s *State, ownerOfKV func(kv client.KV, s *State) string) []Orphan {
+	orphans := []Orphan{}
+	orphans = append(orphans, emptyResourceDefinitions(s)...)
+	orphans = append(orphans, staleSnapshots(s)...)
+	orphans = append(orphans, orphanedKVs(s, ownerOfKV)...)
+	orphans = append(orphans, unusedExternalFiles(s)...)
+	orphans = append(orphans, emptyStoragePools(s)...)
+	orphans = append(orphans, danglingConnections(s)...)
+
+	sort.SliceStable(orphans, func(i, j int) bool {
+		if orphans[i].Kind != orphans[j].Kind {
+			return orphans[i].Kind < orphans[j].Kind
+		}
+		return orphans[i].String() < orphans[j].String()
+	})
+
+	return orphans
+}
+
+// emptyResourceDefinitions reports resource definitions without resources. Resource definitions that only hold
+// snapshots, for example on the secondary site of a disaster recovery setup, are still in use.
+func emptyResourceDefinitions(s *State) []Orphan {
+	used := make(map[string]bool)
+	for _, rsc := range s.Resources {
+		used[rsc.Name] = true
+	}
+	for _, snap := range s.Snapshots {
+		used[snap.ResourceName] = true
+	}
+
+	var orphans []Orphan
+	for _, rd := range s.ResourceDefinitions {
+		if used[rd.Name] || slices.Contains(rd.Flags, linstor.FlagDelete) {
+			continue
+		}
+
+		var size int64
+		for _, vd := range rd.VolumeDefinitions {
+			size += int64(vd.SizeKib)
+		}
+
+		orphans = append(orphans, Orphan{Kind: KindEmptyResourceDefinition, Name: rd.Name, Reason: "no resources", SizeKib: size})
+	}
+
+	return orphans
+}
+
+// staleSnapshots reports snapshots of deleted resource definitions, and snapshots that are older than every
+// resource of their resource definition, which happens if the resource definition was deleted and recreated.
+func staleSnapshots(s *State) []Orphan {
+	rds := make(map[string]bool, len(s.ResourceDefinitions))
+	for _, rd := range s.ResourceDefinitions {
+		rds[rd.Name] = true
+	}
+
+	oldestResource := make(map[string]time.Time)
+	for _, rsc := range s.Resources {
+		created := createdAt(rsc)
+		if created.IsZero() {
+			continue
+		}
+
+		if t, ok := oldestResource[rsc.Name]; !ok || created.Before(t) {
+			oldestResource[rsc.Name] = created
+		}
+	}
+
+	var orphans []Orphan
+	for _, snap := range s.Snapshots {
+		var created time.Time
+		for _, sn := range snap.Snapshots {
+			if sn.CreateTimestamp != nil && (created.IsZero() || sn.CreateTimestamp.Before(created)) {
+				created = sn.CreateTimestamp.Time
+			}
+		}
+
+		reason := ""
+		switch oldest, ok := oldestResource[snap.ResourceName]; {
+		case !rds[snap.ResourceName]:
+			reason = "resource definition no longer exists"
+		case ok && !created.IsZero() && created.Before(oldest):
+			reason = "older than every resource of the resource definition, which was probably recreated"
+		default:
+			continue
+		}
+
+		var size int64
+		for _, vd := range snap.VolumeDefinitions {
+			size += int64(vd.SizeKib)
+		}
+
+		orphans = append(orphans, Orphan{
+			Kind:     KindStaleSnapshot,
+			Name:     snap.Name,
+			Resource: snap.ResourceName,
+			Nodes:    snap.Nodes,
+			Reason:   reason,
+			Age:      age(s.Now, created),
+			SizeKib:  size,
+		})
+	}
+
+	return orphans
+}
+
+func orphanedKVs(s *State, ownerOfKV func(kv client.KV, s *State) string) []Orphan {
+	var orphans []Orphan
+	for _, kv := range s.KVs {
+		reason := ""
+		switch {
+		case len(kv.Props) == 0:
+			reason = "empty"
+		case ownerOfKV != nil:
+			reason = ownerOfKV(kv, s)
+		}
+
+		if reason != "" {
+			orphans = append(orphans, Orphan{Kind: KindOrphanedKV, Name: kv.Name, Reason: reason})
+		}
+	}
+
+	return orphans
+}
+
+func unusedExternalFiles(s *State) []Orphan {
+	attached := make(map[string]bool)
+	collect := func(props map[string]string) {
+		for k := range props {
+			if strings.HasPrefix(k, KeyPrefixFiles) {
+				attached[strings.TrimPrefix(strings.TrimPrefix(k, KeyPrefixFiles), "/")] = true
+			}
+		}
+	}
+
+	collect(s.ControllerProps)
+	for _, rg := range s.ResourceGroups {
+		collect(rg.Props)
+	}
+	for _, rd := range s.ResourceDefinitions {
+		collect(rd.Props)
+	}
+
+	var orphans []Orphan
+	for _, file := range s.ExternalFiles {
+		if attached[strings.TrimPrefix(file.Path, "/")] {
+			continue
+		}
+
+		orphans = append(orphans, Orphan{
+			Kind:    KindUnusedExternalFile,
+			Name:    file.Path,
+			Reason:  "not attached to the controller, a resource group or a resource definition",
+			SizeKib: (int64(len(file.Content)) + 1023) / 1024,
+		})
+	}
+
+	return orphans
+}
+
+// emptyStoragePools reports storage pools without volumes or snapshots. Snapshot volumes that do not report
+// their storage pool keep every pool of their node.
+func emptyStoragePools(s *State) []Orphan {
+	used := make(map[string]bool)
+	for _, rsc := range s.Resources {
+		for _, vol := range rsc.Volumes {
+			used[rsc.NodeName+"/"+vol.StoragePoolName] = true
+		}
+	}
+
+	withSnapshots := make(map[string]bool)
+	for _, snap := range s.Snapshots {
+		known := make(map[string]bool)
+		for _, node := range snap.Snapshots {
+			for _, vol := range node.SnapshotVolumes {
+				if pool := vol.Props[linstor.KeyStorPoolName]; pool != "" {
+					used[node.NodeName+"/"+pool] = true
+					known[node.NodeName] = true
+				}
+			}
+		}
+
+		for _, node := range snap.Nodes {
+			if !known[node] {
+				withSnapshots[node] = true
+			}
+		}
+	}
+
+	var orphans []Orphan
+	for _, sp := range s.StoragePools {
+		if sp.ProviderKind == client.DISKLESS || used[sp.NodeName+"/"+sp.StoragePoolName] || withSnapshots[sp.NodeName] {
+			continue
+		}
+
+		orphans = append(orphans, Orphan{
+			Kind:    KindEmptyStoragePool,
+			Name:    sp.StoragePoolName,
+			Nodes:   []string{sp.NodeName},
+			Reason:  "no volumes",
+			SizeKib: sp.TotalCapacity,
+		})
+	}
+
+	return orphans
+}
+
+func danglingConnections(s *State) []Orphan {
+	nodes := make(map[string]bool, len(s.Nodes))
+	for _, node := range s.Nodes {
+		nodes[node.Name] = true
+	}
+
+	var orphans []Orphan
+	for _, res := range sortedKeys(s.Connections) {
+		for _, conn := range s.Connections[res] {
+			if len(conn.Props) == 0 {
+				continue
+			}
+
+			var missing []string
+			for _, node := range []string{conn.NodeA, conn.NodeB} {
+				if !nodes[node] {
+					missing = append(missing, node)
+				}
+			}
+
+			if len(missing) == 0 {
+				continue
+			}
+
+			orphans = append(orphans, Orphan{
+				Kind:     KindDanglingConnection,
+				Resource: res,
+				Nodes:    []string{conn.NodeA, conn.NodeB},
+				Reason:   "properties reference deleted node " + strings.Join(missing, ", "),
+			})
+		}
+	}
+
+	return orphans
+}
+
+// Report is the result of a scan.
+type Report struct {
+	Time    time.Time `json:"time"`
+	Orphans []Orphan  `json:"orphans"`
+}
+
+// WriteText writes the report in a human readable form.
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, o := range r.Orphans {
+		fmt.Fprintf(&b, "[%s] %s: %s", o.Kind, o, o.Reason)
+
+		var details []string
+		if o.Age > 0 {
+			details = append(details, "age "+formatAge(o.Age))
+		}
+		if o.SizeKib > 0 {
+			details = append(details, fmt.Sprintf("%d KiB", o.SizeKib))
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+		}
+
+		b.WriteString("\n")
+	}
+
+	if len(r.Orphans) == 0 {
+		b.WriteString("no orphans found\n")
+	} else {
+		fmt.Fprintf(&b, "%d orphans\n", len(r.Orphans))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatAge(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh", days, hours)
+	}
+
+	return fmt.Sprintf("%dh", hours)
+}
+
+func createdAt(rsc client.ResourceWithVolumes) time.Time {
+	switch {
+	case rsc.CreateTimestamp != nil:
+		return rsc.CreateTimestamp.Time
+	case rsc.Resource.CreateTimestamp != nil:
+		return rsc.Resource.CreateTimestamp.Time
+	}
+
+	return time.Time{}
+}
+
+func age(now, created time.Time) time.Duration {
+	if created.IsZero() || now.Before(created) {
+		return 0
+	}
+
+	return now.Sub(created)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,302 @@
+package orphan_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/orphan"
+)
+
+var now = time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+
+func day(d int) *client.TimeStampMs {
+	return &client.TimeStampMs{Time: time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC)}
+}
+
+type recorder struct {
+	calls []string
+}
+
+type fakeNodes struct {
+	client.NodeProvider
+	r *recorder
+}
+
+func (f *fakeNodes) GetAll(context.Context, ...*client.ListOpts) ([]client.Node, error) {
+	return []client.Node{{Name: "n1"}, {Name: "n2"}}, nil
+}
+
+func (f *fakeNodes) GetStoragePoolView(context.Context, ...*client.ListOpts) ([]client.StoragePool, error) {
+	return []client.StoragePool{
+		{StoragePoolName: "data", NodeName: "n1", ProviderKind: client.LVM, TotalCapacity: 1000},
+		{StoragePoolName: "spare", NodeName: "n1", ProviderKind: client.LVM, TotalCapacity: 500},
+		{StoragePoolName: "data", NodeName: "n2", ProviderKind: client.LVM, TotalCapacity: 1000},
+		{StoragePoolName: "DfltDisklessStorPool", NodeName: "n2", ProviderKind: client.DISKLESS},
+	}, nil
+}
+
+func (f *fakeNodes) DeleteStoragePool(_ context.Context, node, pool string) error {
+	f.r.calls = append(f.r.calls, "delete pool "+pool+"@"+node)
+	return nil
+}
+
+type fakeResources struct {
+	client.ResourceProvider
+	r *recorder
+}
+
+func (f *fakeResources) GetResourceView(context.Context, ...*client.ListOpts) ([]client.ResourceWithVolumes, error) {
+	return []client.ResourceWithVolumes{{
+		Resource:        client.Resource{Name: "r1", NodeName: "n1"},
+		CreateTimestamp: day(1),
+		Volumes:         []client.Volume{{StoragePoolName: "data"}},
+	}}, nil
+}
+
+func (f *fakeResources) GetSnapshotView(context.Context, ...*client.ListOpts) ([]client.Snapshot, error) {
+	snap := func(res, name, node string, created *client.TimeStampMs) client.Snapshot {
+		return client.Snapshot{
+			Name:              name,
+			ResourceName:      res,
+			Nodes:             []string{node},
+			VolumeDefinitions: []client.SnapshotVolumeDefinition{{SizeKib: 100}},
+			Snapshots: []client.SnapshotNode{{
+				NodeName:        node,
+				CreateTimestamp: created,
+				SnapshotVolumes: []client.SnapshotVolumeNode{{Props: map[string]string{linstor.KeyStorPoolName: "data"}}},
+			}},
+		}
+	}
+
+	old := &client.TimeStampMs{Time: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+	return []client.Snapshot{
+		snap("r1", "s-old", "n1", old),
+		snap("r1", "s-new", "n1", day(5)),
+		snap("r9", "s-gone", "n1", day(8)),
+		// A resource definition that only holds snapshots, like on a disaster recovery site.
+		snap("r4", "s-dr", "n2", day(8)),
+	}, nil
+}
+
+func (f *fakeResources) GetConnections(_ context.Context, res, _, _ string, _ ...*client.ListOpts) ([]client.ResourceConnection, error) {
+	if res != "r1" {
+		return nil, nil
+	}
+
+	return []client.ResourceConnection{
+		{NodeA: "n1", NodeB: "n2", Props: map[string]string{"DrbdOptions/Net/protocol": "C"}},
+		{NodeA: "n1", NodeB: "n3", Props: map[string]string{"DrbdOptions/Net/protocol": "A"}},
+		{NodeA: "n1", NodeB: "n4"},
+	}, nil
+}
+
+func (f *fakeResources) DeleteSnapshot(_ context.Context, res, snap string, _ ...string) error {
+	f.r.calls = append(f.r.calls, "delete snapshot "+res+"/"+snap)
+	return nil
+}
+
+func (f *fakeResources) ModifyConnection(_ context.Context, res, a, b string, props client.GenericPropsModify) error {
+	f.r.calls = append(f.r.calls, "modify connection "+res+" "+a+"-"+b)
+	return nil
+}
+
+type fakeRDs struct {
+	client.ResourceDefinitionProvider
+	r *recorder
+}
+
+func (f *fakeRDs) GetAll(context.Context, client.RDGetAllRequest) ([]client.ResourceDefinitionWithVolumeDefinition, error) {
+	return []client.ResourceDefinitionWithVolumeDefinition{
+		{
+			ResourceDefinition: client.ResourceDefinition{Name: "r1", Props: map[string]string{"Files/etc/b": "True"}},
+			VolumeDefinitions:  []client.VolumeDefinition{{SizeKib: 100}},
+		},
+		{
+			ResourceDefinition: client.ResourceDefinition{Name: "r2"},
+			VolumeDefinitions:  []client.VolumeDefinition{{SizeKib: 50}},
+		},
+		{ResourceDefinition: client.ResourceDefinition{Name: "r3", Flags: []string{linstor.FlagDelete}}},
+		{ResourceDefinition: client.ResourceDefinition{Name: "r4"}},
+	}, nil
+}
+
+func (f *fakeRDs) Delete(_ context.Context, name string) error {
+	f.r.calls = append(f.r.calls, "delete rd "+name)
+	return nil
+}
+
+type fakeRGs struct {
+	client.ResourceGroupProvider
+}
+
+func (f *fakeRGs) GetAll(context.Context, ...*client.ListOpts) ([]client.ResourceGroup, error) {
+	return []client.ResourceGroup{{Name: "rg"}}, nil
+}
+
+type fakeController struct {
+	client.ControllerProvider
+	r *recorder
+}
+
+func (f *fakeController) GetExternalFiles(context.Context, ...*client.ListOpts) ([]client.ExternalFile, error) {
+	return []client.ExternalFile{
+		{Path: "/etc/a"},
+		{Path: "/etc/b"},
+		{Path: "/etc/c", Content: make([]byte, 2048)},
+	}, nil
+}
+
+func (f *fakeController) GetProps(context.Context, ...*client.ListOpts) (client.ControllerProps, error) {
+	return client.ControllerProps{"Files/etc/a": "True"}, nil
+}
+
+func (f *fakeController) DeleteExternalFile(_ context.Context, name string) error {
+	f.r.calls = append(f.r.calls, "delete file "+name)
+	return nil
+}
+
+type fakeKV struct {
+	client.KeyValueStoreProvider
+	r *recorder
+}
+
+func (f *fakeKV) List(context.Context) ([]client.KV, error) {
+	return []client.KV{
+		{Name: "empty"},
+		{Name: "app-x", Props: map[string]string{"owner": "gone"}},
+		{Name: "app-y", Props: map[string]string{"owner": "r1"}},
+	}, nil
+}
+
+func (f *fakeKV) Delete(_ context.Context, name string) error {
+	f.r.calls = append(f.r.calls, "delete kv "+name)
+	return nil
+}
+
+func newScanner(r *recorder) *orphan.Scanner {
+	return &orphan.Scanner{
+		Nodes:               &fakeNodes{r: r},
+		Resources:           &fakeResources{r: r},
+		ResourceDefinitions: &fakeRDs{r: r},
+		ResourceGroups:      &fakeRGs{},
+		Controller:          &fakeController{r: r},
+		KV:                  &fakeKV{r: r},
+		OwnerOfKV: func(kv client.KV, s *orphan.State) string {
+			for _, rd := range s.ResourceDefinitions {
+				if rd.Name == kv.Props["owner"] {
+					return ""
+				}
+			}
+			return "owner '" + kv.Props["owner"] + "' no longer exists"
+		},
+		Now: func() time.Time { return now },
+	}
+}
+
+func TestScan(t *testing.T) {
+	report, err := newScanner(&recorder{}).Scan(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []orphan.Orphan{
+		{Kind: orphan.KindDanglingConnection, Resource: "r1", Nodes: []string{"n1", "n3"}, Reason: "properties reference deleted node n3"},
+		{Kind: orphan.KindEmptyResourceDefinition, Name: "r2", Reason: "no resources", SizeKib: 50},
+		{Kind: orphan.KindEmptyStoragePool, Name: "spare", Nodes: []string{"n1"}, Reason: "no volumes", SizeKib: 500},
+		{Kind: orphan.KindOrphanedKV, Name: "app-x", Reason: "owner 'gone' no longer exists"},
+		{Kind: orphan.KindOrphanedKV, Name: "empty", Reason: "empty"},
+		{
+			Kind:     orphan.KindStaleSnapshot,
+			Name:     "s-old",
+			Resource: "r1",
+			Nodes:    []string{"n1"},
+			Reason:   "older than every resource of the resource definition, which was probably recreated",
+			Age:      40 * 24 * time.Hour,
+			SizeKib:  100,
+		},
+		{
+			Kind:     orphan.KindStaleSnapshot,
+			Name:     "s-gone",
+			Resource: "r9",
+			Nodes:    []string{"n1"},
+			Reason:   "resource definition no longer exists",
+			Age:      2 * 24 * time.Hour,
+			SizeKib:  100,
+		},
+		{Kind: orphan.KindUnusedExternalFile, Name: "/etc/c", Reason: "not attached to the controller, a resource group or a resource definition", SizeKib: 2},
+	}, report.Orphans)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteText(&buf))
+	assert.Contains(t, buf.String(), "[stale-snapshot] r1/s-old: older than every resource of the resource definition, which was probably recreated (age 40d0h, 100 KiB)\n")
+	assert.Contains(t, buf.String(), "[dangling-connection] r1: n1 <-> n3: properties reference deleted node n3\n")
+	assert.Contains(t, buf.String(), "8 orphans\n")
+}
+
+func TestCleanup(t *testing.T) {
+	r := &recorder{}
+	s := newScanner(r)
+
+	actions, err := s.Cleanup(context.Background(), orphan.CleanupOpts{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"delete properties of connection 'n1' <-> 'n3' of 'r1'",
+		"delete key-value store 'app-x'",
+		"delete key-value store 'empty'",
+	}, actions)
+	assert.Empty(t, r.calls)
+
+	// Only objects with a known age of at least a week.
+	actions, err = s.Cleanup(context.Background(), orphan.CleanupOpts{
+		Kinds:  []orphan.Kind{orphan.KindEmptyResourceDefinition, orphan.KindStaleSnapshot},
+		MinAge: 7 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"delete snapshot 's-old' of 'r1'"}, actions)
+	assert.Equal(t, []string{"delete snapshot r1/s-old"}, r.calls)
+
+	r.calls = nil
+	_, err = s.Cleanup(context.Background(), orphan.CleanupOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"modify connection r1 n1-n3",
+		"delete kv app-x",
+		"delete kv empty",
+	}, r.calls)
+
+	// Empty storage pools and external files are only deleted on request.
+	r.calls = nil
+	_, err = s.Cleanup(context.Background(), orphan.CleanupOpts{Kinds: []orphan.Kind{orphan.KindEmptyStoragePool, orphan.KindUnusedExternalFile}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"delete pool spare@n1", "delete file /etc/c"}, r.calls)
+}
+
+func TestFindStoragePoolsWithSnapshots(t *testing.T) {
+	state := &orphan.State{
+		StoragePools: []client.StoragePool{
+			{StoragePoolName: "a", NodeName: "n1", ProviderKind: client.LVM},
+			{StoragePoolName: "b", NodeName: "n1", ProviderKind: client.LVM},
+			{StoragePoolName: "a", NodeName: "n2", ProviderKind: client.LVM},
+		},
+		ResourceDefinitions: []client.ResourceDefinitionWithVolumeDefinition{{ResourceDefinition: client.ResourceDefinition{Name: "r1"}}},
+		Snapshots: []client.Snapshot{{
+			Name:         "s1",
+			ResourceName: "r1",
+			Nodes:        []string{"n1", "n2"},
+			Snapshots: []client.SnapshotNode{{
+				NodeName:        "n1",
+				SnapshotVolumes: []client.SnapshotVolumeNode{{Props: map[string]string{linstor.KeyStorPoolName: "a"}}},
+			}},
+		}},
+	}
+
+	// Only the pool of the snapshot is in use on n1. n2 does not report a pool, so all its pools are kept.
+	assert.Equal(t, []orphan.Orphan{
+		{Kind: orphan.KindEmptyStoragePool, Name: "b", Nodes: []string{"n1"}, Reason: "no volumes"},
+	}, orphan.Find(state, nil))
+}
@@ -0,0 +1,110 @@
+// Package placement simulates LINSTOR's autoplacer offline.
+//
+// A Cluster is a snapshot of the nodes, storage pools and resources of a LINSTOR cluster. Simulate places a number
+// of future resources on it, applying the filters of a client.AutoSelectFilter and the autoplacer weights, without
+// changing anything in the real cluster. This answers questions like "how many more 100GiB volumes fit if we
+// require replicas in different racks?".
+//
+// The simulation follows the documented autoplacer behaviour, but is not an exact copy of the controller's
+// implementation. Pre-select scripts and diskless resources (DisklessOnRemaining) are not simulated.
+package placement
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+)
+
+// Cluster is an offline copy of the cluster state relevant for placement.
+type Cluster struct {
+	Nodes        []client.Node
+	StoragePools []client.StoragePool
+	Resources    []client.ResourceWithVolumes
+	// ControllerProps are used to look up the autoplacer weights.
+	ControllerProps map[string]string
+}
+
+// Snapshot fetches the current state of the cluster using the given providers.
+func Snapshot(ctx context.Context, nodes client.NodeProvider, resources client.ResourceProvider, controller client.ControllerProvider) (*Cluster, error) {
+	n, err := nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	pools, err := nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage pools: %w", err)
+	}
+
+	rscs, err := resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+
+	props, err := controller.GetProps(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get controller properties: %w", err)
+	}
+
+	return &Cluster{Nodes: n, StoragePools: pools, Resources: rscs, ControllerProps: props}, nil
+}
+
+// SnapshotClient is like Snapshot, using the services of the given client.
+func SnapshotClient(ctx context.Context, c *client.Client) (*Cluster, error) {
+	return Snapshot(ctx, c.Nodes, c.Resources, c.Controller)
+}
+
+// Weights of the autoplacer strategies. A higher weight makes the strategy more important.
+type Weights struct {
+	// MaxFreeSpace prefers storage pools with more free space.
+	MaxFreeSpace float64
+	// MinReservedSpace prefers storage pools with less space reserved by volumes.
+	MinReservedSpace float64
+	// MinRscCount prefers nodes with fewer resources.
+	MinRscCount float64
+	// MaxThroughput prefers storage pools with more unused throughput.
+	MaxThroughput float64
+}
+
+// DefaultWeights are used by LINSTOR if no weight is configured.
+var DefaultWeights = Weights{MaxFreeSpace: 1}
+
+// Weights returns the autoplacer weights configured in ControllerProps. If none is configured, DefaultWeights
+// are returned.
+func (c *Cluster) Weights() (Weights, error) {
+	var w Weights
+	keys := []struct {
+		key    string
+		weight *float64
+	}{
+		{linstor.KeyAutoplaceStratWeightMaxFreespace, &w.MaxFreeSpace},
+		{linstor.KeyAutoplaceStratWeightMinReservedSpace, &w.MinReservedSpace},
+		{linstor.KeyAutoplaceStratWeightMinRscCount, &w.MinRscCount},
+		{linstor.KeyAutoplaceMaxThroughput, &w.MaxThroughput},
+	}
+
+	found := false
+	for _, k := range keys {
+		v, ok := c.ControllerProps[linstor.NamespcAutoplacerWeights+"/"+k.key]
+		if !ok {
+			continue
+		}
+
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Weights{}, fmt.Errorf("invalid autoplacer weight '%s': %w", k.key, err)
+		}
+
+		*k.weight = f
+		found = true
+	}
+
+	if !found {
+		return DefaultWeights, nil
+	}
+
+	return w, nil
+}
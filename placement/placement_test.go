@@ -0,0 +1,208 @@
+package placement_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/placement"
+)
+
+func node(name, rack string) client.Node {
+	return client.Node{Name: name, ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/rack": rack}}
+}
+
+func lvmPool(node string, freeKib int64) client.StoragePool {
+	return client.StoragePool{StoragePoolName: "pool", NodeName: node, ProviderKind: client.LVM, FreeCapacity: freeKib, TotalCapacity: freeKib}
+}
+
+func testCluster() *placement.Cluster {
+	return &placement.Cluster{
+		Nodes: []client.Node{node("n1", "a"), node("n2", "a"), node("n3", "b"), node("n4", "b")},
+		StoragePools: []client.StoragePool{
+			lvmPool("n1", 1000), lvmPool("n2", 1000), lvmPool("n3", 1000), lvmPool("n4", 1000),
+			{StoragePoolName: "DfltDisklessStorPool", NodeName: "n1", ProviderKind: client.DISKLESS},
+		},
+	}
+}
+
+func rack(r placement.Replica) string {
+	if r.NodeName == "n1" || r.NodeName == "n2" {
+		return "a"
+	}
+	return "b"
+}
+
+func TestSimulateReplicasOnDifferent(t *testing.T) {
+	result, err := testCluster().Simulate(placement.Request{
+		Filter:         client.AutoSelectFilter{ReplicasOnDifferent: []string{"Aux/rack"}},
+		VolumeSizesKib: []int64{300},
+		Count:          7,
+	})
+	require.NoError(t, err)
+
+	// Every pool fits 3 replicas, so 6 resources with 2 replicas each.
+	assert.Len(t, result.Placements, 6)
+	for _, p := range result.Placements {
+		require.Len(t, p.Replicas, 2)
+		assert.NotEqual(t, rack(p.Replicas[0]), rack(p.Replicas[1]), p.Resource)
+	}
+
+	require.Len(t, result.Failures, 1)
+	assert.Equal(t, "sim-6", result.Failures[0].Resource)
+	assert.Contains(t, result.Failures[0].Reason, "not enough space: 4")
+
+	require.Len(t, result.Remaining, 5)
+	for _, c := range result.Remaining {
+		if c.ProviderKind == client.LVM {
+			assert.Equal(t, int64(100), c.FreeKib, c.NodeName)
+			assert.Equal(t, 3, c.Placed, c.NodeName)
+		}
+	}
+}
+
+func TestSimulateFilters(t *testing.T) {
+	cluster := testCluster()
+	cluster.Nodes[1].ConnectionStatus = "OFFLINE"
+	cluster.Resources = []client.ResourceWithVolumes{
+		{Resource: client.Resource{Name: "db", NodeName: "n3"}},
+	}
+
+	result, err := cluster.Simulate(placement.Request{
+		Filter:         client.AutoSelectFilter{PlaceCount: 2, NotPlaceWithRsc: []string{"db"}},
+		VolumeSizesKib: []int64{100},
+		Count:          1,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Placements, 1)
+	assert.ElementsMatch(t, []placement.Replica{
+		{NodeName: "n1", StoragePoolName: "pool"},
+		{NodeName: "n4", StoragePoolName: "pool"},
+	}, result.Placements[0].Replicas)
+
+	result, err = cluster.Simulate(placement.Request{
+		Filter:         client.AutoSelectFilter{PlaceCount: 3, NotPlaceWithRsc: []string{"db"}},
+		VolumeSizesKib: []int64{100},
+		Count:          1,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Failures, 1)
+	assert.Equal(t, "only 2 of 3 required nodes have an eligible storage pool (rejected storage pools: conflicting resource: 1, diskless: 1, node offline: 1)", result.Failures[0].Reason)
+
+	_, err = cluster.Simulate(placement.Request{VolumeSizesKib: []int64{100}})
+	assert.Error(t, err)
+}
+
+func TestSimulateWeights(t *testing.T) {
+	cluster := testCluster()
+	cluster.StoragePools[0].FreeCapacity = 2000
+	cluster.Resources = []client.ResourceWithVolumes{
+		{Resource: client.Resource{Name: "a", NodeName: "n1"}},
+		{Resource: client.Resource{Name: "b", NodeName: "n1"}},
+		{Resource: client.Resource{Name: "c", NodeName: "n2"}},
+	}
+
+	// By default, the pool with the most free space wins.
+	result, err := cluster.Simulate(placement.Request{VolumeSizesKib: []int64{100}, Count: 1, Filter: client.AutoSelectFilter{PlaceCount: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, "n1", result.Placements[0].Replicas[0].NodeName)
+
+	cluster.ControllerProps = map[string]string{"Autoplacer/Weights/MinRscCount": "1"}
+	weights, err := cluster.Weights()
+	require.NoError(t, err)
+	assert.Equal(t, placement.Weights{MinRscCount: 1}, weights)
+
+	result, err = cluster.Simulate(placement.Request{VolumeSizesKib: []int64{100}, Count: 1, Filter: client.AutoSelectFilter{PlaceCount: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, "n3", result.Placements[0].Replicas[0].NodeName)
+}
+
+func TestSimulateThinOverprovision(t *testing.T) {
+	ratio := 2.0
+	cluster := &placement.Cluster{
+		Nodes:        []client.Node{node("n1", "a")},
+		StoragePools: []client.StoragePool{{StoragePoolName: "thin", NodeName: "n1", ProviderKind: client.LVM_THIN, FreeCapacity: 1000, TotalCapacity: 1000}},
+	}
+
+	result, err := cluster.Simulate(placement.Request{
+		Filter:         client.AutoSelectFilter{PlaceCount: 1, Overprovision: &ratio},
+		VolumeSizesKib: []int64{500},
+		Count:          5,
+	})
+	require.NoError(t, err)
+	assert.Len(t, result.Placements, 4)
+	assert.Len(t, result.Failures, 1)
+	assert.Equal(t, int64(2000), result.Remaining[0].ReservedKib)
+}
+
+type fakeNodes struct {
+	client.NodeProvider
+	cluster *placement.Cluster
+}
+
+func (f *fakeNodes) GetAll(context.Context, ...*client.ListOpts) ([]client.Node, error) {
+	return f.cluster.Nodes, nil
+}
+
+func (f *fakeNodes) GetStoragePoolView(context.Context, ...*client.ListOpts) ([]client.StoragePool, error) {
+	return f.cluster.StoragePools, nil
+}
+
+type fakeResources struct {
+	client.ResourceProvider
+}
+
+func (f *fakeResources) GetResourceView(context.Context, ...*client.ListOpts) ([]client.ResourceWithVolumes, error) {
+	return nil, nil
+}
+
+type fakeController struct {
+	client.ControllerProvider
+}
+
+func (f *fakeController) GetProps(context.Context, ...*client.ListOpts) (client.ControllerProps, error) {
+	return client.ControllerProps{"Autoplacer/Weights/MaxFreeSpace": "2"}, nil
+}
+
+func TestReplicasAllowed(t *testing.T) {
+	a := map[string]string{"Aux/rack": "a", "Aux/site": "x"}
+	b := map[string]string{"Aux/rack": "b", "Aux/site": "x"}
+
+	testcases := []struct {
+		name   string
+		filter client.AutoSelectFilter
+		props  map[string]string
+		others []map[string]string
+		allow  bool
+	}{
+		{name: "different", filter: client.AutoSelectFilter{ReplicasOnDifferent: []string{"Aux/rack"}}, props: b, others: []map[string]string{a}, allow: true},
+		{name: "different conflict", filter: client.AutoSelectFilter{ReplicasOnDifferent: []string{"Aux/rack"}}, props: a, others: []map[string]string{a}},
+		{name: "different without prefix", filter: client.AutoSelectFilter{ReplicasOnDifferent: []string{"rack"}}, props: a, others: []map[string]string{a}},
+		{name: "different missing key", filter: client.AutoSelectFilter{ReplicasOnDifferent: []string{"row"}}, props: a, others: []map[string]string{a}, allow: true},
+		{name: "same", filter: client.AutoSelectFilter{ReplicasOnSame: []string{"site"}}, props: b, others: []map[string]string{a}, allow: true},
+		{name: "same value", filter: client.AutoSelectFilter{ReplicasOnSame: []string{"Aux/site=y"}}, props: b},
+		{name: "same missing key", filter: client.AutoSelectFilter{ReplicasOnSame: []string{"row"}}, props: b},
+		{name: "x different", filter: client.AutoSelectFilter{XReplicasOnDifferent: map[string]int{"site": 2}}, props: b, others: []map[string]string{a}, allow: true},
+		{name: "x different conflict", filter: client.AutoSelectFilter{XReplicasOnDifferent: map[string]int{"site": 2}}, props: b, others: []map[string]string{a, a}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.allow, placement.ReplicasAllowed(tc.filter, tc.props, tc.others))
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cluster, err := placement.Snapshot(context.Background(), &fakeNodes{cluster: testCluster()}, &fakeResources{}, &fakeController{})
+	require.NoError(t, err)
+	assert.Len(t, cluster.Nodes, 4)
+	assert.Len(t, cluster.StoragePools, 5)
+
+	weights, err := cluster.Weights()
+	require.NoError(t, err)
+	assert.Equal(t, placement.Weights{MaxFreeSpace: 2}, weights)
+}
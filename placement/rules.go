@@ -0,0 +1,88 @@
+package placement
+
+import (
+	"slices"
+	"strings"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+)
+
+// Ineligible returns why the autoplacer does not place replicas in the storage pool, or an empty string if it
+// may. Only the node and the pool itself are checked, not the select filter.
+func Ineligible(node client.Node, sp client.StoragePool) string {
+	switch {
+	case node.ConnectionStatus != "ONLINE":
+		return "node offline"
+	case slices.Contains(node.Flags, linstor.FlagEvicted) || slices.Contains(node.Flags, linstor.FlagEvacuate) || slices.Contains(node.Flags, linstor.FlagDelete):
+		return "node evicted or evacuating"
+	case strings.EqualFold(node.Props[linstor.KeyAutoplaceAllowTarget], "false"):
+		return "node not an autoplace target"
+	case sp.ProviderKind == client.DISKLESS:
+		return "diskless"
+	}
+
+	return ""
+}
+
+// ReplicasAllowed checks ReplicasOnSame, ReplicasOnDifferent and XReplicasOnDifferent of the filter for a new
+// replica on a node with the given properties, next to replicas on nodes with the others properties.
+//
+// Keys are auxiliary properties. As in the LINSTOR client, the Aux/ prefix may be left out: "rack" and "Aux/rack"
+// are the same key.
+func ReplicasAllowed(filter client.AutoSelectFilter, props map[string]string, others []map[string]string) bool {
+	for _, same := range filter.ReplicasOnSame {
+		key, want, hasValue := strings.Cut(same, "=")
+		key = auxKey(key)
+		v, ok := props[key]
+		if !ok || (hasValue && v != want) {
+			return false
+		}
+
+		for _, other := range others {
+			if other[key] != v {
+				return false
+			}
+		}
+	}
+
+	for _, key := range filter.ReplicasOnDifferent {
+		if count(auxKey(key), props, others) > 1 {
+			return false
+		}
+	}
+
+	for key, max := range filter.XReplicasOnDifferent {
+		if count(auxKey(key), props, others) > max {
+			return false
+		}
+	}
+
+	return true
+}
+
+// count returns how many replicas, including the new one, have the same value for key. Nodes without the key
+// do not count.
+func count(key string, props map[string]string, others []map[string]string) int {
+	v, ok := props[key]
+	if !ok {
+		return 0
+	}
+
+	n := 1
+	for _, other := range others {
+		if ov, ok := other[key]; ok && ov == v {
+			n++
+		}
+	}
+
+	return n
+}
+
+func auxKey(key string) string {
+	if strings.HasPrefix(key, linstor.NamespcAuxiliary+"/") {
+		return key
+	}
+
+	return linstor.NamespcAuxiliary + "/" + key
+}
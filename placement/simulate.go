@@ -0,0 +1,520 @@
+package placement
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/devicelayerkind"
+)
+
+// defaultOversubscription is LINSTOR's default for MaxOversubscriptionRatio.
+const defaultOversubscription = 20.0
+
+// maxSearchSteps limits the search for a combination of storage pools satisfying the replica constraints.
+const maxSearchSteps = 100000
+
+// Request describes the resources to place.
+type Request struct {
+	// Filter is the select filter used for every resource, usually taken from a resource group. PlaceCount defaults
+	// to 2, as in LINSTOR.
+	Filter client.AutoSelectFilter
+	// VolumeSizesKib are the sizes of the volumes of each resource. All volumes of a resource are placed in the
+	// same storage pool.
+	VolumeSizesKib []int64
+	// Count is the number of resources to place.
+	Count int
+	// NamePrefix is used to name the simulated resources, followed by a number. Defaults to "sim-".
+	NamePrefix string
+	// ThroughputPerVolume is the throughput each placed volume reserves, for the MaxThroughput strategy.
+	ThroughputPerVolume int64
+}
+
+// Replica is a placed replica of a resource.
+type Replica struct {
+	NodeName        string
+	StoragePoolName string
+}
+
+// Placement is a simulated resource and where its replicas were placed.
+type Placement struct {
+	Resource string
+	Replicas []Replica
+}
+
+// Failure is a simulated resource that could not be placed.
+type Failure struct {
+	Resource string
+	Reason   string
+}
+
+// Capacity is the state of a storage pool after the simulation.
+type Capacity struct {
+	NodeName        string
+	StoragePoolName string
+	ProviderKind    client.ProviderKind
+	// FreeKib is the space that can still be used for new volumes, taking oversubscription of thin pools into
+	// account.
+	FreeKib     int64
+	TotalKib    int64
+	ReservedKib int64
+	// Placed is the number of simulated replicas in this pool.
+	Placed int
+}
+
+// Result of a simulation.
+type Result struct {
+	Placements []Placement
+	Failures   []Failure
+	// Remaining lists the capacity of all storage pools after the simulation, sorted by node and pool name.
+	Remaining []Capacity
+}
+
+// pool is the simulated state of a storage pool.
+type pool struct {
+	sp   client.StoragePool
+	node *client.Node
+	// free is the physically free space, reserved the sum of the sizes of all volumes.
+	free, reserved int64
+	// throughput is the unused throughput, or -1 if the pool has no limit.
+	throughput int64
+	placed     int
+}
+
+func (p *pool) thin() bool {
+	switch p.sp.ProviderKind {
+	case client.LVM_THIN, client.ZFS_THIN, client.FILE_THIN:
+		return true
+	}
+
+	return false
+}
+
+// available returns the space available for new volumes.
+func (p *pool) available(overprovision *float64) int64 {
+	if !p.thin() {
+		return p.free
+	}
+
+	ratio := defaultOversubscription
+	if overprovision != nil {
+		ratio = *overprovision
+	} else if r, err := strconv.ParseFloat(p.sp.Props[linstor.NamespcStorageDriver+"/"+linstor.KeyStorPoolMaxOversubscriptionRatio], 64); err == nil {
+		ratio = r
+	}
+
+	avail := int64(float64(p.free) * ratio)
+	if byTotal := int64(float64(p.sp.TotalCapacity)*ratio) - p.reserved; byTotal < avail {
+		avail = byTotal
+	}
+
+	if avail < 0 {
+		return 0
+	}
+
+	return avail
+}
+
+type simulation struct {
+	req      Request
+	weights  Weights
+	pools    []*pool
+	rscCount map[string]int
+	// rscOnNode contains the names of all resources on a node.
+	rscOnNode   map[string]map[string]bool
+	notPlaceRgx *regexp.Regexp
+}
+
+// Simulate places req.Count resources, one after the other, so that each placement sees the space used by the
+// previous ones. The Cluster itself is not modified.
+func (c *Cluster) Simulate(req Request) (*Result, error) {
+	if req.Count < 1 {
+		return nil, errors.New("count must be at least 1")
+	}
+
+	if len(req.VolumeSizesKib) == 0 {
+		return nil, errors.New("at least one volume size is required")
+	}
+
+	for i, size := range req.VolumeSizesKib {
+		if size <= 0 {
+			return nil, fmt.Errorf("volume %d: size must be positive", i)
+		}
+	}
+
+	if req.Filter.PlaceCount == 0 {
+		req.Filter.PlaceCount = 2
+	}
+
+	if req.NamePrefix == "" {
+		req.NamePrefix = "sim-"
+	}
+
+	weights, err := c.Weights()
+	if err != nil {
+		return nil, err
+	}
+
+	sim := &simulation{
+		req:       req,
+		weights:   weights,
+		rscCount:  make(map[string]int),
+		rscOnNode: make(map[string]map[string]bool),
+	}
+
+	if req.Filter.NotPlaceWithRscRegex != "" {
+		sim.notPlaceRgx, err = regexp.Compile(req.Filter.NotPlaceWithRscRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NotPlaceWithRscRegex: %w", err)
+		}
+	}
+
+	c.load(sim)
+
+	result := &Result{}
+	for i := 0; i < req.Count; i++ {
+		name := fmt.Sprintf("%s%d", req.NamePrefix, i)
+		chosen, reason := sim.place()
+		if chosen == nil {
+			result.Failures = append(result.Failures, Failure{Resource: name, Reason: reason})
+			continue
+		}
+
+		placement := Placement{Resource: name}
+		for _, p := range chosen {
+			sim.commit(p, name)
+			placement.Replicas = append(placement.Replicas, Replica{NodeName: p.sp.NodeName, StoragePoolName: p.sp.StoragePoolName})
+		}
+
+		result.Placements = append(result.Placements, placement)
+	}
+
+	for _, p := range sim.pools {
+		result.Remaining = append(result.Remaining, Capacity{
+			NodeName:        p.sp.NodeName,
+			StoragePoolName: p.sp.StoragePoolName,
+			ProviderKind:    p.sp.ProviderKind,
+			FreeKib:         p.available(req.Filter.Overprovision),
+			TotalKib:        p.sp.TotalCapacity,
+			ReservedKib:     p.reserved,
+			Placed:          p.placed,
+		})
+	}
+
+	return result, nil
+}
+
+// load copies the cluster state into the simulation.
+func (c *Cluster) load(sim *simulation) {
+	nodes := make(map[string]*client.Node, len(c.Nodes))
+	for i := range c.Nodes {
+		nodes[c.Nodes[i].Name] = &c.Nodes[i]
+	}
+
+	type poolKey struct{ node, pool string }
+	byKey := make(map[poolKey]*pool)
+	for _, sp := range c.StoragePools {
+		node, ok := nodes[sp.NodeName]
+		if !ok {
+			continue
+		}
+
+		p := &pool{sp: sp, node: node, free: sp.FreeCapacity, throughput: -1}
+		if limit, err := strconv.ParseInt(sp.Props[linstor.NamespcAutoplacer+"/"+linstor.KeyAutoplaceMaxThroughput], 10, 64); err == nil {
+			p.throughput = limit
+		}
+
+		sim.pools = append(sim.pools, p)
+		byKey[poolKey{sp.NodeName, sp.StoragePoolName}] = p
+	}
+
+	sort.Slice(sim.pools, func(i, j int) bool {
+		a, b := sim.pools[i].sp, sim.pools[j].sp
+		if a.NodeName != b.NodeName {
+			return a.NodeName < b.NodeName
+		}
+		return a.StoragePoolName < b.StoragePoolName
+	})
+
+	for _, rsc := range c.Resources {
+		sim.addResource(rsc.NodeName, rsc.Name)
+
+		for _, vol := range rsc.Volumes {
+			p, ok := byKey[poolKey{rsc.NodeName, vol.StoragePoolName}]
+			if !ok {
+				continue
+			}
+
+			p.reserved += vol.UsableSizeKib
+			if p.throughput >= 0 {
+				p.throughput -= volumeThroughput(vol.Props)
+			}
+		}
+	}
+}
+
+func volumeThroughput(props map[string]string) int64 {
+	var sum int64
+	for _, key := range []string{linstor.KeySysFsBlkioThrottleRead, linstor.KeySysFsBlkioThrottleWrite} {
+		if v, err := strconv.ParseInt(props[linstor.NamespcSysFs+"/"+key], 10, 64); err == nil {
+			sum += v
+		}
+	}
+
+	return sum
+}
+
+func (s *simulation) addResource(node, rsc string) {
+	if s.rscOnNode[node] == nil {
+		s.rscOnNode[node] = make(map[string]bool)
+	}
+
+	if !s.rscOnNode[node][rsc] {
+		s.rscOnNode[node][rsc] = true
+		s.rscCount[node]++
+	}
+}
+
+func (s *simulation) sizeKib() int64 {
+	var sum int64
+	for _, size := range s.req.VolumeSizesKib {
+		sum += size
+	}
+
+	return sum
+}
+
+// commit records a replica of rsc in p.
+func (s *simulation) commit(p *pool, rsc string) {
+	size := s.sizeKib()
+	if !p.thin() {
+		p.free -= size
+	}
+
+	p.reserved += size
+	p.placed++
+	if p.throughput >= 0 {
+		p.throughput -= s.req.ThroughputPerVolume * int64(len(s.req.VolumeSizesKib))
+	}
+
+	s.addResource(p.sp.NodeName, rsc)
+}
+
+type candidate struct {
+	pool  *pool
+	score float64
+}
+
+// place selects the storage pools for one resource. If no valid selection exists, it returns a reason.
+func (s *simulation) place() ([]*pool, string) {
+	var candidates []candidate
+	rejected := make(map[string]int)
+	for _, p := range s.pools {
+		if reason := s.reject(p); reason != "" {
+			rejected[reason]++
+			continue
+		}
+
+		candidates = append(candidates, candidate{pool: p})
+	}
+
+	s.score(candidates)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	placeCount := int(s.req.Filter.PlaceCount)
+	nodes := make(map[string]bool)
+	for _, c := range candidates {
+		nodes[c.pool.sp.NodeName] = true
+	}
+
+	if len(nodes) < placeCount {
+		return nil, fmt.Sprintf("only %d of %d required nodes have an eligible storage pool%s", len(nodes), placeCount, formatRejected(rejected))
+	}
+
+	steps := 0
+	chosen, ok := s.search(candidates, nil, placeCount, &steps)
+	if !ok {
+		if steps >= maxSearchSteps {
+			return nil, "no combination satisfying the replica constraints found within the search limit"
+		}
+
+		return nil, "no combination of nodes satisfies the replica constraints"
+	}
+
+	return chosen, ""
+}
+
+func formatRejected(rejected map[string]int) string {
+	if len(rejected) == 0 {
+		return ""
+	}
+
+	reasons := make([]string, 0, len(rejected))
+	for reason, count := range rejected {
+		reasons = append(reasons, fmt.Sprintf("%s: %d", reason, count))
+	}
+
+	sort.Strings(reasons)
+
+	return " (rejected storage pools: " + strings.Join(reasons, ", ") + ")"
+}
+
+// reject returns why the storage pool cannot be used for the resource, or an empty string if it can.
+func (s *simulation) reject(p *pool) string {
+	f := &s.req.Filter
+	node := p.node
+
+	if reason := Ineligible(*node, p.sp); reason != "" {
+		return reason
+	}
+
+	switch {
+	case len(f.NodeNameList) > 0 && !slices.Contains(f.NodeNameList, node.Name):
+		return "node not selected"
+	case f.StoragePool != "" && p.sp.StoragePoolName != f.StoragePool,
+		len(f.StoragePoolList) > 0 && !slices.Contains(f.StoragePoolList, p.sp.StoragePoolName):
+		return "storage pool not selected"
+	case len(f.ProviderList) > 0 && !slices.Contains(f.ProviderList, string(p.sp.ProviderKind)):
+		return "provider not selected"
+	case !supportsLayers(node, f.LayerStack):
+		return "layer not supported"
+	case s.conflicts(node.Name):
+		return "conflicting resource"
+	case p.available(f.Overprovision) < s.sizeKib():
+		return "not enough space"
+	}
+
+	return ""
+}
+
+func supportsLayers(node *client.Node, layers []string) bool {
+	for _, layer := range layers {
+		found := false
+		for _, l := range node.ResourceLayers {
+			if strings.EqualFold(string(l), layer) {
+				found = true
+				break
+			}
+		}
+
+		// Nodes that do not report their layers are assumed to support the storage layer.
+		if !found && !(len(node.ResourceLayers) == 0 && strings.EqualFold(layer, string(devicelayerkind.Storage))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// conflicts returns true if the node has a resource that must not be placed together with the new one.
+func (s *simulation) conflicts(node string) bool {
+	for rsc := range s.rscOnNode[node] {
+		if slices.Contains(s.req.Filter.NotPlaceWithRsc, rsc) {
+			return true
+		}
+
+		if s.notPlaceRgx != nil && s.notPlaceRgx.MatchString(rsc) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// score computes the weighted score of each candidate. Every strategy value is normalized to [0, 1] relative to
+// the best candidate, so that weights are comparable.
+func (s *simulation) score(candidates []candidate) {
+	strategies := []struct {
+		weight   float64
+		maximize bool
+		value    func(p *pool) float64
+	}{
+		{s.weights.MaxFreeSpace, true, func(p *pool) float64 { return float64(p.available(s.req.Filter.Overprovision)) }},
+		{s.weights.MinReservedSpace, false, func(p *pool) float64 { return float64(p.reserved) }},
+		{s.weights.MinRscCount, false, func(p *pool) float64 { return float64(s.rscCount[p.sp.NodeName]) }},
+		{s.weights.MaxThroughput, true, func(p *pool) float64 {
+			if p.throughput < 0 {
+				return 0
+			}
+			return float64(p.throughput)
+		}},
+	}
+
+	for _, strategy := range strategies {
+		if strategy.weight == 0 {
+			continue
+		}
+
+		values := make([]float64, len(candidates))
+		maxValue := 0.0
+		for i := range candidates {
+			values[i] = strategy.value(candidates[i].pool)
+			if values[i] > maxValue {
+				maxValue = values[i]
+			}
+		}
+
+		for i := range candidates {
+			normalized := 0.0
+			if maxValue > 0 {
+				normalized = values[i] / maxValue
+			}
+
+			if !strategy.maximize {
+				normalized = 1 - normalized
+			}
+
+			candidates[i].score += strategy.weight * normalized
+		}
+	}
+}
+
+// search finds the best scored combination of storage pools on different nodes that satisfies the replica
+// constraints. Candidates must be sorted by score.
+func (s *simulation) search(candidates []candidate, chosen []*pool, placeCount int, steps *int) ([]*pool, bool) {
+	if len(chosen) == placeCount {
+		return chosen, true
+	}
+
+	for i, c := range candidates {
+		*steps++
+		if *steps >= maxSearchSteps {
+			return nil, false
+		}
+
+		if !s.compatible(chosen, c.pool) {
+			continue
+		}
+
+		if result, ok := s.search(candidates[i+1:], append(chosen, c.pool), placeCount, steps); ok {
+			return result, true
+		}
+	}
+
+	return nil, false
+}
+
+// compatible returns true if p can be added to the chosen pools without violating the replica constraints.
+func (s *simulation) compatible(chosen []*pool, p *pool) bool {
+	for _, c := range chosen {
+		if c.sp.NodeName == p.sp.NodeName {
+			return false
+		}
+	}
+
+	others := make([]map[string]string, len(chosen))
+	for i, c := range chosen {
+		others[i] = c.node.Props
+	}
+
+	return ReplicasAllowed(s.req.Filter, p.node.Props, others)
+}
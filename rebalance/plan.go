@@ -0,0 +1,324 @@
+// Package rebalance evens out the utilization of storage pools by moving replicas between nodes.
+//
+// The controller properties linstor.KeyBalanceResourcesEnabled and linstor.KeyBalanceResourcesInterval only
+// restore the replica count of resources. The Rebalancer here looks at capacity instead: it groups the storage pools
+// by name, computes their utilization from StoragePool.FreeCapacity and StoragePool.TotalCapacity and proposes
+// moves from the fullest to the emptiest pools until the spread of each group is below a threshold.
+package rebalance
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/placement"
+)
+
+// Move moves the replica of a resource to another node, in a storage pool of the same name.
+type Move struct {
+	Resource    string `json:"resource"`
+	FromNode    string `json:"from_node"`
+	ToNode      string `json:"to_node"`
+	StoragePool string `json:"storage_pool"`
+	// SizeKib is the space allocated by the replica.
+	SizeKib int64 `json:"size_kib"`
+}
+
+func (m Move) String() string {
+	return fmt.Sprintf("move %s from %s to %s (pool %s, %d KiB)", m.Resource, m.FromNode, m.ToNode, m.StoragePool, m.SizeKib)
+}
+
+// Group is the imbalance of all storage pools with the same name, before and after the planned moves. The
+// imbalance is the difference between the highest and the lowest utilization, from 0 to 1.
+type Group struct {
+	StoragePool string  `json:"storage_pool"`
+	Before      float64 `json:"before"`
+	After       float64 `json:"after"`
+}
+
+// Plan is an ordered list of moves.
+type Plan struct {
+	Moves  []Move  `json:"moves"`
+	Groups []Group `json:"groups"`
+}
+
+// WriteText writes the plan in a human readable form, as used for dry runs.
+func (p *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, m := range p.Moves {
+		fmt.Fprintf(&b, "%s\n", m)
+	}
+
+	for _, g := range p.Groups {
+		fmt.Fprintf(&b, "pool %s: imbalance %.1f%% -> %.1f%%\n", g.StoragePool, 100*g.Before, 100*g.After)
+	}
+
+	if len(p.Moves) == 0 {
+		b.WriteString("no moves needed\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// State is the cluster state a plan is computed from.
+type State struct {
+	Nodes        []client.Node
+	StoragePools []client.StoragePool
+	Resources    []client.ResourceWithVolumes
+	// SelectFilters are the select filters of the resource groups, by resource definition name.
+	SelectFilters map[string]client.AutoSelectFilter
+}
+
+// PlanOpts control Compute.
+type PlanOpts struct {
+	// Threshold is the imbalance at which a group counts as balanced. Defaults to 0.1.
+	Threshold float64
+	// MaxMoves limits the number of moves. Defaults to 10.
+	MaxMoves int
+	// StoragePools restricts balancing to the storage pools with these names. Optional.
+	StoragePools []string
+}
+
+type pool struct {
+	node  client.Node
+	name  string
+	total int64
+	free  int64
+}
+
+func (p *pool) utilization() float64 {
+	return float64(p.total-p.free) / float64(p.total)
+}
+
+type candidate struct {
+	resource string
+	node     string
+	pool     string
+	size     int64
+	moved    bool
+}
+
+// Compute plans moves that bring the imbalance of every group of storage pools to the threshold or below.
+//
+// Pools the autoplacer may not use, as checked by placement.Ineligible, are not balanced. A replica
+// can be moved if it is diskful, all its volumes are in the same pool and the resource is not in use on any node.
+// The target node must not have a diskful replica of the resource, must have enough free space and must satisfy
+// the replica constraints of the resource group, as checked by placement.ReplicasAllowed. Every replica is moved at most once, and a move
+// is only planned if it reduces the difference between the two pools involved.
+func Compute(s *State, opts PlanOpts) *Plan {
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = 0.1
+	}
+
+	maxMoves := opts.MaxMoves
+	if maxMoves == 0 {
+		maxMoves = 10
+	}
+
+	nodes := make(map[string]client.Node, len(s.Nodes))
+	for _, node := range s.Nodes {
+		nodes[node.Name] = node
+	}
+
+	groups := make(map[string][]*pool)
+	for _, sp := range s.StoragePools {
+		node, ok := nodes[sp.NodeName]
+		if !ok || !eligible(node, sp, opts) {
+			continue
+		}
+
+		groups[sp.StoragePoolName] = append(groups[sp.StoragePoolName], &pool{node: node, name: sp.StoragePoolName, total: sp.TotalCapacity, free: sp.FreeCapacity})
+	}
+
+	replicas, candidates := analyzeResources(s.Resources)
+
+	plan := &Plan{Moves: []Move{}, Groups: []Group{}}
+	for _, name := range sortedKeys(groups) {
+		pools := groups[name]
+		if len(pools) < 2 {
+			continue
+		}
+
+		group := Group{StoragePool: name, Before: imbalance(pools)}
+		for len(plan.Moves) < maxMoves && imbalance(pools) > threshold {
+			move, ok := nextMove(pools, candidates, replicas, nodes, s.SelectFilters)
+			if !ok {
+				break
+			}
+
+			plan.Moves = append(plan.Moves, move)
+		}
+
+		group.After = imbalance(pools)
+		plan.Groups = append(plan.Groups, group)
+	}
+
+	return plan
+}
+
+func eligible(node client.Node, sp client.StoragePool, opts PlanOpts) bool {
+	switch {
+	case placement.Ineligible(node, sp) != "",
+		sp.TotalCapacity <= 0,
+		len(opts.StoragePools) > 0 && !slices.Contains(opts.StoragePools, sp.StoragePoolName):
+		return false
+	}
+
+	return true
+}
+
+// analyzeResources returns the nodes with a diskful replica of every resource, and the replicas that can be
+// moved.
+func analyzeResources(rscs []client.ResourceWithVolumes) (map[string][]string, []*candidate) {
+	inUse := make(map[string]bool)
+	for _, rsc := range rscs {
+		if rsc.State != nil && rsc.State.InUse != nil && *rsc.State.InUse {
+			inUse[rsc.Name] = true
+		}
+	}
+
+	replicas := make(map[string][]string)
+	var candidates []*candidate
+	for _, rsc := range rscs {
+		if !rsc.Diskful() {
+			continue
+		}
+
+		replicas[rsc.Name] = append(replicas[rsc.Name], rsc.NodeName)
+
+		if inUse[rsc.Name] || len(rsc.Volumes) == 0 {
+			continue
+		}
+
+		c := &candidate{resource: rsc.Name, node: rsc.NodeName, pool: rsc.Volumes[0].StoragePoolName}
+		for _, vol := range rsc.Volumes {
+			if vol.StoragePoolName != c.pool {
+				c = nil
+				break
+			}
+
+			size := vol.AllocatedSizeKib
+			if size == 0 {
+				size = vol.UsableSizeKib
+			}
+			c.size += size
+		}
+
+		if c != nil {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].resource != candidates[j].resource {
+			return candidates[i].resource < candidates[j].resource
+		}
+		return candidates[i].node < candidates[j].node
+	})
+
+	return replicas, candidates
+}
+
+// nextMove finds the move that best evens out a pair of pools, trying the fullest sources and the emptiest targets
+// first, and applies it to the pools.
+func nextMove(pools []*pool, candidates []*candidate, replicas map[string][]string, nodes map[string]client.Node, filters map[string]client.AutoSelectFilter) (Move, bool) {
+	sort.SliceStable(pools, func(i, j int) bool {
+		return pools[i].utilization() > pools[j].utilization()
+	})
+
+	for _, src := range pools {
+		for i := len(pools) - 1; i >= 0; i-- {
+			dst := pools[i]
+			diff := src.utilization() - dst.utilization()
+			if diff <= 0 {
+				break
+			}
+
+			var best *candidate
+			bestDiff := diff
+			for _, c := range candidates {
+				if c.moved || c.node != src.node.Name || c.pool != src.name || c.size > dst.free {
+					continue
+				}
+
+				if slices.Contains(replicas[c.resource], dst.node.Name) || !allowed(dst.node, src.node, replicas[c.resource], nodes, filters[c.resource]) {
+					continue
+				}
+
+				srcAfter := float64(src.total-src.free-c.size) / float64(src.total)
+				dstAfter := float64(dst.total-dst.free+c.size) / float64(dst.total)
+				if d := math.Abs(srcAfter - dstAfter); d < bestDiff {
+					best, bestDiff = c, d
+				}
+			}
+
+			if best == nil {
+				continue
+			}
+
+			best.moved = true
+			src.free += best.size
+			dst.free -= best.size
+			replicas[best.resource] = append(without(replicas[best.resource], src.node.Name), dst.node.Name)
+
+			return Move{Resource: best.resource, FromNode: src.node.Name, ToNode: dst.node.Name, StoragePool: src.name, SizeKib: best.size}, true
+		}
+	}
+
+	return Move{}, false
+}
+
+// allowed checks the replica constraints of the filter for moving the replica on src to dst.
+func allowed(dst, src client.Node, replicaNodes []string, nodes map[string]client.Node, filter client.AutoSelectFilter) bool {
+	var others []map[string]string
+	for _, name := range replicaNodes {
+		if name != src.Name {
+			others = append(others, nodes[name].Props)
+		}
+	}
+
+	return placement.ReplicasAllowed(filter, dst.Props, others)
+}
+
+func imbalance(pools []*pool) float64 {
+	if len(pools) == 0 {
+		return 0
+	}
+
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, p := range pools {
+		u := p.utilization()
+		lowest = math.Min(lowest, u)
+		highest = math.Max(highest, u)
+	}
+
+	return highest - lowest
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func without(list []string, s string) []string {
+	result := make([]string, 0, len(list))
+	for _, l := range list {
+		if l != s {
+			result = append(result, l)
+		}
+	}
+
+	return result
+}
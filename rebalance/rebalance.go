@@ -0,0 +1,269 @@
+package rebalance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+)
+
+// ErrOutsideWindow is returned by Run if a move would start outside of all maintenance windows.
+var ErrOutsideWindow = errors.New("outside of maintenance windows")
+
+// Window is a recurring maintenance window. Times are compared in the location of the time passed to Contains.
+type Window struct {
+	// Days on which the window starts. Empty means every day.
+	Days []time.Weekday
+	// Start is the offset of the start from midnight.
+	Start time.Duration
+	// Duration of the window. Windows may extend past midnight.
+	Duration time.Duration
+}
+
+// Contains returns true if t is inside the window.
+func (w Window) Contains(t time.Time) bool {
+	for _, offset := range []int{0, -1} {
+		day := t.AddDate(0, 0, offset)
+		midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, t.Location())
+		if len(w.Days) > 0 && !slices.Contains(w.Days, midnight.Weekday()) {
+			continue
+		}
+
+		start := midnight.Add(w.Start)
+		if !t.Before(start) && t.Before(start.Add(w.Duration)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Status is the state of a move while the Rebalancer runs.
+type Status string
+
+const (
+	// StatusSkipped is reported for moves of resources that were put in use after planning.
+	StatusSkipped Status = "skipped"
+	// StatusSyncing is reported once the target replica is diskful and synchronizing.
+	StatusSyncing Status = "syncing"
+	StatusDone    Status = "done"
+)
+
+// Event reports the progress of a move.
+type Event struct {
+	Move   Move
+	Status Status
+}
+
+// Rebalancer plans and runs moves that even out storage pool utilization.
+type Rebalancer struct {
+	Nodes               client.NodeProvider
+	Resources           client.ResourceProvider
+	ResourceDefinitions client.ResourceDefinitionProvider
+	ResourceGroups      client.ResourceGroupProvider
+	PlanOpts            PlanOpts
+	// Windows restricts when moves may start. A running move is always completed. Empty means no restriction.
+	Windows []Window
+	// DryRun only computes the plan.
+	DryRun bool
+	// Progress is called whenever a move changes its status. Optional.
+	Progress func(Event)
+	// Interval between polls while waiting for a sync. Defaults to 5 seconds.
+	Interval time.Duration
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// New returns a Rebalancer using the services of the given client.
+func New(c *client.Client) *Rebalancer {
+	return &Rebalancer{
+		Nodes:               c.Nodes,
+		Resources:           c.Resources,
+		ResourceDefinitions: c.ResourceDefinitions,
+		ResourceGroups:      c.ResourceGroups,
+	}
+}
+
+// Collect fetches the cluster state.
+func (r *Rebalancer) Collect(ctx context.Context) (*State, error) {
+	nodes, err := r.Nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	pools, err := r.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage pools: %w", err)
+	}
+
+	rscs, err := r.Resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resources: %w", err)
+	}
+
+	rgs, err := r.ResourceGroups.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource groups: %w", err)
+	}
+
+	byGroup := make(map[string]client.AutoSelectFilter, len(rgs))
+	for _, rg := range rgs {
+		byGroup[rg.Name] = rg.SelectFilter
+	}
+
+	rds, err := r.ResourceDefinitions.GetAll(ctx, client.RDGetAllRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource definitions: %w", err)
+	}
+
+	filters := make(map[string]client.AutoSelectFilter, len(rds))
+	for _, rd := range rds {
+		filters[rd.Name] = byGroup[rd.ResourceGroupName]
+	}
+
+	return &State{Nodes: nodes, StoragePools: pools, Resources: rscs, SelectFilters: filters}, nil
+}
+
+// Plan computes a plan from the current cluster state.
+func (r *Rebalancer) Plan(ctx context.Context) (*Plan, error) {
+	s, err := r.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return Compute(s, r.PlanOpts), nil
+}
+
+// Run computes a plan and, unless DryRun is set, runs its moves one at a time.
+//
+// Every move makes the replica on the target node diskful, creating a diskless replica first if needed, waits
+// until the target is up to date and then deletes the replica on the source node. Before a move starts, Run checks
+// the maintenance windows and that the resource is still not in use. Run stops at the first error. If it fails
+// while waiting for a sync, the resource keeps the additional replica. The plan is always returned.
+func (r *Rebalancer) Run(ctx context.Context) (*Plan, error) {
+	plan, err := r.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.DryRun {
+		return plan, nil
+	}
+
+	for _, move := range plan.Moves {
+		if !r.inWindow() {
+			return plan, ErrOutsideWindow
+		}
+
+		err := r.move(ctx, move)
+		if err != nil {
+			return plan, fmt.Errorf("failed to move '%s' from '%s' to '%s': %w", move.Resource, move.FromNode, move.ToNode, err)
+		}
+	}
+
+	return plan, nil
+}
+
+func (r *Rebalancer) inWindow() bool {
+	if len(r.Windows) == 0 {
+		return true
+	}
+
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+
+	t := now()
+	for _, w := range r.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rebalancer) move(ctx context.Context, move Move) error {
+	rscs, err := r.Resources.GetResourceView(ctx, &client.ListOpts{Resource: []string{move.Resource}})
+	if err != nil {
+		return err
+	}
+
+	for _, rsc := range rscs {
+		if rsc.Name == move.Resource && rsc.State != nil && rsc.State.InUse != nil && *rsc.State.InUse {
+			r.report(Event{Move: move, Status: StatusSkipped})
+			return nil
+		}
+	}
+
+	_, err = r.Resources.Get(ctx, move.Resource, move.ToNode)
+	if errors.Is(err, client.NotFoundError) {
+		err = r.Resources.Create(ctx, client.ResourceCreate{Resource: client.Resource{
+			Name:     move.Resource,
+			NodeName: move.ToNode,
+			Flags:    []string{linstor.FlagDiskless},
+		}})
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.Resources.Diskful(ctx, move.Resource, move.ToNode, move.StoragePool, nil)
+	if err != nil {
+		return err
+	}
+
+	r.report(Event{Move: move, Status: StatusSyncing})
+
+	err = r.wait(ctx, move)
+	if err != nil {
+		return err
+	}
+
+	err = r.Resources.Delete(ctx, move.Resource, move.FromNode)
+	if err != nil {
+		return err
+	}
+
+	r.report(Event{Move: move, Status: StatusDone})
+
+	return nil
+}
+
+func (r *Rebalancer) wait(ctx context.Context, move Move) error {
+	interval := r.Interval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		vols, err := r.Resources.GetVolumes(ctx, move.Resource, move.ToNode)
+		if err != nil {
+			return err
+		}
+
+		if client.VolumesUpToDate(vols) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for sync: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Rebalancer) report(ev Event) {
+	if r.Progress != nil {
+		r.Progress(ev)
+	}
+}
@@ -0,0 +1,247 @@
+package rebalance_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/rebalance"
+)
+
+func replica(res, node string, sizeKib int64, flags ...string) client.ResourceWithVolumes {
+	return client.ResourceWithVolumes{
+		Resource: client.Resource{Name: res, NodeName: node, Flags: flags},
+		Volumes: []client.Volume{{
+			StoragePoolName:  "data",
+			AllocatedSizeKib: sizeKib,
+			State:            client.VolumeState{DiskState: "UpToDate"},
+		}},
+	}
+}
+
+func newState() *rebalance.State {
+	inUse := true
+	r4 := replica("r4", "n1", 50)
+	r4.State = &client.ResourceState{InUse: &inUse}
+
+	node := func(name, site string) client.Node {
+		return client.Node{Name: name, ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/site": site}}
+	}
+
+	pool := func(node string, free int64) client.StoragePool {
+		return client.StoragePool{StoragePoolName: "data", NodeName: node, ProviderKind: client.LVM, TotalCapacity: 1000, FreeCapacity: free}
+	}
+
+	return &rebalance.State{
+		Nodes: []client.Node{node("n1", "a"), node("n2", "b"), node("n3", "c"), node("n4", "d")},
+		StoragePools: []client.StoragePool{
+			pool("n1", 100),
+			pool("n2", 900),
+			pool("n3", 500),
+			{StoragePoolName: "data", NodeName: "n4", ProviderKind: client.LVM},
+			{StoragePoolName: "DfltDisklessStorPool", NodeName: "n2", ProviderKind: client.DISKLESS},
+		},
+		Resources: []client.ResourceWithVolumes{
+			replica("r1", "n1", 200),
+			replica("r1", "n3", 200),
+			replica("r2", "n1", 300),
+			replica("r2", "n2", 10, linstor.FlagDiskless),
+			replica("r3", "n1", 100),
+			r4,
+		},
+		SelectFilters: map[string]client.AutoSelectFilter{
+			"r1": {ReplicasOnDifferent: []string{"Aux/site"}},
+		},
+	}
+}
+
+func TestCompute(t *testing.T) {
+	plan := rebalance.Compute(newState(), rebalance.PlanOpts{})
+	assert.Equal(t, []rebalance.Move{
+		{Resource: "r2", FromNode: "n1", ToNode: "n2", StoragePool: "data", SizeKib: 300},
+		{Resource: "r3", FromNode: "n1", ToNode: "n2", StoragePool: "data", SizeKib: 100},
+	}, plan.Moves)
+	require.Len(t, plan.Groups, 1)
+	assert.InDelta(t, 0.8, plan.Groups[0].Before, 1e-9)
+	assert.InDelta(t, 0, plan.Groups[0].After, 1e-9)
+
+	var buf bytes.Buffer
+	require.NoError(t, plan.WriteText(&buf))
+	assert.Equal(t, "move r2 from n1 to n2 (pool data, 300 KiB)\n"+
+		"move r3 from n1 to n2 (pool data, 100 KiB)\n"+
+		"pool data: imbalance 80.0% -> 0.0%\n", buf.String())
+
+	// The threshold is already met after the first move.
+	plan = rebalance.Compute(newState(), rebalance.PlanOpts{Threshold: 0.25})
+	assert.Len(t, plan.Moves, 1)
+
+	// With only r1 left, neither replica may go to n2, as it is on the same site as the other replica.
+	s := newState()
+	s.Nodes[1].Props["Aux/site"] = "a"
+	s.Nodes[2].Props["Aux/site"] = "a"
+	s.Resources = s.Resources[:2]
+	plan = rebalance.Compute(s, rebalance.PlanOpts{})
+	assert.Empty(t, plan.Moves)
+
+	buf.Reset()
+	require.NoError(t, plan.WriteText(&buf))
+	assert.Equal(t, "pool data: imbalance 80.0% -> 80.0%\nno moves needed\n", buf.String())
+}
+
+func TestWindow(t *testing.T) {
+	w := rebalance.Window{Days: []time.Weekday{time.Saturday}, Start: 22 * time.Hour, Duration: 4 * time.Hour}
+
+	// 2024-06-01 is a Saturday.
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 6, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	assert.False(t, w.Contains(at(1, 21)))
+	assert.True(t, w.Contains(at(1, 22)))
+	assert.True(t, w.Contains(at(2, 1)))
+	assert.False(t, w.Contains(at(2, 2)))
+	assert.False(t, w.Contains(at(2, 22)))
+}
+
+type cluster struct {
+	state   *rebalance.State
+	polls   int
+	calls   []string
+	inUseOn string
+}
+
+type fakeNodes struct {
+	client.NodeProvider
+	c *cluster
+}
+
+func (f *fakeNodes) GetAll(context.Context, ...*client.ListOpts) ([]client.Node, error) {
+	return f.c.state.Nodes, nil
+}
+
+func (f *fakeNodes) GetStoragePoolView(context.Context, ...*client.ListOpts) ([]client.StoragePool, error) {
+	return f.c.state.StoragePools, nil
+}
+
+type fakeResources struct {
+	client.ResourceProvider
+	c *cluster
+}
+
+func (f *fakeResources) GetResourceView(_ context.Context, opts ...*client.ListOpts) ([]client.ResourceWithVolumes, error) {
+	if len(opts) == 0 {
+		return f.c.state.Resources, nil
+	}
+
+	var result []client.ResourceWithVolumes
+	for _, rsc := range f.c.state.Resources {
+		if rsc.Name == opts[0].Resource[0] {
+			if rsc.Name == f.c.inUseOn {
+				inUse := true
+				rsc.State = &client.ResourceState{InUse: &inUse}
+			}
+			result = append(result, rsc)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *fakeResources) Get(_ context.Context, res, node string, _ ...*client.ListOpts) (client.Resource, error) {
+	for _, rsc := range f.c.state.Resources {
+		if rsc.Name == res && rsc.NodeName == node {
+			return rsc.Resource, nil
+		}
+	}
+
+	return client.Resource{}, client.NotFoundError
+}
+
+func (f *fakeResources) Create(_ context.Context, create client.ResourceCreate) error {
+	f.c.calls = append(f.c.calls, "create "+create.Resource.Name+" on "+create.Resource.NodeName)
+	return nil
+}
+
+func (f *fakeResources) Diskful(_ context.Context, res, node, pool string, _ *client.ToggleDiskDiskfulProps) error {
+	f.c.calls = append(f.c.calls, "diskful "+res+" on "+node+" in "+pool)
+	f.c.polls = 0
+	return nil
+}
+
+func (f *fakeResources) GetVolumes(context.Context, string, string, ...*client.ListOpts) ([]client.Volume, error) {
+	f.c.polls++
+	state := "Inconsistent"
+	if f.c.polls > 1 {
+		state = "UpToDate"
+	}
+
+	return []client.Volume{{State: client.VolumeState{DiskState: state}}}, nil
+}
+
+func (f *fakeResources) Delete(_ context.Context, res, node string, _ ...*client.ResourceDeleteOpts) error {
+	f.c.calls = append(f.c.calls, "delete "+res+" on "+node)
+	return nil
+}
+
+type fakeRDs struct {
+	client.ResourceDefinitionProvider
+}
+
+func (f *fakeRDs) GetAll(context.Context, client.RDGetAllRequest) ([]client.ResourceDefinitionWithVolumeDefinition, error) {
+	return nil, nil
+}
+
+type fakeRGs struct {
+	client.ResourceGroupProvider
+}
+
+func (f *fakeRGs) GetAll(context.Context, ...*client.ListOpts) ([]client.ResourceGroup, error) {
+	return nil, nil
+}
+
+func TestRun(t *testing.T) {
+	c := &cluster{state: newState()}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var events []rebalance.Status
+	r := &rebalance.Rebalancer{
+		Nodes:               &fakeNodes{c: c},
+		Resources:           &fakeResources{c: c},
+		ResourceDefinitions: &fakeRDs{},
+		ResourceGroups:      &fakeRGs{},
+		Windows:             []rebalance.Window{{Start: 22 * time.Hour, Duration: time.Hour}},
+		DryRun:              true,
+		Interval:            time.Millisecond,
+		Now:                 func() time.Time { return now },
+		Progress: func(ev rebalance.Event) {
+			events = append(events, ev.Status)
+		},
+	}
+
+	plan, err := r.Run(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, plan.Moves, 2)
+	assert.Empty(t, c.calls)
+
+	r.DryRun = false
+	_, err = r.Run(context.Background())
+	assert.True(t, errors.Is(err, rebalance.ErrOutsideWindow), err)
+	assert.Empty(t, c.calls)
+
+	now = now.Add(10 * time.Hour)
+	c.inUseOn = "r3"
+	_, err = r.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"diskful r2 on n2 in data",
+		"delete r2 on n1",
+	}, c.calls)
+	assert.Equal(t, []rebalance.Status{rebalance.StatusSyncing, rebalance.StatusDone, rebalance.StatusSkipped}, events)
+	assert.Equal(t, 2, c.polls)
+}
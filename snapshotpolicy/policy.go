@@ -0,0 +1,371 @@
+// Package snapshotpolicy creates and expires snapshots according to client-side policies.
+//
+// Unlike LINSTOR's own schedules, policies are evaluated by the caller, for example an operator, and can select
+// resource definitions by resource group or by properties.
+//
+// Snapshot names are derived from the scheduled time, so multiple replicas running the same policies concurrently
+// agree on the snapshot to create. Conflicts between them are ignored: a snapshot that already exists was created
+// by another replica, a snapshot that is already gone was deleted by another replica.
+package snapshotpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+)
+
+const (
+	// KeyPolicy is the snapshot definition property that stores the name of the policy that created a snapshot.
+	// Retention only applies to snapshots created by the same policy.
+	KeyPolicy = linstor.NamespcAuxiliary + "/SnapshotPolicy"
+	// KeyPrefix is the resource definition property that overrides the snapshot prefix, same as for LINSTOR's
+	// auto snapshots.
+	KeyPrefix = linstor.NamespcAutoSnapshot + "/" + linstor.KeyAutoSnapshotPrefix
+
+	// DefaultPrefix is used if neither the policy nor the resource definition set a prefix.
+	DefaultPrefix = "snap-"
+
+	timeFormat = "20060102T1504"
+)
+
+// Policy describes when to take snapshots of which resource definitions and how long to keep them.
+type Policy struct {
+	// Name identifies the policy. It must be unique and stable, as it is stored on every snapshot.
+	Name string
+	// Schedule is a cron expression, see ParseCron.
+	Schedule string
+	// ResourceGroup selects all resource definitions of the resource group. Optional.
+	ResourceGroup string
+	// Selector selects resource definitions with matching properties. Optional.
+	Selector map[string]string
+	// Prefix of the snapshot names. If empty, the resource definition's KeyPrefix property is used, then
+	// DefaultPrefix.
+	Prefix string
+	// KeepCount is the number of snapshots to keep. 0 means no limit.
+	KeepCount int
+	// MaxAge is the maximum age of snapshots. 0 means no limit. A missed snapshot is not taken late if it would
+	// already be older than MaxAge.
+	MaxAge time.Duration
+	// Location is the time zone of Schedule. Defaults to UTC.
+	Location *time.Location
+
+	cron *Cron
+}
+
+// Engine evaluates snapshot policies.
+type Engine struct {
+	Resources           client.ResourceProvider
+	ResourceDefinitions client.ResourceDefinitionProvider
+	Policies            []Policy
+	// OnError is called for errors during Run. Optional.
+	OnError func(err error)
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewEngine returns an Engine for the given policies, using the services of the client.
+func NewEngine(c *client.Client, policies ...Policy) (*Engine, error) {
+	e := &Engine{
+		Resources:           c.Resources,
+		ResourceDefinitions: c.ResourceDefinitions,
+		Policies:            policies,
+	}
+
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *Engine) validate() error {
+	names := make(map[string]bool, len(e.Policies))
+	for i := range e.Policies {
+		p := &e.Policies[i]
+		if p.Name == "" {
+			return errors.New("snapshot policy name is required")
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("snapshot policy '%s' is defined more than once", p.Name)
+		}
+		names[p.Name] = true
+
+		if p.ResourceGroup == "" && len(p.Selector) == 0 {
+			return fmt.Errorf("snapshot policy '%s' needs a resource group or a selector", p.Name)
+		}
+
+		if p.KeepCount < 0 || p.MaxAge < 0 {
+			return fmt.Errorf("snapshot policy '%s': retention must not be negative", p.Name)
+		}
+
+		cron, err := ParseCron(p.Schedule)
+		if err != nil {
+			return fmt.Errorf("snapshot policy '%s': %w", p.Name, err)
+		}
+		p.cron = cron
+	}
+
+	return nil
+}
+
+// Run calls RunOnce every interval until the context is cancelled. The interval should be shorter than the
+// smallest interval of the policies' schedules, a minute is a good choice. Defaults to 5 seconds.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.RunOnce(ctx); err != nil && e.OnError != nil {
+			e.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce creates the snapshots for the most recent scheduled time of every policy, if they do not exist yet,
+// and deletes expired snapshots.
+//
+// Errors for single resource definitions do not stop processing of the others, they are returned together.
+func (e *Engine) RunOnce(ctx context.Context) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	now := time.Now
+	if e.Now != nil {
+		now = e.Now
+	}
+
+	var errs []error
+	for i := range e.Policies {
+		p := &e.Policies[i]
+
+		rds, err := e.selectResourceDefinitions(ctx, p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("snapshot policy '%s': %w", p.Name, err))
+			continue
+		}
+
+		for _, rd := range rds {
+			if err := e.apply(ctx, p, rd, now()); err != nil {
+				errs = append(errs, fmt.Errorf("snapshot policy '%s', resource definition '%s': %w", p.Name, rd.Name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (e *Engine) selectResourceDefinitions(ctx context.Context, p *Policy) ([]client.ResourceDefinitionWithVolumeDefinition, error) {
+	req := client.RDGetAllRequest{}
+	for k, v := range p.Selector {
+		req.Props = append(req.Props, k+"="+v)
+	}
+	sort.Strings(req.Props)
+
+	rds, err := e.ResourceDefinitions.GetAll(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []client.ResourceDefinitionWithVolumeDefinition
+	for _, rd := range rds {
+		if p.matches(&rd.ResourceDefinition) {
+			result = append(result, rd)
+		}
+	}
+
+	return result, nil
+}
+
+func (p *Policy) matches(rd *client.ResourceDefinition) bool {
+	if p.ResourceGroup != "" && rd.ResourceGroupName != p.ResourceGroup {
+		return false
+	}
+
+	for k, v := range p.Selector {
+		if rd.Props[k] != v {
+			return false
+		}
+	}
+
+	for _, flag := range rd.Flags {
+		if flag == linstor.FlagDelete {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *Policy) prefix(rd *client.ResourceDefinition) string {
+	if p.Prefix != "" {
+		return p.Prefix
+	}
+
+	if prefix := rd.Props[KeyPrefix]; prefix != "" {
+		return prefix
+	}
+
+	return DefaultPrefix
+}
+
+// SnapshotName returns the name of the snapshot for the given scheduled time.
+func (p *Policy) SnapshotName(rd *client.ResourceDefinition, scheduled time.Time) string {
+	return p.prefix(rd) + scheduled.UTC().Format(timeFormat)
+}
+
+func (e *Engine) apply(ctx context.Context, p *Policy, rd client.ResourceDefinitionWithVolumeDefinition, now time.Time) error {
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	snaps, err := e.Resources.GetSnapshots(ctx, rd.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var errs []error
+	scheduled := p.cron.Prev(now.In(loc))
+	// A snapshot that would already be expired would be deleted again right away, and recreated on the next run.
+	if !scheduled.IsZero() && (p.MaxAge == 0 || now.Sub(scheduled) <= p.MaxAge) {
+		name := p.SnapshotName(&rd.ResourceDefinition, scheduled)
+		if !containsSnapshot(snaps, name) {
+			err := e.Resources.CreateSnapshot(ctx, client.Snapshot{
+				Name:                    name,
+				ResourceName:            rd.Name,
+				SnapshotDefinitionProps: map[string]string{KeyPolicy: p.Name},
+			})
+			if err != nil && !client.IsApiCallError(err, linstor.FailExistsSnapshotDfn) && !client.IsApiCallError(err, linstor.FailExistsSnapshot) {
+				errs = append(errs, fmt.Errorf("failed to create snapshot '%s': %w", name, err))
+			}
+		}
+	}
+
+	for _, snap := range p.expired(snaps, now) {
+		err := e.Resources.DeleteSnapshot(ctx, rd.Name, snap.Name)
+		if err != nil && !errors.Is(err, client.NotFoundError) && !client.IsApiCallError(err, linstor.FailNotFoundSnapshotDfn) {
+			errs = append(errs, fmt.Errorf("failed to delete snapshot '%s': %w", snap.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// expired returns the snapshots created by the policy that should be deleted. Snapshots in use are never
+// returned, but still count towards KeepCount. Failed snapshots do not count towards KeepCount, they are only
+// deleted once they are older than MaxAge.
+func (p *Policy) expired(snaps []client.Snapshot, now time.Time) []client.Snapshot {
+	var own, failed []client.Snapshot
+	for _, snap := range snaps {
+		if snap.SnapshotDefinitionProps[KeyPolicy] != p.Name {
+			continue
+		}
+
+		if Failed(snap) {
+			failed = append(failed, snap)
+		} else {
+			own = append(own, snap)
+		}
+	}
+
+	// Newest first.
+	sort.SliceStable(own, func(i, j int) bool {
+		return createTime(own[i]).After(createTime(own[j]))
+	})
+
+	tooOld := func(snap client.Snapshot) bool {
+		created := createTime(snap)
+		return p.MaxAge > 0 && !created.IsZero() && now.Sub(created) > p.MaxAge
+	}
+
+	var result []client.Snapshot
+	for i, snap := range own {
+		tooMany := p.KeepCount > 0 && i >= p.KeepCount
+		if (tooMany || tooOld(snap)) && !InUse(snap) {
+			result = append(result, snap)
+		}
+	}
+
+	for _, snap := range failed {
+		if tooOld(snap) && !InUse(snap) {
+			result = append(result, snap)
+		}
+	}
+
+	return result
+}
+
+// Failed returns true if the snapshot could not be created.
+func Failed(snap client.Snapshot) bool {
+	return slices.Contains(snap.Flags, linstor.FlagFailedDeployment) || slices.Contains(snap.Flags, linstor.FlagFailedDisconnect)
+}
+
+// InUse returns true if the snapshot is still being created, or is used by a shipping, a backup or a restore.
+func InUse(snap client.Snapshot) bool {
+	busy := func(flags []string) bool {
+		for _, flag := range flags {
+			switch flag {
+			case linstor.FlagShipping, linstor.FlagShippingCleanup, linstor.FlagBackupSource, linstor.FlagBackupTarget, linstor.FlagRestoreBackupOnSuccess:
+				return true
+			}
+		}
+		return false
+	}
+
+	if busy(snap.Flags) {
+		return true
+	}
+
+	// Still being created.
+	if !slices.Contains(snap.Flags, linstor.FlagSuccessful) && !Failed(snap) {
+		return true
+	}
+
+	for _, node := range snap.Snapshots {
+		if busy(node.Flags) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createTime returns the earliest creation time of the snapshot on any node.
+func createTime(snap client.Snapshot) time.Time {
+	var t time.Time
+	for _, node := range snap.Snapshots {
+		if node.CreateTimestamp != nil && (t.IsZero() || node.CreateTimestamp.Before(t)) {
+			t = node.CreateTimestamp.Time
+		}
+	}
+
+	return t
+}
+
+func containsSnapshot(snaps []client.Snapshot, name string) bool {
+	for _, snap := range snaps {
+		if snap.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,214 @@
+package snapshotpolicy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/golinstor/snapshotpolicy"
+)
+
+func TestCronPrev(t *testing.T) {
+	at := time.Date(2024, 3, 15, 10, 37, 12, 0, time.UTC) // a Friday
+
+	cases := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 15, 10, 37, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 3, 15, 3, 0, 0, 0, time.UTC)},
+		{"30 22 * * *", time.Date(2024, 3, 14, 22, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,20 * *", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 1-2 *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Either day field may match if both are restricted.
+		{"0 0 1 * 4", time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+
+	for _, tcase := range cases {
+		t.Run(tcase.spec, func(t *testing.T) {
+			cron, err := snapshotpolicy.ParseCron(tcase.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tcase.expected, cron.Prev(at))
+		})
+	}
+
+	for _, invalid := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := snapshotpolicy.ParseCron(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+type fakeResources struct {
+	client.ResourceProvider
+	snaps   map[string][]client.Snapshot
+	created []client.Snapshot
+	deleted []string
+}
+
+func (f *fakeResources) GetSnapshots(_ context.Context, resName string, _ ...*client.ListOpts) ([]client.Snapshot, error) {
+	return f.snaps[resName], nil
+}
+
+func (f *fakeResources) CreateSnapshot(_ context.Context, snapshot client.Snapshot) error {
+	f.created = append(f.created, snapshot)
+	return nil
+}
+
+func (f *fakeResources) DeleteSnapshot(_ context.Context, resName, snapName string, _ ...string) error {
+	f.deleted = append(f.deleted, resName+"/"+snapName)
+	return nil
+}
+
+type fakeDefinitions struct {
+	client.ResourceDefinitionProvider
+	rds []client.ResourceDefinitionWithVolumeDefinition
+}
+
+func (f *fakeDefinitions) GetAll(_ context.Context, _ client.RDGetAllRequest) ([]client.ResourceDefinitionWithVolumeDefinition, error) {
+	return f.rds, nil
+}
+
+func snap(name string, created time.Time, flags ...string) client.Snapshot {
+	return client.Snapshot{
+		Name:                    name,
+		Flags:                   append([]string{linstor.FlagSuccessful}, flags...),
+		SnapshotDefinitionProps: map[string]string{snapshotpolicy.KeyPolicy: "hourly"},
+		Snapshots:               []client.SnapshotNode{{CreateTimestamp: &client.TimeStampMs{Time: created}}},
+	}
+}
+
+func TestEngine(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 37, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return now.Add(-time.Duration(h) * time.Hour) }
+
+	resources := &fakeResources{snaps: map[string][]client.Snapshot{
+		"rsc1": {
+			snap("snap-20240315T0900", hour(1)),
+			snap("snap-20240315T0800", hour(2)),
+			snap("snap-20240315T0700", hour(3), linstor.FlagShipping),
+			snap("snap-20240315T0600", hour(4)),
+			{Name: "manual", Snapshots: []client.SnapshotNode{{CreateTimestamp: &client.TimeStampMs{Time: hour(100)}}}},
+		},
+		"rsc2": {snap("custom-20240315T1000", hour(0))},
+	}}
+
+	definitions := &fakeDefinitions{rds: []client.ResourceDefinitionWithVolumeDefinition{
+		{ResourceDefinition: client.ResourceDefinition{Name: "rsc1", ResourceGroupName: "rg"}},
+		{ResourceDefinition: client.ResourceDefinition{Name: "rsc2", ResourceGroupName: "rg", Props: map[string]string{snapshotpolicy.KeyPrefix: "custom-"}}},
+		{ResourceDefinition: client.ResourceDefinition{Name: "rsc3", ResourceGroupName: "other"}},
+	}}
+
+	engine := &snapshotpolicy.Engine{
+		Resources:           resources,
+		ResourceDefinitions: definitions,
+		Policies:            []snapshotpolicy.Policy{{Name: "hourly", Schedule: "0 * * * *", ResourceGroup: "rg", KeepCount: 2}},
+		Now:                 func() time.Time { return now },
+	}
+
+	require.NoError(t, engine.RunOnce(context.Background()))
+
+	require.Len(t, resources.created, 1)
+	assert.Equal(t, "snap-20240315T1000", resources.created[0].Name)
+	assert.Equal(t, "rsc1", resources.created[0].ResourceName)
+	assert.Equal(t, "hourly", resources.created[0].SnapshotDefinitionProps[snapshotpolicy.KeyPolicy])
+
+	// The shipping snapshot and the manual snapshot are kept.
+	assert.Equal(t, []string{"rsc1/snap-20240315T0600"}, resources.deleted)
+
+	engine.Policies[0].KeepCount = 0
+	engine.Policies[0].MaxAge = 90 * time.Minute
+	resources.deleted = nil
+	require.NoError(t, engine.RunOnce(context.Background()))
+	assert.Equal(t, []string{"rsc1/snap-20240315T0800", "rsc1/snap-20240315T0600"}, resources.deleted)
+}
+
+func TestEngineFailedSnapshots(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 37, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return now.Add(-time.Duration(h) * time.Hour) }
+	withFlags := func(s client.Snapshot, flags ...string) client.Snapshot {
+		s.Flags = flags
+		return s
+	}
+
+	resources := &fakeResources{snaps: map[string][]client.Snapshot{
+		"rsc1": {
+			snap("snap-20240315T1000", hour(0)),
+			withFlags(snap("snap-20240315T0900", hour(1)), linstor.FlagFailedDeployment),
+			snap("snap-20240315T0800", hour(2)),
+			withFlags(snap("snap-20240315T0600", hour(4)), linstor.FlagFailedDisconnect),
+			withFlags(snap("snap-20240315T0500", hour(5))),
+		},
+	}}
+
+	engine := &snapshotpolicy.Engine{
+		Resources: resources,
+		ResourceDefinitions: &fakeDefinitions{rds: []client.ResourceDefinitionWithVolumeDefinition{
+			{ResourceDefinition: client.ResourceDefinition{Name: "rsc1", ResourceGroupName: "rg"}},
+		}},
+		Policies: []snapshotpolicy.Policy{{Name: "hourly", Schedule: "0 * * * *", ResourceGroup: "rg", KeepCount: 2, MaxAge: 3 * time.Hour}},
+		Now:      func() time.Time { return now },
+	}
+
+	require.NoError(t, engine.RunOnce(context.Background()))
+	assert.Empty(t, resources.created)
+	// The failed snapshot at 09:00 does not push 08:00 out of KeepCount, the failed snapshot at 06:00 is too old.
+	// The snapshot at 05:00 is still being created and is kept.
+	assert.Equal(t, []string{"rsc1/snap-20240315T0600"}, resources.deleted)
+}
+
+func TestEngineMaxAgeShorterThanPeriod(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 37, 0, 0, time.UTC)
+
+	resources := &fakeResources{snaps: map[string][]client.Snapshot{}}
+	engine := &snapshotpolicy.Engine{
+		Resources: resources,
+		ResourceDefinitions: &fakeDefinitions{rds: []client.ResourceDefinitionWithVolumeDefinition{
+			{ResourceDefinition: client.ResourceDefinition{Name: "rsc1", ResourceGroupName: "rg"}},
+		}},
+		Policies: []snapshotpolicy.Policy{{Name: "hourly", Schedule: "0 * * * *", ResourceGroup: "rg", MaxAge: 30 * time.Minute}},
+		Now:      func() time.Time { return now },
+	}
+
+	// The snapshot for 10:00 would already be expired.
+	require.NoError(t, engine.RunOnce(context.Background()))
+	assert.Empty(t, resources.created)
+
+	now = time.Date(2024, 3, 15, 11, 5, 0, 0, time.UTC)
+	require.NoError(t, engine.RunOnce(context.Background()))
+	require.Len(t, resources.created, 1)
+	assert.Equal(t, "snap-20240315T1100", resources.created[0].Name)
+}
+
+func TestNewEngineValidation(t *testing.T) {
+	cl, err := client.NewClient()
+	require.NoError(t, err)
+
+	_, err = snapshotpolicy.NewEngine(cl, snapshotpolicy.Policy{Name: "p", Schedule: "@daily"})
+	assert.Error(t, err)
+	_, err = snapshotpolicy.NewEngine(cl, snapshotpolicy.Policy{Name: "p", Schedule: "bogus", ResourceGroup: "rg"})
+	assert.Error(t, err)
+	_, err = snapshotpolicy.NewEngine(cl, snapshotpolicy.Policy{Name: "p", Schedule: "@daily", ResourceGroup: "rg"})
+	assert.NoError(t, err)
+}
+
+func TestEngineRunDefaultInterval(t *testing.T) {
+	engine := &snapshotpolicy.Engine{
+		Resources:           &fakeResources{},
+		ResourceDefinitions: &fakeDefinitions{},
+		Policies:            []snapshotpolicy.Policy{{Name: "hourly", Schedule: "0 * * * *", ResourceGroup: "rg"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, engine.Run(ctx, 0), context.Canceled)
+}